@@ -0,0 +1,71 @@
+// auditverify - checks the hash chain of the audit files written by the server
+//
+// usage:
+//
+//	auditverify [-k key] audit.log
+//	auditverify [-k key] audit.log.20250101T000000.000000000.gz audit.log
+//
+// a single path is checked with its rotated segments, several paths are checked in the given order
+// the first broken link, missing entries or bad signature is reported with the file and line
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+)
+
+func main() {
+	key := flag.String("k", os.Getenv("KEY"), "key the entries were signed with, empty skips the hmac check")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-k key] audit-file [audit-file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	report, err := verify(flag.Args(), []byte(*key))
+	if err != nil {
+		var chainErr *observer.ChainError
+		if errors.As(err, &chainErr) {
+			fmt.Printf("FAIL %v\n", chainErr)
+			fmt.Printf("checked %d entries before the failure\n", report.Entries)
+		} else {
+			fmt.Printf("couldn't verify the audit files: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	if report.Entries == 0 {
+		fmt.Printf("OK no entries in %d files\n", report.Files)
+		return
+	}
+
+	fmt.Printf("OK %d entries in %d files, seq %d to %d\n", report.Entries, report.Files, report.FirstSeq, report.LastSeq)
+	if report.FirstSeq > 1 {
+		fmt.Printf("entries before seq %d are not in the checked files\n", report.FirstSeq)
+	}
+}
+
+// verify - checks a single audit file with its segments or several files in order
+func verify(paths []string, key []byte) (observer.ChainReport, error) {
+	if len(paths) == 1 {
+		return observer.VerifyChain(paths[0], key)
+	}
+
+	v := observer.NewChainVerifier(key)
+	for _, path := range paths {
+		if err := v.VerifyFile(path); err != nil {
+			return v.Report(), err
+		}
+	}
+
+	return v.Report(), nil
+}
@@ -3,60 +3,276 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math"
 
 	"github.com/makimaki04/go-metrics-agent.git/internal/config"
+	"github.com/makimaki04/go-metrics-agent.git/internal/handler"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
 )
 
 type Config struct {
-	Address     string `json:"address" env:"ADDRESS"`
-	StoreInt    int    `json:"store_interval" env:"STORE_INTERVAL"`
-	FilePath    string `env:"FILE_STORAGE_PATH"`
-	Restore     bool   `json:"restore" env:"RESTORE"`
-	DSN         string `json:"database_dsn" env:"DATABASE_DSN"`
-	KEY         string `env:"KEY"`
-	AuditFile   string `json:"store_file" env:"AUDIT_FILE"`
-	AuditURL    string `env:"AUDIT_URL"`
-	PprofServer string `env:"PPROF_SERVER"`
-	CryptoKey   string `json:"crypto_key" env:"CRYPTO_KEY"`
-	Config      string `env:"CONFIG"`
+	Address      string                     `json:"address" env:"ADDRESS"`
+	StoreInt     int                        `json:"store_interval" env:"STORE_INTERVAL"`
+	FilePath     string                     `env:"FILE_STORAGE_PATH"`
+	Restore      bool                       `json:"restore" env:"RESTORE"`
+	WALDir       string                     `json:"wal_dir" env:"WAL_DIR"`
+	WALSegmentMB int                        `json:"wal_segment_mb" env:"WAL_SEGMENT_MB"`
+	WALFsync     bool                       `json:"wal_fsync" env:"WAL_FSYNC"`
+	DSN          string                     `json:"database_dsn" env:"DATABASE_DSN"`
+	DBBackend    string                     `json:"database_backend" env:"DATABASE_BACKEND"`
+	DBMaxConns   int                        `json:"database_max_conns" env:"DATABASE_MAX_CONNS"`
+	DBMinConns   int                        `json:"database_min_conns" env:"DATABASE_MIN_CONNS"`
+	DBConnLife   int                        `json:"database_conn_max_lifetime" env:"DATABASE_CONN_MAX_LIFETIME"`
+	DBConnIdle   int                        `json:"database_conn_max_idle_time" env:"DATABASE_CONN_MAX_IDLE_TIME"`
+	DBConnTime   int                        `json:"database_connect_timeout" env:"DATABASE_CONNECT_TIMEOUT"`
+	DBQueryTime  int                        `json:"database_query_timeout" env:"DATABASE_QUERY_TIMEOUT"`
+	DBBatchTime  int                        `json:"database_batch_timeout" env:"DATABASE_BATCH_TIMEOUT"`
+	KEY          string                     `env:"KEY"`
+	AuditFile    string                     `json:"store_file" env:"AUDIT_FILE"`
+	AuditURL     string                     `env:"AUDIT_URL"`
+	PprofServer  string                     `env:"PPROF_SERVER"`
+	TrustedProxy string                     `json:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	CryptoKey    string                     `json:"crypto_key" env:"CRYPTO_KEY"`
+	HistorySize  int                        `json:"history_size" env:"HISTORY_SIZE"`
+	Retention    []repository.RetentionRule `json:"retention"`
+	RetentionInt int                        `json:"retention_interval" env:"RETENTION_INTERVAL"`
+	StatsdAddr   string                     `json:"statsd_address" env:"STATSD_ADDRESS"`
+	StatsdFlush  int                        `json:"statsd_flush_interval" env:"STATSD_FLUSH_INTERVAL"`
+	GraphiteAddr string                     `json:"graphite_address" env:"GRAPHITE_ADDRESS"`
+	GraphiteTmpl string                     `json:"graphite_template" env:"GRAPHITE_TEMPLATE"`
+	GraphiteConn int                        `json:"graphite_max_conns" env:"GRAPHITE_MAX_CONNS"`
+	GRPCAddr     string                     `json:"grpc_address" env:"GRPC_ADDRESS"`
+	RetryAttempt int                        `json:"retry_attempts" env:"RETRY_ATTEMPTS"`
+	RetryBase    int                        `json:"retry_base_ms" env:"RETRY_BASE_MS"`
+	RetryMax     int                        `json:"retry_max_ms" env:"RETRY_MAX_MS"`
+	RetryJitter  float64                    `json:"retry_jitter" env:"RETRY_JITTER"`
+	BreakerFails int                        `json:"breaker_threshold" env:"BREAKER_THRESHOLD"`
+	BreakerCool  int                        `json:"breaker_cooldown" env:"BREAKER_COOLDOWN"`
+	AuditQueue   int                        `json:"audit_queue_size" env:"AUDIT_QUEUE_SIZE"`
+	AuditWorkers int                        `json:"audit_workers" env:"AUDIT_WORKERS"`
+	AuditPolicy  string                     `json:"audit_overflow" env:"AUDIT_OVERFLOW"`
+	AuditSpill   string                     `json:"audit_spill_dir" env:"AUDIT_SPILL_DIR"`
+	AuditSpillMB int                        `json:"audit_spill_max_mb" env:"AUDIT_SPILL_MAX_MB"`
+	AuditDrain   int                        `json:"audit_drain_timeout" env:"AUDIT_DRAIN_TIMEOUT"`
+	AuditBatch   int                        `json:"audit_batch_size" env:"AUDIT_BATCH_SIZE"`
+	AuditFlush   int                        `json:"audit_flush_interval" env:"AUDIT_FLUSH_INTERVAL"`
+	AuditRetries int                        `json:"audit_retry_attempts" env:"AUDIT_RETRY_ATTEMPTS"`
+	AuditBackoff int                        `json:"audit_retry_base_ms" env:"AUDIT_RETRY_BASE_MS"`
+	AuditMaxWait int                        `json:"audit_retry_max_ms" env:"AUDIT_RETRY_MAX_MS"`
+	AuditTimeout int                        `json:"audit_timeout" env:"AUDIT_TIMEOUT"`
+	AuditSpool   string                     `json:"audit_spool_dir" env:"AUDIT_SPOOL_DIR"`
+	AuditSpoolMB int                        `json:"audit_spool_max_mb" env:"AUDIT_SPOOL_MAX_MB"`
+	AuditFsync   int                        `json:"audit_fsync_interval" env:"AUDIT_FSYNC_INTERVAL"`
+	AuditMaxMB   int                        `json:"audit_file_max_mb" env:"AUDIT_FILE_MAX_MB"`
+	AuditRotate  int                        `json:"audit_rotate_interval" env:"AUDIT_ROTATE_INTERVAL"`
+	AuditMaxFile int                        `json:"audit_max_files" env:"AUDIT_MAX_FILES"`
+	AuditGzip    bool                       `json:"audit_compress" env:"AUDIT_COMPRESS"`
+	AuditHMAC    bool                       `json:"audit_hmac" env:"AUDIT_HMAC"`
+	AuditStore   string                     `json:"audit_store_file" env:"AUDIT_STORE_FILE"`
+	AuditStoreDB bool                       `json:"audit_store_db" env:"AUDIT_STORE_DB"`
+	AuditKeepAge int                        `json:"audit_store_retention" env:"AUDIT_STORE_RETENTION"`
+	AuditKeepMax int                        `json:"audit_store_max_events" env:"AUDIT_STORE_MAX_EVENTS"`
+	AuditSyslog  string                     `json:"audit_syslog" env:"AUDIT_SYSLOG"`
+	AuditFacil   string                     `json:"audit_syslog_facility" env:"AUDIT_SYSLOG_FACILITY"`
+	AuditAppName string                     `json:"audit_syslog_app_name" env:"AUDIT_SYSLOG_APP_NAME"`
+	Config       string                     `env:"CONFIG"`
 }
 
 func setConfig() (Config, error) {
 	cfg := Config{
-		Address:     ":8080",
-		StoreInt:    300,
-		FilePath:    "",
-		Restore:     false,
-		DSN:         "",
-		KEY:         "",
-		AuditFile:   "",
-		AuditURL:    "",
-		PprofServer: ":6060",
-		CryptoKey:   "",
+		Address:      ":8080",
+		StoreInt:     300,
+		FilePath:     "",
+		Restore:      false,
+		WALDir:       "",
+		WALSegmentMB: 16,
+		WALFsync:     true,
+		DSN:          "",
+		DBBackend:    "sql",
+		DBMaxConns:   10,
+		DBMinConns:   0,
+		DBConnLife:   3600,
+		DBConnIdle:   1800,
+		DBConnTime:   5,
+		DBQueryTime:  2,
+		DBBatchTime:  5,
+		KEY:          "",
+		AuditFile:    "",
+		AuditURL:     "",
+		PprofServer:  ":6060",
+		TrustedProxy: "",
+		CryptoKey:    "",
+		HistorySize:  1000,
+		RetentionInt: 60,
+		StatsdAddr:   "",
+		StatsdFlush:  10,
+		GraphiteAddr: "",
+		GraphiteTmpl: "",
+		GraphiteConn: 100,
+		GRPCAddr:     "",
+		RetryAttempt: 3,
+		RetryBase:    1000,
+		RetryMax:     5000,
+		RetryJitter:  0.2,
+		BreakerFails: 5,
+		BreakerCool:  30,
+		AuditQueue:   1024,
+		AuditWorkers: 1,
+		AuditPolicy:  "drop-oldest",
+		AuditSpill:   "",
+		AuditSpillMB: 64,
+		AuditDrain:   10,
+		AuditBatch:   1,
+		AuditFlush:   1,
+		AuditRetries: 5,
+		AuditBackoff: 500,
+		AuditMaxWait: 30000,
+		AuditTimeout: 5,
+		AuditSpool:   "",
+		AuditSpoolMB: 64,
+		AuditFsync:   1,
+		AuditMaxMB:   100,
+		AuditRotate:  0,
+		AuditMaxFile: 10,
+		AuditGzip:    true,
+		AuditHMAC:    false,
+		AuditStore:   "",
+		AuditStoreDB: false,
+		AuditKeepAge: 2592000,
+		AuditKeepMax: 100000,
+		AuditSyslog:  "",
+		AuditFacil:   "local0",
+		AuditAppName: "metrics-server",
 	}
 
 	var address string
 	var storeInt int
 	var filePath string
 	var restore bool
+	var walDir string
+	var walSegmentMB int
+	var walFsync bool
 	var dsn string
+	var dbBackend string
+	var dbMaxConns int
+	var dbMinConns int
+	var dbConnLife int
+	var dbConnIdle int
+	var dbConnTime int
+	var dbQueryTime int
+	var dbBatchTime int
 	var key string
 	var auditFile string
 	var auditURL string
 	var pprof string
+	var trustedProxy string
 	var cryptoKey string
+	var historySize int
+	var retentionInt int
+	var statsdAddr string
+	var statsdFlush int
+	var graphiteAddr string
+	var graphiteTmpl string
+	var graphiteConn int
+	var grpcAddr string
+	var retryAttempt int
+	var retryBase int
+	var retryMax int
+	var retryJitter float64
+	var breakerFails int
+	var breakerCool int
+	var auditQueue int
+	var auditWorkers int
+	var auditPolicy string
+	var auditSpill string
+	var auditSpillMB int
+	var auditDrain int
+	var auditBatch int
+	var auditFlush int
+	var auditRetries int
+	var auditBackoff int
+	var auditMaxWait int
+	var auditTimeout int
+	var auditSpool string
+	var auditSpoolMB int
+	var auditFsync int
+	var auditMaxMB int
+	var auditRotate int
+	var auditMaxFile int
+	var auditGzip bool
+	var auditHMAC bool
+	var auditStore string
+	var auditStoreDB bool
+	var auditKeepAge int
+	var auditKeepMax int
+	var auditSyslog string
+	var auditFacil string
+	var auditAppName string
 
 	bind := func(fs *flag.FlagSet) {
 		fs.StringVar(&address, "a", ":8080", "Server port")
-		fs.IntVar(&storeInt, "i", 300, "collect data to store interval in secodns")
+		fs.IntVar(&storeInt, "i", 300, "storage file checkpoint interval in seconds, 0 checkpoints only full wal segments")
 		fs.StringVar(&filePath, "f", "", "storage file path")
 		fs.BoolVar(&restore, "r", false, "should load data from local file when starting the server")
-		fs.StringVar(&dsn, "d", "", "databse connection string")
+		fs.StringVar(&walDir, "wal-dir", "", "write-ahead log directory, the storage file path with .wal if empty")
+		fs.IntVar(&walSegmentMB, "wal-segment-mb", 16, "write-ahead log segment size in megabytes")
+		fs.BoolVar(&walFsync, "wal-fsync", true, "fsync every write-ahead log record before the update is acknowledged")
+		fs.StringVar(&dsn, "d", "", "databse connection string, postgres dsn or sqlite://path")
+		fs.StringVar(&dbBackend, "database-backend", "sql", "postgres driver: sql for database/sql, pgx for the native pool with multi-row batch upserts")
+		fs.IntVar(&dbMaxConns, "database-max-conns", 10, "max connections of the pgx pool")
+		fs.IntVar(&dbMinConns, "database-min-conns", 0, "connections the pgx pool keeps open")
+		fs.IntVar(&dbConnLife, "database-conn-max-lifetime", 3600, "seconds a pgx pool connection lives before it is replaced")
+		fs.IntVar(&dbConnIdle, "database-conn-max-idle-time", 1800, "seconds an idle pgx pool connection is kept")
+		fs.IntVar(&dbConnTime, "database-connect-timeout", 5, "database connect timeout in seconds")
+		fs.IntVar(&dbQueryTime, "database-query-timeout", 2, "timeout of a single metric query in seconds")
+		fs.IntVar(&dbBatchTime, "database-batch-timeout", 5, "timeout of a batch write in seconds")
 		fs.StringVar(&key, "k", "", "key value")
 		fs.StringVar(&auditFile, "audit-file", "", "audit file address")
 		fs.StringVar(&auditURL, "audit-url", "", "audit url")
 		fs.StringVar(&pprof, "p", ":6060", "pprof server port")
+		fs.StringVar(&trustedProxy, "trusted-proxies", "", "comma-separated proxy IPs and CIDRs whose X-Forwarded-For is trusted, the peer address is the client if empty")
 		fs.StringVar(&cryptoKey, "crypto-key", "", "crypto-key file path")
+		fs.IntVar(&historySize, "history-size", 1000, "samples kept per series by the in-memory storage, 0 keeps none")
+		fs.IntVar(&retentionInt, "retention-interval", 60, "history compaction interval in seconds")
+		fs.StringVar(&statsdAddr, "statsd-address", "", "statsd udp listener address, disabled if empty")
+		fs.IntVar(&statsdFlush, "statsd-flush-interval", 10, "statsd flush interval in seconds")
+		fs.StringVar(&graphiteAddr, "graphite-address", "", "graphite tcp listener address, disabled if empty")
+		fs.StringVar(&graphiteTmpl, "graphite-template", "", "graphite path templates, for example \"servers.* .host.measurement*\"")
+		fs.IntVar(&graphiteConn, "graphite-max-conns", 100, "graphite simultaneous connections limit")
+		fs.StringVar(&grpcAddr, "grpc-address", "", "grpc server address, disabled if empty")
+		fs.IntVar(&retryAttempt, "retry-attempts", 3, "storage write attempts, 1 disables retries")
+		fs.IntVar(&retryBase, "retry-base", 1000, "delay before the first storage retry in milliseconds, doubled for every next one")
+		fs.IntVar(&retryMax, "retry-max", 5000, "max delay between storage retries in milliseconds")
+		fs.Float64Var(&retryJitter, "retry-jitter", 0.2, "share of the retry delay picked at random, from 0 to 1")
+		fs.IntVar(&breakerFails, "breaker-threshold", 5, "consecutive database failures that open the circuit breaker, 0 disables it")
+		fs.IntVar(&breakerCool, "breaker-cooldown", 30, "seconds the circuit breaker stays open before a probe call")
+		fs.IntVar(&auditQueue, "audit-queue-size", 1024, "audit events queued per observer")
+		fs.IntVar(&auditWorkers, "audit-workers", 1, "goroutines delivering the audit events per observer")
+		fs.StringVar(&auditPolicy, "audit-overflow", "drop-oldest", "what a full audit queue does: drop-oldest, block or spill")
+		fs.StringVar(&auditSpill, "audit-spill-dir", "", "directory for the audit events spilled by a full queue")
+		fs.IntVar(&auditSpillMB, "audit-spill-max-mb", 64, "size limit of an audit spill file in megabytes, new events are dropped once it is reached")
+		fs.IntVar(&auditDrain, "audit-drain-timeout", 10, "seconds to deliver the queued audit events on shutdown")
+		fs.IntVar(&auditBatch, "audit-batch-size", 1, "events per audit POST, 1 posts single json objects")
+		fs.IntVar(&auditFlush, "audit-flush-interval", 1, "seconds a partial audit batch waits for more events")
+		fs.IntVar(&auditRetries, "audit-retry-attempts", 5, "audit delivery attempts before the events are spooled")
+		fs.IntVar(&auditBackoff, "audit-retry-base", 500, "delay before the first audit retry in milliseconds, doubled for every next one")
+		fs.IntVar(&auditMaxWait, "audit-retry-max", 30000, "max delay between audit retries and spool replays in milliseconds")
+		fs.IntVar(&auditTimeout, "audit-timeout", 5, "audit request timeout in seconds")
+		fs.StringVar(&auditSpool, "audit-spool-dir", "", "directory of the undeliverable audit events, dropped if empty")
+		fs.IntVar(&auditSpoolMB, "audit-spool-max-mb", 64, "size limit of the audit spool in megabytes")
+		fs.IntVar(&auditFsync, "audit-fsync-interval", 1, "seconds between the audit file flushes and fsyncs")
+		fs.IntVar(&auditMaxMB, "audit-file-max-mb", 100, "audit file size in megabytes it is rotated at, 0 disables size rotation")
+		fs.IntVar(&auditRotate, "audit-rotate-interval", 0, "seconds the audit file is rotated after, 0 disables time rotation")
+		fs.IntVar(&auditMaxFile, "audit-max-files", 10, "rotated audit files kept, 0 keeps all")
+		fs.BoolVar(&auditGzip, "audit-compress", true, "gzip the rotated audit files")
+		fs.BoolVar(&auditHMAC, "audit-hmac", false, "sign the audit file entries with an hmac of the key")
+		fs.StringVar(&auditStore, "audit-store-file", "", "queryable audit store file")
+		fs.BoolVar(&auditStoreDB, "audit-store-db", false, "keep the queryable audit events in the audit_events table of the postgres database instead of the store file")
+		fs.IntVar(&auditKeepAge, "audit-store-retention", 2592000, "seconds the queryable audit events are kept, 0 keeps them all")
+		fs.IntVar(&auditKeepMax, "audit-store-max-events", 100000, "events kept by the audit store file, the oldest are cut off above it, 0 keeps them all")
+		fs.StringVar(&auditSyslog, "audit-syslog", "", "syslog address for the audit events: udp://host:port, tcp://host:port or unix:///dev/log")
+		fs.StringVar(&auditFacil, "audit-syslog-facility", "local0", "syslog facility of the audit events")
+		fs.StringVar(&auditAppName, "audit-syslog-app-name", "metrics-server", "syslog app name of the audit events")
 	}
 
 	apply := func(name string) {
@@ -69,8 +285,30 @@ func setConfig() (Config, error) {
 			cfg.FilePath = filePath
 		case "r":
 			cfg.Restore = restore
+		case "wal-dir":
+			cfg.WALDir = walDir
+		case "wal-segment-mb":
+			cfg.WALSegmentMB = walSegmentMB
+		case "wal-fsync":
+			cfg.WALFsync = walFsync
 		case "d":
 			cfg.DSN = dsn
+		case "database-backend":
+			cfg.DBBackend = dbBackend
+		case "database-max-conns":
+			cfg.DBMaxConns = dbMaxConns
+		case "database-min-conns":
+			cfg.DBMinConns = dbMinConns
+		case "database-conn-max-lifetime":
+			cfg.DBConnLife = dbConnLife
+		case "database-conn-max-idle-time":
+			cfg.DBConnIdle = dbConnIdle
+		case "database-connect-timeout":
+			cfg.DBConnTime = dbConnTime
+		case "database-query-timeout":
+			cfg.DBQueryTime = dbQueryTime
+		case "database-batch-timeout":
+			cfg.DBBatchTime = dbBatchTime
 		case "k":
 			cfg.KEY = key
 		case "audit-file":
@@ -79,8 +317,92 @@ func setConfig() (Config, error) {
 			cfg.AuditURL = auditURL
 		case "p":
 			cfg.PprofServer = pprof
+		case "trusted-proxies":
+			cfg.TrustedProxy = trustedProxy
 		case "crypto-key":
 			cfg.CryptoKey = cryptoKey
+		case "history-size":
+			cfg.HistorySize = historySize
+		case "retention-interval":
+			cfg.RetentionInt = retentionInt
+		case "statsd-address":
+			cfg.StatsdAddr = statsdAddr
+		case "statsd-flush-interval":
+			cfg.StatsdFlush = statsdFlush
+		case "graphite-address":
+			cfg.GraphiteAddr = graphiteAddr
+		case "graphite-template":
+			cfg.GraphiteTmpl = graphiteTmpl
+		case "graphite-max-conns":
+			cfg.GraphiteConn = graphiteConn
+		case "grpc-address":
+			cfg.GRPCAddr = grpcAddr
+		case "retry-attempts":
+			cfg.RetryAttempt = retryAttempt
+		case "retry-base":
+			cfg.RetryBase = retryBase
+		case "retry-max":
+			cfg.RetryMax = retryMax
+		case "retry-jitter":
+			cfg.RetryJitter = retryJitter
+		case "breaker-threshold":
+			cfg.BreakerFails = breakerFails
+		case "breaker-cooldown":
+			cfg.BreakerCool = breakerCool
+		case "audit-queue-size":
+			cfg.AuditQueue = auditQueue
+		case "audit-workers":
+			cfg.AuditWorkers = auditWorkers
+		case "audit-overflow":
+			cfg.AuditPolicy = auditPolicy
+		case "audit-spill-dir":
+			cfg.AuditSpill = auditSpill
+		case "audit-spill-max-mb":
+			cfg.AuditSpillMB = auditSpillMB
+		case "audit-drain-timeout":
+			cfg.AuditDrain = auditDrain
+		case "audit-batch-size":
+			cfg.AuditBatch = auditBatch
+		case "audit-flush-interval":
+			cfg.AuditFlush = auditFlush
+		case "audit-retry-attempts":
+			cfg.AuditRetries = auditRetries
+		case "audit-retry-base":
+			cfg.AuditBackoff = auditBackoff
+		case "audit-retry-max":
+			cfg.AuditMaxWait = auditMaxWait
+		case "audit-timeout":
+			cfg.AuditTimeout = auditTimeout
+		case "audit-spool-dir":
+			cfg.AuditSpool = auditSpool
+		case "audit-spool-max-mb":
+			cfg.AuditSpoolMB = auditSpoolMB
+		case "audit-fsync-interval":
+			cfg.AuditFsync = auditFsync
+		case "audit-file-max-mb":
+			cfg.AuditMaxMB = auditMaxMB
+		case "audit-rotate-interval":
+			cfg.AuditRotate = auditRotate
+		case "audit-max-files":
+			cfg.AuditMaxFile = auditMaxFile
+		case "audit-compress":
+			cfg.AuditGzip = auditGzip
+		case "audit-hmac":
+			cfg.AuditHMAC = auditHMAC
+		case "audit-store-file":
+			cfg.AuditStore = auditStore
+		case "audit-store-db":
+			cfg.AuditStoreDB = auditStoreDB
+		case "audit-store-retention":
+			cfg.AuditKeepAge = auditKeepAge
+		case "audit-store-max-events":
+			cfg.AuditKeepMax = auditKeepMax
+		case "audit-syslog":
+			cfg.AuditSyslog = auditSyslog
+		case "audit-syslog-facility":
+			cfg.AuditFacil = auditFacil
+		case "audit-syslog-app-name":
+			cfg.AuditAppName = auditAppName
 		}
 	}
 
@@ -88,9 +410,70 @@ func setConfig() (Config, error) {
 		return Config{}, fmt.Errorf("could't load config: %v", err)
 	}
 
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return cfg, nil
 }
 
+// validate - method for checking the settings that can't be used as they are
+// if a setting is invalid, return error
+func (c Config) validate() error {
+	if c.HistorySize < 0 {
+		return fmt.Errorf("history size must not be negative: %d", c.HistorySize)
+	}
+	if err := c.validateDatabase(); err != nil {
+		return err
+	}
+	for _, rule := range c.Retention {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid retention rule: %w", err)
+		}
+	}
+	if len(c.Retention) > 0 && c.RetentionInt <= 0 {
+		return fmt.Errorf("retention interval must be positive: %d", c.RetentionInt)
+	}
+	if _, err := handler.ParseTrustedProxies(c.TrustedProxy); err != nil {
+		return err
+	}
+	if c.AuditStoreDB && (c.DSN == "" || repository.IsSQLiteDSN(c.DSN)) {
+		return fmt.Errorf("audit store in the database needs a postgres dsn")
+	}
+	if c.AuditKeepAge < 0 {
+		return fmt.Errorf("audit store retention must not be negative: %d", c.AuditKeepAge)
+	}
+	if c.AuditKeepMax < 0 {
+		return fmt.Errorf("audit store max events must not be negative: %d", c.AuditKeepMax)
+	}
+	return nil
+}
+
+// validateDatabase - method for checking the database settings
+// the timeouts are passed to context.WithTimeout and the pool settings to pgxpool as they are
+// if a setting is invalid, return error
+func (c Config) validateDatabase() error {
+	switch {
+	case c.DBBackend != "sql" && c.DBBackend != "pgx":
+		return fmt.Errorf("invalid database backend: %q, must be sql or pgx", c.DBBackend)
+	case c.DBConnTime <= 0:
+		return fmt.Errorf("database connect timeout must be positive: %d", c.DBConnTime)
+	case c.DBQueryTime <= 0:
+		return fmt.Errorf("database query timeout must be positive: %d", c.DBQueryTime)
+	case c.DBBatchTime <= 0:
+		return fmt.Errorf("database batch timeout must be positive: %d", c.DBBatchTime)
+	case c.DBMaxConns <= 0 || c.DBMaxConns > math.MaxInt32:
+		return fmt.Errorf("database max conns must be from 1 to %d: %d", math.MaxInt32, c.DBMaxConns)
+	case c.DBMinConns < 0 || c.DBMinConns > c.DBMaxConns:
+		return fmt.Errorf("database min conns must be from 0 to max conns %d: %d", c.DBMaxConns, c.DBMinConns)
+	case c.DBConnLife <= 0:
+		return fmt.Errorf("database conn max lifetime must be positive: %d", c.DBConnLife)
+	case c.DBConnIdle <= 0:
+		return fmt.Errorf("database conn max idle time must be positive: %d", c.DBConnIdle)
+	}
+	return nil
+}
+
 // host=localhost port=5432 user=metrics_user password=password dbname=metrics_db sslmode=disable
 
 //../../data/save.json
@@ -4,26 +4,34 @@ import (
 	"context"
 	"crypto/rsa"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/makimaki04/go-metrics-agent.git/internal/audit"
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
 	"github.com/makimaki04/go-metrics-agent.git/internal/crypto"
+	"github.com/makimaki04/go-metrics-agent.git/internal/graphite"
+	"github.com/makimaki04/go-metrics-agent.git/internal/grpcserver"
 	"github.com/makimaki04/go-metrics-agent.git/internal/handler"
 	"github.com/makimaki04/go-metrics-agent.git/internal/middleware"
 	"github.com/makimaki04/go-metrics-agent.git/internal/migrations"
 	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
 	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
 	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"github.com/makimaki04/go-metrics-agent.git/internal/statsd"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 var buildVersion = "N/A"
@@ -44,26 +52,67 @@ func main() {
 		zap.String("handler", "Handle Request"),
 	)
 
+	signalctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
 	var storage repository.Repository
 	var mService service.MetricsService
+	var dbBreaker *breaker.Breaker
+	var db *sql.DB
+	var wal *repository.WALStorage
 
 	switch {
+	case repository.IsSQLiteDSN(cfg.DSN):
+		var sqliteDB *sql.DB
+		sqliteDB, storage = initSQLiteStorage(cfg, logger)
+		defer sqliteDB.Close()
+		mService = service.NewService(storage, logger)
+		logger.Info("SQLite storage initialized")
 	case cfg.DSN != "":
-		db, storage := initDBStorage(cfg, logger)
+		var dbStorage repository.Repository
+		switch cfg.DBBackend {
+		case "pgx":
+			var pool *pgxpool.Pool
+			pool, db, dbStorage = initPgxStorage(cfg, logger)
+			defer pool.Close()
+		case "sql":
+			db, dbStorage = initDBStorage(cfg, logger)
+		default:
+			log.Fatalf("invalid database backend: %q, must be pgx or sql", cfg.DBBackend)
+		}
 		defer db.Close()
+		storage = dbStorage
+		if cfg.BreakerFails > 0 {
+			if cfg.BreakerCool <= 0 {
+				log.Fatalf("invalid breaker cooldown: %d", cfg.BreakerCool)
+			}
+			dbBreaker = breaker.New(cfg.BreakerFails, time.Duration(cfg.BreakerCool)*time.Second)
+			storage = repository.NewBreakerStorage(dbStorage, dbBreaker)
+		}
 		mService = service.NewService(storage, logger)
 		logger.Info("Database storage initialized")
 	case cfg.FilePath != "":
-		storage = repository.NewStorage()
+		wal = initWALStorage(signalctx, cfg, logger)
+		storage = wal
 		mService = service.NewService(storage, logger)
-		initFileStorage(mService, cfg, logger)
 		logger.Info("Local storage initialized")
 	default:
-		storage = repository.NewStorage()
+		storage = repository.NewStorageWithHistory(cfg.HistorySize)
 		mService = service.NewService(storage, logger)
 		logger.Info("In-memory storage initialized")
 	}
 
+	retryPolicy := service.RetryPolicy{
+		Attempts: cfg.RetryAttempt,
+		Base:     time.Duration(cfg.RetryBase) * time.Millisecond,
+		Max:      time.Duration(cfg.RetryMax) * time.Millisecond,
+		Jitter:   cfg.RetryJitter,
+	}
+	if err := retryPolicy.Validate(); err != nil {
+		log.Fatalf("invalid retry config: %v", err)
+	}
+	mService.SetRetryPolicy(retryPolicy)
+
 	var privateKey *rsa.PrivateKey
 	if cfg.CryptoKey != "" {
 		key, err := crypto.LoadPrivateKey(cfg.CryptoKey)
@@ -75,9 +124,19 @@ func main() {
 	} else {
 		logger.Info("No crypto key specified, running without decryption")
 	}
-	InitObservers(mService, cfg, logger)
+	auditStore := initAuditStore(db, cfg, logger)
+	auditStatus, auditClosers := InitObservers(mService, auditStore, cfg, logger)
+
+	// the list is checked by the config validation
+	proxies, _ := handler.ParseTrustedProxies(cfg.TrustedProxy)
 
 	handler := handler.NewHandler(mService, cfg.KEY)
+	handler.SetBreaker(dbBreaker)
+	handler.SetTrustedProxies(proxies)
+	handler.SetAuditStatus(auditStatus...)
+	if auditStore != nil {
+		handler.SetAuditStore(auditStore)
+	}
 
 	r := chi.NewRouter()
 	r.Route("/", func(r chi.Router) {
@@ -100,6 +159,27 @@ func main() {
 		r.Route("/updates", func(r chi.Router) {
 			r.Post("/", middleware.WithLogging(middleware.GzipMiddleware(middleware.CryptoMiddleware(privateKey, handler.UpdateMetricBatch)), handlersLogger))
 		})
+		r.Route("/metrics", func(r chi.Router) {
+			r.Get("/", middleware.WithLogging(middleware.GzipMiddleware(handler.GetPrometheusMetrics), handlersLogger))
+		})
+		r.Route("/import/prometheus", func(r chi.Router) {
+			r.Post("/", middleware.WithLogging(middleware.GzipMiddleware(middleware.CryptoMiddleware(privateKey, handler.ImportPrometheus)), handlersLogger))
+		})
+		r.Route("/write", func(r chi.Router) {
+			r.Post("/", middleware.WithLogging(middleware.GzipMiddleware(middleware.CryptoMiddleware(privateKey, handler.WriteLineProtocol)), handlersLogger))
+		})
+		r.Route("/v1/metrics", func(r chi.Router) {
+			r.Post("/", middleware.WithLogging(middleware.GzipMiddleware(handler.ExportOTLPMetrics), handlersLogger))
+		})
+		r.Route("/audit", func(r chi.Router) {
+			r.Get("/", middleware.WithLogging(middleware.GzipMiddleware(handler.QueryAudit), handlersLogger))
+		})
+		r.Route("/status/audit", func(r chi.Router) {
+			r.Get("/", middleware.WithLogging(middleware.GzipMiddleware(handler.AuditStatus), handlersLogger))
+		})
+		r.Route("/history/{MType}/{ID}", func(r chi.Router) {
+			r.Get("/", middleware.WithLogging(middleware.GzipMiddleware(handler.GetHistory), handlersLogger))
+		})
 
 	})
 
@@ -112,8 +192,80 @@ func main() {
 		Handler: r,
 	}
 
-	signalctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+	if len(cfg.Retention) > 0 {
+		go runCompaction(signalctx, storage, cfg, logger)
+		logger.Info("History compaction started", zap.Int("rules", len(cfg.Retention)))
+	}
+
+	if auditStore != nil && cfg.AuditKeepAge > 0 {
+		go runAuditRetention(signalctx, auditStore, cfg, logger)
+	}
+
+	statsdDone := make(chan struct{})
+	if cfg.StatsdAddr != "" {
+		if cfg.StatsdFlush <= 0 {
+			log.Fatalf("invalid statsd flush interval: %d", cfg.StatsdFlush)
+		}
+		statsdServer := statsd.NewServer(cfg.StatsdAddr, time.Duration(cfg.StatsdFlush)*time.Second, mService, logger)
+		go func() {
+			defer close(statsdDone)
+			if err := statsdServer.ListenAndServe(signalctx); err != nil {
+				logger.Error("StatsD listener failed", zap.Error(err))
+			}
+		}()
+		logger.Info("StatsD listener started", zap.String("address", cfg.StatsdAddr))
+	} else {
+		close(statsdDone)
+	}
+
+	graphiteDone := make(chan struct{})
+	if cfg.GraphiteAddr != "" {
+		templates, err := graphite.ParseTemplates(cfg.GraphiteTmpl)
+		if err != nil {
+			log.Fatalf("invalid graphite template: %v", err)
+		}
+		if cfg.GraphiteConn <= 0 {
+			log.Fatalf("invalid graphite connections limit: %d", cfg.GraphiteConn)
+		}
+		ln, err := net.Listen("tcp", cfg.GraphiteAddr)
+		if err != nil {
+			log.Fatalf("graphite listener failed to listen on %s: %v", cfg.GraphiteAddr, err)
+		}
+
+		graphiteServer := graphite.NewServer(cfg.GraphiteAddr, cfg.GraphiteConn, templates, mService, logger)
+		go func() {
+			defer close(graphiteDone)
+			if err := graphiteServer.Serve(signalctx, ln); err != nil {
+				logger.Error("Graphite listener failed", zap.Error(err))
+			}
+		}()
+		logger.Info("Graphite listener started", zap.String("address", cfg.GraphiteAddr))
+	} else {
+		close(graphiteDone)
+	}
+
+	var grpcServer *grpc.Server
+	var metricsServer *grpcserver.MetricsServer
+	if cfg.GRPCAddr != "" {
+		ln, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			log.Fatalf("grpc server failed to listen on %s: %v", cfg.GRPCAddr, err)
+		}
+
+		grpcServer = grpc.NewServer(
+			grpc.ChainUnaryInterceptor(grpcserver.UnaryLogging(handlersLogger)),
+			grpc.ChainStreamInterceptor(grpcserver.StreamLogging(handlersLogger)),
+		)
+		metricsServer = grpcserver.NewMetricsServer(mService, cfg.KEY, logger)
+		metricsServer.Register(grpcServer)
+
+		go func() {
+			if err := grpcServer.Serve(ln); err != nil {
+				logger.Error("gRPC server failed", zap.Error(err))
+			}
+		}()
+		logger.Info("gRPC server started", zap.String("address", cfg.GRPCAddr))
+	}
 
 	fmt.Printf("Build version: %s\n", buildVersion)
 	fmt.Printf("Build date: %s\n", buildDate)
@@ -140,142 +292,349 @@ func main() {
 
 	APIServer.Shutdown(shutDownCtx)
 	pprofServer.Shutdown(shutDownCtx)
-}
 
-func loadMetricsFromFile(path string, service service.MetricsService, logger *zap.Logger) {
-	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		logger.Error("Failed to open metrics file for reading", zap.Error(err))
-		return
+	if grpcServer != nil {
+		metricsServer.Close()
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutDownCtx.Done():
+			grpcServer.Stop()
+		}
 	}
-	defer file.Close()
 
-	data, err := file.Stat()
-	if err != nil {
-		logger.Info("Couldn't read the file")
+	select {
+	case <-statsdDone:
+	case <-shutDownCtx.Done():
+		logger.Warn("StatsD final flush timed out")
+	}
+
+	select {
+	case <-graphiteDone:
+	case <-shutDownCtx.Done():
+		logger.Warn("Graphite listener shutdown timed out")
 	}
 
-	if data.Size() > 0 {
-		var metrics struct {
-			Counters map[string]int64   `json:"counters"`
-			Gauges   map[string]float64 `json:"gauges"`
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(cfg.AuditDrain)*time.Second)
+	defer cancelDrain()
+	for _, closeObserver := range auditClosers {
+		if err := closeObserver(drainCtx); err != nil {
+			logger.Error("Failed to drain audit observer", zap.Error(err))
 		}
-		decoder := json.NewDecoder(file)
-		if err := decoder.Decode(&metrics); err != nil {
-			logger.Error("Couldn't parse data")
-		} else {
-			for key, value := range metrics.Gauges {
-				service.UpdateGauge(key, value)
-			}
+	}
 
-			for key, value := range metrics.Counters {
-				service.UpdateCounter(key, value)
-			}
-			logger.Info("metrics successfully loaded from local storage located in ./data/save.json")
+	// the servers are stopped, so the final checkpoint has every acknowledged update
+	if wal != nil {
+		if err := wal.Checkpoint(context.Background()); err != nil {
+			logger.Error("Failed to checkpoint write-ahead log", zap.Error(err))
+		}
+		if err := wal.Close(); err != nil {
+			logger.Error("Failed to close write-ahead log", zap.Error(err))
 		}
-	} else {
-		logger.Info("the local file exists, but is empty")
 	}
 }
 
-func saveMetricsToFile(path string, service service.MetricsService, logger *zap.Logger) {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		logger.Error("Failed to open metrics file for writing", zap.Error(err))
-		return
+func initDBStorage(cfg Config, logger *zap.Logger) (*sql.DB, repository.Repository) {
+	if err := migrations.RunMigration(cfg.DSN); err != nil {
+		logger.Fatal("Error when starting migrations: %v", zap.Error(err))
 	}
-	defer file.Close()
+	logger.Info("Migration successfully started")
 
-	gauges, err := service.GetAllGauges()
+	db, err := sql.Open("pgx", cfg.DSN)
 	if err != nil {
-		logger.Error("Failed to get gauges", zap.Error(err))
-		gauges = make(map[string]float64)
+		logger.Fatal("Database connection error:" + err.Error())
 	}
 
-	counters, err := service.GetAllCounters()
-	if err != nil {
-		logger.Error("Failed to get counters", zap.Error(err))
-		counters = make(map[string]int64)
-	}
+	return db, repository.NewDBStorage(db, logger)
+}
 
-	allMetrics := struct {
-		Counters map[string]int64   `json:"counters"`
-		Gauges   map[string]float64 `json:"gauges"`
-	}{
-		Counters: counters,
-		Gauges:   gauges,
+// initPgxStorage - migrates the database and connects the pgx pool
+// the *sql.DB for the audit store is a view of the pool, so both share the connections
+func initPgxStorage(cfg Config, logger *zap.Logger) (*pgxpool.Pool, *sql.DB, repository.Repository) {
+	if err := migrations.RunMigration(cfg.DSN); err != nil {
+		logger.Fatal("Error when starting migrations", zap.Error(err))
 	}
+	logger.Info("Migration successfully started")
 
-	data, err := json.MarshalIndent(allMetrics, "", "	")
-	if err != nil {
-		logger.Error("Failed to marshal all metrics", zap.Error(err))
-		return
+	pgxCfg := repository.PgxConfig{
+		MaxConns:        int32(cfg.DBMaxConns),
+		MinConns:        int32(cfg.DBMinConns),
+		MaxConnLifetime: time.Duration(cfg.DBConnLife) * time.Second,
+		MaxConnIdleTime: time.Duration(cfg.DBConnIdle) * time.Second,
+		ConnectTimeout:  time.Duration(cfg.DBConnTime) * time.Second,
+		QueryTimeout:    time.Duration(cfg.DBQueryTime) * time.Second,
+		BatchTimeout:    time.Duration(cfg.DBBatchTime) * time.Second,
 	}
-
-	if _, err := file.Write(data); err != nil {
-		logger.Error("Failed to write metrics to file", zap.Error(err))
-		return
+	pool, err := repository.NewPgxPool(context.Background(), cfg.DSN, pgxCfg)
+	if err != nil {
+		logger.Fatal("Database connection error", zap.Error(err))
 	}
 
-	logger.Info("metrics successfully added to the local storage located in ./data/save.json")
+	return pool, stdlib.OpenDBFromPool(pool), repository.NewPgxStorage(pool, pgxCfg, logger)
 }
 
-func initDBStorage(cfg Config, logger *zap.Logger) (*sql.DB, repository.Repository) {
+// initSQLiteStorage - migrates and opens the embedded SQLite database of a sqlite://path dsn
+// the audit events aren't stored in it, the audit store file is used instead
+func initSQLiteStorage(cfg Config, logger *zap.Logger) (*sql.DB, repository.Repository) {
 	if err := migrations.RunMigration(cfg.DSN); err != nil {
-		logger.Fatal("Error when starting migrations: %v", zap.Error(err))
+		logger.Fatal("Error when starting migrations", zap.Error(err))
 	}
 	logger.Info("Migration successfully started")
 
-	db, err := sql.Open("pgx", cfg.DSN)
+	db, err := repository.OpenSQLite(cfg.DSN)
 	if err != nil {
-		logger.Fatal("Database connection error:" + err.Error())
+		logger.Fatal("Database connection error", zap.Error(err))
 	}
 
-	return db, repository.NewDBStorage(db, logger)
+	return db, repository.NewSQLiteStorage(db, logger)
 }
 
-func initFileStorage(service service.MetricsService, cfg Config, logger *zap.Logger) {
-	dir := filepath.Dir(cfg.FilePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// initWALStorage - creates the in-memory storage persisted to the storage file and its write-ahead log
+// with RESTORE the metrics are rebuilt from the storage file and the log, a corrupted log stops the server
+// with STORE_INTERVAL above 0 checkpoints are also taken on a ticker
+func initWALStorage(ctx context.Context, cfg Config, logger *zap.Logger) *repository.WALStorage {
+	if err := os.MkdirAll(filepath.Dir(cfg.FilePath), 0755); err != nil {
 		logger.Fatal("Couldn't create directory for storage file", zap.Error(err))
 	}
 
-	if cfg.Restore {
-		loadMetricsFromFile(cfg.FilePath, service, logger)
+	walDir := cfg.WALDir
+	if walDir == "" {
+		walDir = cfg.FilePath + ".wal"
 	}
 
-	if cfg.StoreInt == 0 {
-		saveMetricsToFile(cfg.FilePath, service, logger)
-	} else {
+	wal, err := repository.NewWALStorage(ctx,
+		repository.NewStorageWithHistory(cfg.HistorySize),
+		repository.NewSnapshotFile(cfg.FilePath),
+		repository.WALConfig{
+			Dir:         walDir,
+			SegmentSize: int64(cfg.WALSegmentMB) << 20,
+			Fsync:       cfg.WALFsync,
+			Restore:     cfg.Restore,
+		}, logger)
+	if err != nil {
+		log.Fatalf("couldn't open storage file %s: %v", cfg.FilePath, err)
+	}
+	logger.Info("Write-ahead log opened", zap.String("path", cfg.FilePath), zap.String("wal", walDir))
+
+	if cfg.StoreInt > 0 {
 		go func() {
 			ticker := time.NewTicker(time.Duration(cfg.StoreInt) * time.Second)
 			defer ticker.Stop()
 
-			for range ticker.C {
-				saveMetricsToFile(cfg.FilePath, service, logger)
+			for {
+				select {
+				case <-ticker.C:
+					if err := wal.Checkpoint(ctx); err != nil {
+						logger.Error("Failed to checkpoint write-ahead log", zap.Error(err))
+					}
+				case <-ctx.Done():
+					return
+				}
 			}
 		}()
 	}
+
+	return wal
 }
 
-func InitObservers(service service.MetricsService, cfg Config, logger *zap.Logger) {
+// runCompaction - applies the retention rules to the storage every retention interval
+// the interval is checked to be positive when the config is loaded
+func runCompaction(ctx context.Context, storage repository.Repository, cfg Config, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Duration(cfg.RetentionInt) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := storage.ApplyRetention(ctx, cfg.Retention, time.Now()); err != nil {
+				logger.Error("Failed to apply history retention", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runAuditRetention - removes the audit events older than the retention from the store
+// the store is pruned on start and then every hour, or every retention if it is shorter
+func runAuditRetention(ctx context.Context, store audit.Store, cfg Config, logger *zap.Logger) {
+	keep := time.Duration(cfg.AuditKeepAge) * time.Second
+	ticker := time.NewTicker(min(keep, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		removed, err := store.Prune(ctx, time.Now().Add(-keep))
+		if err != nil {
+			logger.Error("Failed to prune audit events", zap.Error(err))
+		} else if removed > 0 {
+			logger.Info("Audit events pruned", zap.Int64("removed", removed))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// initAuditStore - opens the queryable audit store
+// the audit_events table is used if it is enabled, the store file otherwise
+// returns nil if neither is configured
+func initAuditStore(db *sql.DB, cfg Config, logger *zap.Logger) audit.Store {
+	if cfg.AuditStoreDB {
+		// the postgres dsn is checked by the config validation
+		return audit.NewDBStore(db, logger)
+	}
+	if cfg.AuditStore == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.AuditStore), 0755); err != nil {
+		log.Fatalf("couldn't create audit store directory: %v", err)
+	}
+	store, err := audit.NewFileStore(cfg.AuditStore, cfg.AuditKeepMax, logger)
+	if err != nil {
+		log.Fatalf("couldn't open audit store: %v", err)
+	}
+	return store
+}
+
+func InitObservers(service service.MetricsService, store audit.Store, cfg Config, logger *zap.Logger) ([]observer.StatusReporter, []func(context.Context) error) {
+	policy, err := observer.ParseOverflowPolicy(cfg.AuditPolicy)
+	if err != nil {
+		log.Fatalf("invalid audit config: %v", err)
+	}
+	queueCfg := observer.QueueConfig{
+		Size:          cfg.AuditQueue,
+		Workers:       cfg.AuditWorkers,
+		Overflow:      policy,
+		SpillDir:      cfg.AuditSpill,
+		SpillMaxBytes: int64(cfg.AuditSpillMB) << 20,
+	}
+	if err := queueCfg.Validate(); err != nil {
+		log.Fatalf("invalid audit config: %v", err)
+	}
+	if policy == observer.OverflowSpill {
+		if err := os.MkdirAll(cfg.AuditSpill, 0700); err != nil {
+			log.Fatalf("couldn't create audit spill directory: %v", err)
+		}
+	}
+
+	var reporters []observer.StatusReporter
+	var queues []func(context.Context) error
+	var closers []func(context.Context) error
+	register := func(name string, o observer.Observer) {
+		q, err := observer.NewQueueObserver(name, o, queueCfg, logger)
+		if err != nil {
+			log.Fatalf("couldn't start %s audit queue: %v", name, err)
+		}
+		service.RegisterObserver(q)
+		reporters = append(reporters, q)
+		queues = append(queues, q.Close)
+	}
+
 	if cfg.AuditFile != "" {
-		fObs := &observer.FileObserver{
-			FilePath: cfg.AuditFile,
-			Logger:   logger,
+		var auditKey []byte
+		if cfg.AuditHMAC {
+			if cfg.KEY == "" {
+				log.Fatalf("couldn't sign the audit file: the key is empty")
+			}
+			auditKey = []byte(cfg.KEY)
 		}
 
-		service.RegisterObserver(fObs)
+		fObs, err := observer.NewFileObserver(observer.FileConfig{
+			Path:           cfg.AuditFile,
+			SyncInterval:   time.Duration(cfg.AuditFsync) * time.Second,
+			MaxSize:        int64(cfg.AuditMaxMB) << 20,
+			RotateInterval: time.Duration(cfg.AuditRotate) * time.Second,
+			MaxFiles:       cfg.AuditMaxFile,
+			Compress:       cfg.AuditGzip,
+			Key:            auditKey,
+		}, logger)
+		if err != nil {
+			log.Fatalf("couldn't start file observer: %v", err)
+		}
+
+		register("file", fObs)
+		closers = append(closers, fObs.Close)
+		go reopenOnHangup(fObs, logger)
 		logger.Info("file observer successfully registered in service")
 	}
 
 	if cfg.AuditURL != "" {
-		httpObs := &observer.HTTPObserver{
-			URL:    cfg.AuditURL,
-			Logger: logger,
+		if cfg.AuditSpool != "" {
+			if err := os.MkdirAll(cfg.AuditSpool, 0700); err != nil {
+				log.Fatalf("couldn't create audit spool directory: %v", err)
+			}
+		}
+		httpObs, err := observer.NewHTTPObserver(observer.HTTPConfig{
+			URL:           cfg.AuditURL,
+			BatchSize:     cfg.AuditBatch,
+			FlushInterval: time.Duration(cfg.AuditFlush) * time.Second,
+			Attempts:      cfg.AuditRetries,
+			Backoff:       time.Duration(cfg.AuditBackoff) * time.Millisecond,
+			MaxBackoff:    time.Duration(cfg.AuditMaxWait) * time.Millisecond,
+			Timeout:       time.Duration(cfg.AuditTimeout) * time.Second,
+			SpoolDir:      cfg.AuditSpool,
+			SpoolMaxBytes: int64(cfg.AuditSpoolMB) << 20,
+		}, logger)
+		if err != nil {
+			log.Fatalf("couldn't start http observer: %v", err)
 		}
 
-		service.RegisterObserver(httpObs)
+		register("http", httpObs)
+		reporters = append(reporters, httpObs)
+		closers = append(closers, httpObs.Close)
 		logger.Info("http observer successfully registered in service")
 	}
+
+	if cfg.AuditSyslog != "" {
+		facility, err := observer.ParseFacility(cfg.AuditFacil)
+		if err != nil {
+			log.Fatalf("invalid audit config: %v", err)
+		}
+
+		sObs, err := observer.NewSyslogObserver(observer.SyslogConfig{
+			Address:  cfg.AuditSyslog,
+			Facility: facility,
+			AppName:  cfg.AuditAppName,
+			Timeout:  time.Duration(cfg.AuditTimeout) * time.Second,
+		}, logger)
+		if err != nil {
+			log.Fatalf("couldn't start syslog observer: %v", err)
+		}
+
+		register("syslog", sObs)
+		closers = append(closers, sObs.Close)
+		logger.Info("syslog observer successfully registered in service")
+	}
+
+	if store != nil {
+		register("store", store)
+		closers = append(closers, store.Close)
+		logger.Info("audit store successfully registered in service")
+	}
+
+	// the queues are drained into the observers before the observers are closed
+	return reporters, append(queues, closers...)
+}
+
+// reopenOnHangup - reopens the audit file on every SIGHUP
+// lets logrotate move the file away without restarting the server
+func reopenOnHangup(fObs *observer.FileObserver, logger *zap.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		if err := fObs.Reopen(); err != nil {
+			logger.Error("Failed to reopen audit file", zap.Error(err))
+			continue
+		}
+		logger.Info("Audit file reopened")
+	}
 }
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"sync"
 	"time"
@@ -17,7 +18,7 @@ type Agent struct {
 	cfg       agentconfig.Config
 	storage   *LocalStorage
 	collector *Collector
-	sender    *Sender
+	sender    BatchSender
 
 	collectTicker *time.Ticker
 	sendTicker    *time.Ticker
@@ -27,6 +28,12 @@ type Agent struct {
 	cancel        context.CancelFunc
 }
 
+//BatchSender - interface for sending metric batches to the server
+//SendMetricsBatch - method for sending a batch of metrics
+type BatchSender interface {
+	SendMetricsBatch(batch []models.Metrics) error
+}
+
 //NewAgent - method for creating a new agent
 //create a new agent
 func NewAgent(cfg agentconfig.Config) *Agent {
@@ -34,9 +41,21 @@ func NewAgent(cfg agentconfig.Config) *Agent {
 	storage := NewLocalStorage()
 	collector := NewCollector(storage)
 	client := resty.New()
-	sender, err := NewSender(client, url, storage, cfg.Key, cfg.CryptoKey)
-	if err != nil {
-		fmt.Printf("load public key error: %v, continuing without encryption", err)
+	var sender BatchSender
+	if cfg.GRPCAddress != "" {
+		grpcSender, err := NewGRPCSender(cfg.GRPCAddress, cfg.Key)
+		if err != nil {
+			fmt.Printf("grpc sender error: %v, continuing with http", err)
+		} else {
+			sender = grpcSender
+		}
+	}
+	if sender == nil {
+		httpSender, err := NewSender(client, url, storage, cfg.Key, cfg.CryptoKey)
+		if err != nil {
+			fmt.Printf("load public key error: %v, continuing without encryption", err)
+		}
+		sender = httpSender
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -68,6 +87,9 @@ func (a *Agent) Run() {
 
 	<-a.ctx.Done()
 	a.wg.Wait()
+	if c, ok := a.sender.(io.Closer); ok {
+		c.Close()
+	}
 	fmt.Println("Agent was shutdown")
 }
 
@@ -117,6 +139,7 @@ func (a *Agent) runSender() {
 				a.metricsCh <- m
 			}
 			a.collector.ResetPollCount()
+			a.collector.ResetHistograms()
 		case <-a.ctx.Done():
 			return
 		}
@@ -3,6 +3,7 @@ package agent
 import (
 	"math/rand"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -11,12 +12,20 @@ import (
 	"github.com/shirou/gopsutil/v4/mem"
 )
 
+//gcPauseBounds - bucket bounds of the GC pause histogram in milliseconds
+var gcPauseBounds = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100}
+
 //Collector - struct for the collector
 //storage - metric storage
 //pollCount - poll count atomic integer metric
+//gcPauses - GC pause histogram accumulated since the last report
+//lastNumGC - number of GC cycles seen by the previous poll
 type Collector struct {
 	storage   CollectorStorageInterface
 	pollCount atomic.Int64
+	gcMu      sync.Mutex
+	gcPauses  *models.HistogramValue
+	lastNumGC uint32
 }
 
 //CollectorStorageInterface - interface for the collector storage
@@ -91,6 +100,37 @@ func (c *Collector) CollectRuntimeMetrics() {
 		MType: "gauge",
 		Value: &randomValue,
 	})
+
+	c.collectGCPauses(&m)
+}
+
+//collectGCPauses - method for collecting GC pauses
+//observe pauses of the GC cycles finished since the previous poll
+//the runtime keeps only the last 256 pauses, older ones are skipped
+func (c *Collector) collectGCPauses(m *runtime.MemStats) {
+	c.gcMu.Lock()
+	defer c.gcMu.Unlock()
+
+	if c.gcPauses == nil {
+		c.gcPauses = models.NewHistogram(gcPauseBounds)
+	}
+
+	from := c.lastNumGC
+	if m.NumGC-from > uint32(len(m.PauseNs)) {
+		from = m.NumGC - uint32(len(m.PauseNs))
+	}
+	for i := from; i < m.NumGC; i++ {
+		pause := m.PauseNs[i%uint32(len(m.PauseNs))]
+		c.gcPauses.Observe(float64(pause) / float64(time.Millisecond))
+	}
+	c.lastNumGC = m.NumGC
+
+	h := c.gcPauses.Clone()
+	c.storage.SetMetric("GCPauseMs", models.Metrics{
+		ID:        "GCPauseMs",
+		MType:     "histogram",
+		Histogram: &h,
+	})
 }
 
 //ResetPollCount - method for resetting the poll count
@@ -99,6 +139,15 @@ func (c *Collector) ResetPollCount() {
 	c.pollCount.Store(0)
 }
 
+//ResetHistograms - method for resetting the histograms
+//start accumulating observations from scratch after a report
+func (c *Collector) ResetHistograms() {
+	c.gcMu.Lock()
+	defer c.gcMu.Unlock()
+
+	c.gcPauses = models.NewHistogram(gcPauseBounds)
+}
+
 //CollectSysMetrics - method for collecting system metrics
 //collect the system metrics
 //set total memory and free memory metrics
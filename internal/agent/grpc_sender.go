@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/metricspb"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// grpcSendTimeout - timeout of one PushMetrics stream
+const grpcSendTimeout = 10 * time.Second
+
+// grpcChunkSize - metrics per stream message
+const grpcChunkSize = 100
+
+// GRPCSender - struct for the sender using the gRPC API of the server
+type GRPCSender struct {
+	conn   *grpc.ClientConn
+	client metricspb.MetricsClient
+	key    []byte
+}
+
+// NewGRPCSender - method for creating a new gRPC sender
+// the connection is established lazily on the first send
+// if error, return error
+func NewGRPCSender(addr string, key string) (*GRPCSender, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create grpc client for %s: %w", addr, err)
+	}
+
+	return &GRPCSender{
+		conn:   conn,
+		client: metricspb.NewMetricsClient(conn),
+		key:    []byte(key),
+	}, nil
+}
+
+// SendMetricsBatch - method for sending metrics batch to the server
+// the batch is sent over one PushMetrics stream in chunks
+// if error, return error
+// if success, return nil
+func (s *GRPCSender) SendMetricsBatch(batch []models.Metrics) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcSendTimeout)
+	defer cancel()
+
+	stream, err := s.client.PushMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open push stream: %w", err)
+	}
+
+	for start := 0; start < len(batch); start += grpcChunkSize {
+		end := min(start+grpcChunkSize, len(batch))
+
+		req := &metricspb.PushMetricsRequest{
+			Metrics: make([]*metricspb.Metric, 0, end-start),
+		}
+		for _, m := range batch[start:end] {
+			req.Metrics = append(req.Metrics, metricspb.MetricFromModel(m))
+		}
+
+		if len(s.key) > 0 {
+			hash, err := metricspb.Sign(req, s.key)
+			if err != nil {
+				return err
+			}
+			req.Hash = hash
+		}
+
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("failed to send metric batch: %w", err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("failed to send metric batch: %w", err)
+	}
+
+	log.Printf("Sending batch %v over grpc, accepted %v", len(batch), resp.GetAccepted())
+
+	return nil
+}
+
+// Close - method for closing the connection
+func (s *GRPCSender) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/grpcserver"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func TestGRPCSender_SendMetricsBatch(t *testing.T) {
+	storage := repository.NewStorage()
+	svc := service.NewService(storage, zap.NewNop())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	grpcserver.NewMetricsServer(svc, "secret", zap.NewNop()).Register(srv)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	sender, err := NewGRPCSender(ln.Addr().String(), "secret")
+	require.NoError(t, err)
+	defer sender.Close()
+
+	metrics := make([]models.Metrics, 0, len((&mockStorage{}).GetAll()))
+	for _, m := range (&mockStorage{}).GetAll() {
+		metrics = append(metrics, m)
+	}
+	require.NoError(t, sender.SendMetricsBatch(metrics))
+
+	gauge, ok := storage.GetGauge(context.Background(), "test_gauge")
+	assert.True(t, ok)
+	assert.Equal(t, 123.45, gauge)
+
+	counter, ok := storage.GetCounter(context.Background(), "test_counter")
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), counter)
+}
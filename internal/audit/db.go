@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+	"go.uber.org/zap"
+)
+
+// Queries of the database store
+const (
+	insertAuditEventQuery = `
+		INSERT INTO audit_events (ts, ip_address, metrics, names, event)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	selectAuditEventsQuery = `
+		SELECT id, event FROM audit_events
+	`
+
+	pruneAuditEventsQuery = `
+		DELETE FROM audit_events WHERE ts < $1
+	`
+)
+
+// DBStore - struct for the audit store on the audit_events table
+// the cursor is the id of the row
+type DBStore struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+// NewDBStore - constructor for DBStore
+// the table is created by the migrations
+func NewDBStore(db *sql.DB, logger *zap.Logger) *DBStore {
+	return &DBStore{db: db, logger: logger}
+}
+
+// Notify - method for inserting the event into the table
+func (s *DBStore) Notify(ctx context.Context, event observer.AuditEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Error("Failed to marshal audit event", zap.Error(err))
+		return
+	}
+
+	metrics := event.Metrics
+	if metrics == nil {
+		metrics = []string{}
+	}
+
+	_, err = s.db.ExecContext(ctx, insertAuditEventQuery,
+		time.Unix(int64(event.TimeStamp), 0).UTC(), event.IPAddress, metrics, metricNames(metrics), data)
+	if err != nil {
+		s.logger.Error("Failed to store audit event", zap.Error(err))
+	}
+}
+
+// Query - method for getting a page of the matching events
+// a metric matches the series keys or the names of the event
+// if error, return error
+func (s *DBStore) Query(ctx context.Context, q Query) (Page, error) {
+	if err := q.Validate(); err != nil {
+		return Page{}, err
+	}
+
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.Cursor != "" {
+		cursor, err := strconv.ParseInt(q.Cursor, 10, 64)
+		if err != nil || cursor < 1 {
+			return Page{}, ErrInvalidCursor
+		}
+		conds = append(conds, "id < "+arg(cursor))
+	}
+	if q.Metric != "" {
+		metric := []string{q.Metric}
+		conds = append(conds, fmt.Sprintf("(metrics @> %s OR names @> %s)", arg(metric), arg(metric)))
+	}
+	if q.IP != "" {
+		conds = append(conds, "ip_address = "+arg(q.IP))
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "ts >= "+arg(q.From.Truncate(time.Second).UTC()))
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "ts <= "+arg(q.To.UTC()))
+	}
+
+	query := selectAuditEventsQuery
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// one more row tells if there is a next page
+	query += " ORDER BY id DESC LIMIT " + arg(q.limit()+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("couldn't query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	page := Page{Events: make([]observer.AuditEvent, 0)}
+	var lastID int64
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return Page{}, fmt.Errorf("couldn't scan audit event: %w", err)
+		}
+
+		if len(page.Events) == q.limit() {
+			page.Next = strconv.FormatInt(lastID, 10)
+			break
+		}
+
+		var event observer.AuditEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return Page{}, fmt.Errorf("couldn't decode audit event: %w", err)
+		}
+		page.Events = append(page.Events, event)
+		lastID = id
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, fmt.Errorf("couldn't query audit events: %w", err)
+	}
+
+	return page, nil
+}
+
+// Prune - method for deleting the events older than before
+// if error, return error
+func (s *DBStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, pruneAuditEventsQuery, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("couldn't prune audit events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Close - method for closing the store
+// the database is closed by its owner
+func (s *DBStore) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,312 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+	"go.uber.org/zap"
+)
+
+// indexEntry - struct for the position and the filter fields of a stored event
+type indexEntry struct {
+	offset  int64
+	size    int
+	ts      int64
+	ip      string
+	metrics []string
+}
+
+// FileStore - struct for the audit store on an append-only file
+// the events are appended as json lines, the index of the lines is kept in memory
+// the index is rebuilt from the file on start
+// the oldest events are cut off the file once there are more than maxEvents of them,
+// so the index stays bounded, a quarter of maxEvents is cut at once
+// the cursor is the number of the event, the events cut off are still counted
+// so it stays valid while the store is open
+type FileStore struct {
+	path      string
+	maxEvents int
+	logger    *zap.Logger
+
+	mu     sync.RWMutex
+	file   *os.File
+	size   int64
+	index  []indexEntry
+	base   int
+	closed bool
+}
+
+// NewFileStore - constructor for FileStore
+// maxEvents - number of the events kept, 0 keeps all
+// the file is created if it doesn't exist, a torn last line is cut off
+// if error, return error
+func NewFileStore(path string, maxEvents int, logger *zap.Logger) (*FileStore, error) {
+	if maxEvents < 0 {
+		return nil, fmt.Errorf("invalid audit store size: %d", maxEvents)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open audit store: %w", err)
+	}
+
+	s := &FileStore{path: path, maxEvents: maxEvents, logger: logger, file: file}
+	if err := s.load(); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load - method for building the index from the file
+// if error, return error
+func (s *FileStore) load() error {
+	reader := bufio.NewReader(s.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var event observer.AuditEvent
+			if jerr := json.Unmarshal(line, &event); jerr != nil {
+				return fmt.Errorf("couldn't read audit store line %d: %w", len(s.index)+1, jerr)
+			}
+			s.index = append(s.index, newIndexEntry(s.size, len(line), event))
+			s.size += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't read audit store: %w", err)
+		}
+	}
+
+	if err := s.file.Truncate(s.size); err != nil {
+		return fmt.Errorf("couldn't truncate audit store: %w", err)
+	}
+	if s.maxEvents > 0 && len(s.index) > s.maxEvents {
+		return s.drop(len(s.index) - s.maxEvents)
+	}
+	return nil
+}
+
+// newIndexEntry - method for building the index entry of an event
+func newIndexEntry(offset int64, size int, event observer.AuditEvent) indexEntry {
+	return indexEntry{
+		offset:  offset,
+		size:    size,
+		ts:      int64(event.TimeStamp),
+		ip:      event.IPAddress,
+		metrics: event.Metrics,
+	}
+}
+
+// Notify - method for appending the event to the store
+func (s *FileStore) Notify(ctx context.Context, event observer.AuditEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Error("Failed to marshal audit event", zap.Error(err))
+		return
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.logger.Error("Audit event stored after the audit store was closed")
+		return
+	}
+
+	if _, err := s.file.WriteAt(data, s.size); err != nil {
+		s.logger.Error("Failed to write audit event to store", zap.Error(err))
+		return
+	}
+	s.index = append(s.index, newIndexEntry(s.size, len(data), event))
+	s.size += int64(len(data))
+
+	if s.maxEvents > 0 && len(s.index) >= s.maxEvents+max(s.maxEvents/4, 1) {
+		if err := s.drop(len(s.index) - s.maxEvents); err != nil {
+			s.logger.Error("Failed to cut off old audit events", zap.Error(err))
+		}
+	}
+}
+
+// Prune - method for removing the events older than before
+// the events are removed from the oldest one up to the first newer one
+// if error, return error
+func (s *FileStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, nil
+	}
+
+	n := 0
+	for n < len(s.index) && s.index[n].ts < before.Unix() {
+		n++
+	}
+	if err := s.drop(n); err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// drop - method for removing the n oldest events from the file and the index
+// the rest of the file is copied to a new file that replaces it
+// must be called with the lock held
+// if error, return error
+func (s *FileStore) drop(n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	shift := s.size
+	if n < len(s.index) {
+		shift = s.index[n].offset
+	}
+
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0640)
+	if err != nil {
+		return fmt.Errorf("couldn't create audit store: %w", err)
+	}
+	if _, err := io.Copy(file, io.NewSectionReader(s.file, shift, s.size-shift)); err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't copy audit store: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't sync audit store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't replace audit store: %w", err)
+	}
+
+	s.file.Close()
+	s.file = file
+
+	// a new slice, so the entries removed are freed
+	index := make([]indexEntry, len(s.index)-n)
+	for i, entry := range s.index[n:] {
+		entry.offset -= shift
+		index[i] = entry
+	}
+	s.index = index
+	s.size -= shift
+	s.base += n
+
+	return nil
+}
+
+// Query - method for getting a page of the matching events
+// the index is scanned from the cursor back to the oldest event
+// if error, return error
+func (s *FileStore) Query(ctx context.Context, q Query) (Page, error) {
+	if err := q.Validate(); err != nil {
+		return Page{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := len(s.index)
+	if q.Cursor != "" {
+		cursor, err := strconv.Atoi(q.Cursor)
+		if err != nil || cursor < 1 || cursor > s.base+len(s.index)+1 {
+			return Page{}, ErrInvalidCursor
+		}
+		// the events before the cursor could have been cut off
+		start = max(cursor-1-s.base, 0)
+	}
+
+	page := Page{Events: make([]observer.AuditEvent, 0)}
+	for i := start - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return Page{}, err
+		}
+
+		entry := s.index[i]
+		if !entry.matches(q) {
+			continue
+		}
+
+		if len(page.Events) == q.limit() {
+			page.Next = strconv.Itoa(s.base + i + 2)
+			break
+		}
+
+		event, err := s.read(entry)
+		if err != nil {
+			return Page{}, err
+		}
+		page.Events = append(page.Events, event)
+	}
+
+	return page, nil
+}
+
+// read - method for reading the event of an index entry
+// must be called with the lock held
+func (s *FileStore) read(entry indexEntry) (observer.AuditEvent, error) {
+	data := make([]byte, entry.size)
+	if _, err := s.file.ReadAt(data, entry.offset); err != nil {
+		return observer.AuditEvent{}, fmt.Errorf("couldn't read audit store: %w", err)
+	}
+
+	var event observer.AuditEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return observer.AuditEvent{}, fmt.Errorf("couldn't decode audit event: %w", err)
+	}
+	return event, nil
+}
+
+// matches - method for checking the entry against the query filters
+func (e indexEntry) matches(q Query) bool {
+	if q.IP != "" && e.ip != q.IP {
+		return false
+	}
+	if !q.From.IsZero() && time.Unix(e.ts, 0).Before(q.From.Truncate(time.Second)) {
+		return false
+	}
+	if !q.To.IsZero() && time.Unix(e.ts, 0).After(q.To) {
+		return false
+	}
+	if q.Metric == "" {
+		return true
+	}
+	for _, key := range e.metrics {
+		if matchesMetric(key, q.Metric) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close - method for syncing and closing the store file
+// if error, return error
+func (s *FileStore) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func timestamps(events []observer.AuditEvent) []int {
+	ts := make([]int, 0, len(events))
+	for _, e := range events {
+		ts = append(ts, e.TimeStamp)
+	}
+	return ts
+}
+
+func newFileStore(t *testing.T) (*FileStore, string) {
+	path := filepath.Join(t.TempDir(), "audit.store")
+	store, err := NewFileStore(path, 0, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close(context.Background()) })
+
+	events := []observer.AuditEvent{
+		{TimeStamp: 100, Metrics: []string{"load"}, IPAddress: "10.0.0.1"},
+		{TimeStamp: 200, Metrics: []string{`requests{path="/a"}`}, IPAddress: "10.0.0.2"},
+		{TimeStamp: 300, Metrics: []string{"load", "requests"}, IPAddress: "10.0.0.1"},
+		{TimeStamp: 400, Metrics: []string{"requests_total"}, IPAddress: "10.0.0.1"},
+		{TimeStamp: 500, Metrics: []string{"load"}, IPAddress: "10.0.0.2"},
+	}
+	for _, e := range events {
+		store.Notify(context.Background(), e)
+	}
+
+	return store, path
+}
+
+func TestFileStore_Query(t *testing.T) {
+	store, _ := newFileStore(t)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []int
+	}{
+		{name: "all", query: Query{}, want: []int{500, 400, 300, 200, 100}},
+		{name: "metric name", query: Query{Metric: "requests"}, want: []int{300, 200}},
+		{name: "series key", query: Query{Metric: `requests{path="/a"}`}, want: []int{200}},
+		{name: "ip", query: Query{IP: "10.0.0.2"}, want: []int{500, 200}},
+		{name: "time range", query: Query{From: time.Unix(200, 0), To: time.Unix(400, 0)}, want: []int{400, 300, 200}},
+		{name: "combined", query: Query{Metric: "load", IP: "10.0.0.1", To: time.Unix(250, 0)}, want: []int{100}},
+		{name: "nothing", query: Query{Metric: "missing"}, want: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := store.Query(context.Background(), tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, timestamps(page.Events))
+			assert.Empty(t, page.Next)
+		})
+	}
+}
+
+func TestFileStore_Pagination(t *testing.T) {
+	store, _ := newFileStore(t)
+
+	q := Query{Metric: "load", Limit: 2}
+	page, err := store.Query(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, []int{500, 300}, timestamps(page.Events))
+	require.NotEmpty(t, page.Next)
+
+	q.Cursor = page.Next
+	page, err = store.Query(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, []int{100}, timestamps(page.Events))
+	assert.Empty(t, page.Next)
+
+	_, err = store.Query(context.Background(), Query{Cursor: "abc"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = store.Query(context.Background(), Query{Limit: MaxLimit + 1})
+	assert.Error(t, err)
+}
+
+func TestFileStore_Reopen(t *testing.T) {
+	store, path := newFileStore(t)
+	require.NoError(t, store.Close(context.Background()))
+
+	// a crash in the middle of a write leaves a torn line
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0640)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"ts":600,"metr`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store, err = NewFileStore(path, 0, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+
+	store.Notify(context.Background(), observer.AuditEvent{TimeStamp: 700, Metrics: []string{"load"}, IPAddress: "10.0.0.3"})
+
+	page, err := store.Query(context.Background(), Query{Metric: "load"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{700, 500, 300, 100}, timestamps(page.Events))
+}
+
+func TestFileStore_Prune(t *testing.T) {
+	store, path := newFileStore(t)
+
+	q := Query{Limit: 2}
+	page, err := store.Query(context.Background(), q)
+	require.NoError(t, err)
+	require.Equal(t, []int{500, 400}, timestamps(page.Events))
+
+	removed, err := store.Prune(context.Background(), time.Unix(300, 0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	// the cursor taken before the prune still points to the same events
+	q.Cursor = page.Next
+	page, err = store.Query(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, []int{300}, timestamps(page.Events))
+	assert.Empty(t, page.Next)
+
+	// the events are cut off the file too
+	require.NoError(t, store.Close(context.Background()))
+	store, err = NewFileStore(path, 0, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+	page, err = store.Query(context.Background(), Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{500, 400, 300}, timestamps(page.Events))
+}
+
+func TestFileStore_MaxEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.store")
+	store, err := NewFileStore(path, 4, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 10; ts++ {
+		store.Notify(context.Background(), observer.AuditEvent{TimeStamp: ts, Metrics: []string{"load"}, IPAddress: "10.0.0.1"})
+	}
+	page, err := store.Query(context.Background(), Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{10, 9, 8, 7}, timestamps(page.Events))
+	require.NoError(t, store.Close(context.Background()))
+
+	// a store reopened with a lower limit is cut off on start
+	store, err = NewFileStore(path, 2, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+	page, err = store.Query(context.Background(), Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{10, 9}, timestamps(page.Events))
+}
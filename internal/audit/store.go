@@ -0,0 +1,100 @@
+// Package audit keeps the audit events queryable by metric, client and time
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+)
+
+// Limits of the page size
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// ErrInvalidCursor - the cursor wasn't returned by a previous query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query - struct for the filters of an audit query
+// Metric - metric name or series key, empty matches every metric
+// IP - client address, empty matches every client
+// From, To - time range including the bounds, zero leaves the side open
+// Limit - events per page, 0 means DefaultLimit
+// Cursor - Next of the previous page, empty starts from the newest event
+type Query struct {
+	Metric string
+	IP     string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Cursor string
+}
+
+// Validate - method for validating the query
+// if error, return error
+func (q Query) Validate() error {
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("invalid limit: %d, must be from 1 to %d", q.Limit, MaxLimit)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return errors.New("to is before from")
+	}
+	return nil
+}
+
+// limit - method for getting the page size
+func (q Query) limit() int {
+	if q.Limit == 0 {
+		return DefaultLimit
+	}
+	return q.Limit
+}
+
+// Page - struct for a page of the query result
+// Events - matching events from the newest to the oldest
+// Next - cursor of the next page, empty on the last page
+type Page struct {
+	Events []observer.AuditEvent `json:"events"`
+	Next   string                `json:"next,omitempty"`
+}
+
+// Store - interface for the audit store
+// the store gets the events as an observer
+// Query - method for getting a page of the matching events
+// Prune - method for removing the events older than before, returns the number removed
+// Close - method for closing the store
+type Store interface {
+	observer.Observer
+	Query(ctx context.Context, q Query) (Page, error)
+	Prune(ctx context.Context, before time.Time) (int64, error)
+	Close(ctx context.Context) error
+}
+
+// matchesMetric - method for checking if the series key belongs to the queried metric
+// the metric is either the series key or the name of the series
+func matchesMetric(key, metric string) bool {
+	return key == metric || strings.HasPrefix(key, metric+"{")
+}
+
+// metricNames - method for getting the distinct metric names of the series keys
+func metricNames(keys []string) []string {
+	names := make([]string, 0, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		name := key
+		if i := strings.IndexByte(key, '{'); i >= 0 {
+			name = key[:i]
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,183 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State - state of the circuit breaker
+type State int
+
+const (
+	// Closed - calls are let through, failures are counted
+	Closed State = iota
+	// Open - calls are rejected until the cooldown is over
+	Open
+	// HalfOpen - the cooldown is over, a single probe call is let through
+	HalfOpen
+)
+
+// String - method for getting the name of the state
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrOpen - error returned when the breaker rejects a call
+// the returned *OpenError matches it with errors.Is
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError - error returned when the breaker rejects a call
+// RetryAfter - time left until the breaker lets a probe call through
+type OpenError struct {
+	RetryAfter time.Duration
+}
+
+// Error - method for getting the error message
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrOpen, e.RetryAfter)
+}
+
+// Is - method for matching the error with ErrOpen
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Breaker - struct for the circuit breaker
+// the breaker opens after threshold consecutive failures
+// after the cooldown one probe call is let through, its outcome closes or reopens the breaker
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// New - constructor for Breaker
+// threshold - consecutive failures that open the breaker
+// cooldown - time the breaker stays open before a probe call
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow - method for asking the breaker to let a call through
+// every allowed call must be followed by Success, Failure or Release
+// if the breaker is open, return *OpenError
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.current() {
+	case Closed:
+		return nil
+	case HalfOpen:
+		if b.probing {
+			return &OpenError{RetryAfter: b.cooldown}
+		}
+		b.state = HalfOpen
+		b.probing = true
+		return nil
+	default:
+		return &OpenError{RetryAfter: b.retryAfter()}
+	}
+}
+
+// Success - method for reporting a successful call
+// closes the breaker and resets the failure count
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = Closed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure - method for reporting a failed call
+// opens the breaker if the threshold is reached or the probe call failed
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Closed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.trip()
+		}
+	case HalfOpen:
+		b.trip()
+	}
+}
+
+// Release - method for reporting a call that tells nothing about the backend
+// for example, a call canceled by the client
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State - method for getting the state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.current()
+}
+
+// RetryAfter - method for getting the time left until the breaker lets a probe call through
+// returns 0 if the breaker is not open
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.current() != Open {
+		return 0
+	}
+	return b.retryAfter()
+}
+
+// current - method for getting the state with the cooldown taken into account
+// must be called with the lock held
+func (b *Breaker) current() State {
+	if b.state == Open && b.retryAfter() <= 0 {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// retryAfter - method for getting the time left of the cooldown
+// must be called with the lock held
+func (b *Breaker) retryAfter() time.Duration {
+	return b.cooldown - b.now().Sub(b.openedAt)
+}
+
+// trip - method for opening the breaker
+// must be called with the lock held
+func (b *Breaker) trip() {
+	b.state = Open
+	b.openedAt = b.now()
+	b.failures = 0
+	b.probing = false
+}
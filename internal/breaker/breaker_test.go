@@ -0,0 +1,63 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := New(3, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, Closed, b.State())
+
+	require.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+
+	err := b.Allow()
+	require.ErrorIs(t, err, ErrOpen)
+	var openErr *OpenError
+	require.True(t, errors.As(err, &openErr))
+	assert.Equal(t, 10*time.Second, openErr.RetryAfter)
+
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, 6*time.Second, b.RetryAfter())
+
+	now = now.Add(6 * time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+	require.NoError(t, b.Allow())
+	assert.ErrorIs(t, b.Allow(), ErrOpen, "only one probe is let through")
+
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, b.Allow())
+	b.Release()
+	require.NoError(t, b.Allow(), "released probe frees the slot")
+
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, time.Duration(0), b.RetryAfter())
+	assert.NoError(t, b.Allow())
+}
+
+func TestBreaker_SuccessResetsFailures(t *testing.T) {
+	b := New(2, time.Second)
+
+	b.Failure()
+	b.Success()
+	b.Failure()
+
+	assert.Equal(t, Closed, b.State())
+}
@@ -13,6 +13,7 @@ type Config struct {
 	Key            string `env:"KEY"`
 	RateLimit      int    `env:"RATE_LIMIT"`
 	CryptoKey      string `json:"crypto_key" env:"CRYPTO_KEY"`
+	GRPCAddress    string `json:"grpc_address" env:"GRPC_ADDRESS"`
 	Config         string `env:"CONFIG"`
 }
 
@@ -24,6 +25,7 @@ func SetConfig() (Config, error) {
 		Key:            "",
 		RateLimit:      3,
 		CryptoKey:      "",
+		GRPCAddress:    "",
 		Config:         "",
 	}
 
@@ -33,6 +35,7 @@ func SetConfig() (Config, error) {
 	var key string
 	var rateLim int
 	var cryptoKey string
+	var grpcAddress string
 
 	bind := func(fs *flag.FlagSet) {
 		fs.StringVar(&address, "a", ":8080", "Server port")
@@ -41,6 +44,7 @@ func SetConfig() (Config, error) {
 		fs.StringVar(&key, "k", "", "Key value")
 		fs.IntVar(&rateLim, "l", 3, "Rate limit value")
 		fs.StringVar(&cryptoKey, "crypto-key", "", "crypto-key file path")
+		fs.StringVar(&grpcAddress, "grpc-address", "", "server grpc address, sends over http if empty")
 	}
 
 	apply := func(name string) {
@@ -57,6 +61,8 @@ func SetConfig() (Config, error) {
 			cfg.RateLimit = rateLim
 		case "crypto-key":
 			cfg.CryptoKey = cryptoKey
+		case "grpc-address":
+			cfg.GRPCAddress = grpcAddress
 		}
 	}
 
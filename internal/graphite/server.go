@@ -0,0 +1,198 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"go.uber.org/zap"
+)
+
+// idleTimeout - time after which a silent connection is closed
+const idleTimeout = 2 * time.Minute
+
+// acceptDelay, maxAcceptDelay - first and longest pause after a failed accept,
+// the pause doubles while the accepts keep failing
+const (
+	acceptDelay    = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// Server - struct for the Graphite plaintext TCP listener
+// every "path value timestamp" line is stored as a gauge
+type Server struct {
+	addr      string
+	templates []Template
+	service   service.MetricsService
+	logger    *zap.Logger
+
+	sem   chan struct{}
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer - method for creating a new Graphite server
+// maxConns - limit of simultaneous connections, extra connections are closed at once
+func NewServer(addr string, maxConns int, templates []Template, service service.MetricsService, logger *zap.Logger) *Server {
+	return &Server{
+		addr:      addr,
+		templates: templates,
+		service:   service,
+		logger:    logger,
+		sem:       make(chan struct{}, maxConns),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe - method for listening on the TCP address until the context is done
+// if error, return error
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+
+	return s.Serve(ctx, ln)
+}
+
+// Serve - method for accepting connections until the context is done
+// a failed accept is retried after a growing pause, a closed listener stops the server
+// on return the listener and all open connections are closed
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		s.closeConns()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+
+			delay = min(max(2*delay, acceptDelay), maxAcceptDelay)
+			s.logger.Warn("Failed to accept graphite connection", zap.Error(err), zap.Duration("retry_in", delay))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		delay = 0
+
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			s.logger.Warn("Graphite connection limit reached", zap.String("remote", conn.RemoteAddr().String()))
+			conn.Close()
+			continue
+		}
+
+		s.track(conn, true)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.sem }()
+			defer s.track(conn, false)
+			defer conn.Close()
+
+			s.handleConn(ctx, conn)
+		}()
+	}
+
+	s.wg.Wait()
+	return nil
+}
+
+// track - method for adding or removing an open connection
+func (s *Server) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		s.conns[conn] = struct{}{}
+		return
+	}
+	delete(s.conns, conn)
+}
+
+// closeConns - method for closing all open connections
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// handleConn - method for reading lines until the peer closes the connection
+// invalid lines are logged and skipped
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if err := s.HandleLine(ctx, line); err != nil {
+			s.logger.Debug("Skipping invalid graphite line", zap.String("line", line), zap.Error(err))
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		s.logger.Debug("Graphite connection closed", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+	}
+}
+
+// HandleLine - method for storing one "path value [timestamp]" line as a gauge
+// the timestamp is validated, samples are stored with the receive time
+// if error, return error
+func (s *Server) HandleLine(ctx context.Context, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return fmt.Errorf("expected path, value and timestamp")
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	if len(fields) == 3 {
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			return fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+	}
+
+	id, labels := fields[0], map[string]string(nil)
+	if t, ok := match(s.templates, fields[0]); ok {
+		id, labels = t.Apply(fields[0])
+	}
+
+	if err := models.ValidateSeries(id, labels); err != nil {
+		return err
+	}
+
+	return s.service.UpdateGauge(ctx, models.SeriesKey(id, labels), value)
+}
@@ -0,0 +1,91 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer_Serve(t *testing.T) {
+	storage := repository.NewStorage()
+	svc := service.NewService(storage, zap.NewNop())
+	templates, err := ParseTemplates("servers.* .host.measurement*")
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer("", 1, templates, svc, zap.NewNop())
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(ctx, ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	fmt.Fprintf(conn, "servers.web03.cpu.load 1.5 %d\nbroken line\nplain.metric 7\n", time.Now().Unix())
+
+	key := models.SeriesKey("cpu_load", map[string]string{"host": "web03"})
+	require.Eventually(t, func() bool {
+		_, ok := storage.GetGauge(context.Background(), "plain.metric")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	value, ok := storage.GetGauge(context.Background(), key)
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, value)
+
+	// the second connection is over the limit and is closed at once
+	extra, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	extra.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = extra.Read(make([]byte, 1))
+	assert.Error(t, err)
+	extra.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server didn't stop with open connections")
+	}
+	conn.Close()
+}
+
+// failingListener - listener that fails every accept until it is closed
+type failingListener struct {
+	net.Listener
+	accepts int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts++
+	if l.accepts > 3 {
+		return nil, net.ErrClosed
+	}
+	return nil, errors.New("too many open files")
+}
+
+func TestServer_ServeAcceptErrors(t *testing.T) {
+	ln := &failingListener{}
+	s := NewServer("", 1, nil, nil, zap.NewNop())
+
+	start := time.Now()
+	require.NoError(t, s.Serve(context.Background(), ln))
+
+	// the failed accepts are retried after a pause, a closed listener stops the server
+	assert.Equal(t, 4, ln.accepts)
+	assert.GreaterOrEqual(t, time.Since(start), acceptDelay+2*acceptDelay+4*acceptDelay)
+}
@@ -0,0 +1,105 @@
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// measurementTag - template tag for the parts joined into the metric ID
+const measurementTag = "measurement"
+
+// Template - struct for a rule turning a dotted path into a metric ID and labels
+// Filter - path.Match pattern on the dotted path, empty matches everything
+// Tags - tag per path part, "measurement" parts form the ID joined with "_",
+// a trailing "measurement*" takes all remaining parts, an empty tag skips the part,
+// any other tag becomes a label with the part as its value
+type Template struct {
+	Filter string
+	Tags   []string
+}
+
+// ParseTemplates - parses rules separated by ";" in the form "[filter ]template"
+// for example "servers.* .host.measurement*;measurement*"
+// if error, return error
+func ParseTemplates(s string) ([]Template, error) {
+	var templates []Template
+
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		var t Template
+		fields := strings.Fields(rule)
+		switch len(fields) {
+		case 1:
+			t.Tags = strings.Split(fields[0], ".")
+		case 2:
+			if _, err := path.Match(fields[0], ""); err != nil {
+				return nil, fmt.Errorf("invalid template filter %q: %w", fields[0], err)
+			}
+			t.Filter = fields[0]
+			t.Tags = strings.Split(fields[1], ".")
+		default:
+			return nil, fmt.Errorf("invalid template %q", rule)
+		}
+
+		for i, tag := range t.Tags {
+			if tag == measurementTag+"*" && i != len(t.Tags)-1 {
+				return nil, fmt.Errorf("template %q: %q must be the last part", rule, tag)
+			}
+		}
+
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+// Apply - method for building the metric ID and labels from a dotted path
+// if the template has no measurement parts, the whole path is the ID
+func (t Template) Apply(p string) (string, map[string]string) {
+	parts := strings.Split(p, ".")
+	var id []string
+	var labels map[string]string
+
+	for i, tag := range t.Tags {
+		if i >= len(parts) {
+			break
+		}
+
+		switch tag {
+		case "":
+		case measurementTag:
+			id = append(id, parts[i])
+		case measurementTag + "*":
+			id = append(id, parts[i:]...)
+		default:
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[tag] = parts[i]
+		}
+	}
+
+	if len(id) == 0 {
+		return p, labels
+	}
+
+	return strings.Join(id, "_"), labels
+}
+
+// match - finds the first template whose filter matches the path
+func match(templates []Template, p string) (Template, bool) {
+	for _, t := range templates {
+		if t.Filter == "" {
+			return t, true
+		}
+		if ok, _ := path.Match(t.Filter, p); ok {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
@@ -0,0 +1,56 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate_Apply(t *testing.T) {
+	templates, err := ParseTemplates("servers.* .host.measurement*; stats.* .measurement.region.measurement")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantID     string
+		wantLabels map[string]string
+	}{
+		{
+			name:       "Host and remaining parts",
+			path:       "servers.web03.cpu.load",
+			wantID:     "cpu_load",
+			wantLabels: map[string]string{"host": "web03"},
+		},
+		{
+			name:       "Measurement parts around a label",
+			path:       "stats.requests.eu.count",
+			wantID:     "requests_count",
+			wantLabels: map[string]string{"region": "eu"},
+		},
+		{
+			name:   "No matching template",
+			path:   "other.metric",
+			wantID: "other.metric",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, labels := tt.path, map[string]string(nil)
+			if tmpl, ok := match(templates, tt.path); ok {
+				id, labels = tmpl.Apply(tt.path)
+			}
+			assert.Equal(t, tt.wantID, id)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestParseTemplates_Invalid(t *testing.T) {
+	_, err := ParseTemplates("measurement*.host")
+	assert.Error(t, err)
+
+	_, err = ParseTemplates("a b c")
+	assert.Error(t, err)
+}
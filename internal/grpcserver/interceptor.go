@@ -0,0 +1,44 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryLogging - interceptor for logging unary calls
+// shows the method, status code and duration
+func UnaryLogging(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		logger.Sugar().Infoln(
+			"method", info.FullMethod,
+			"code", status.Code(err),
+			"duration", time.Since(start),
+		)
+
+		return resp, err
+	}
+}
+
+// StreamLogging - interceptor for logging streaming calls
+// shows the method, status code and duration
+func StreamLogging(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+
+		logger.Sugar().Infoln(
+			"method", info.FullMethod,
+			"code", status.Code(err),
+			"duration", time.Since(start),
+		)
+
+		return err
+	}
+}
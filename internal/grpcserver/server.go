@@ -0,0 +1,313 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
+	"github.com/makimaki04/go-metrics-agent.git/internal/metricspb"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsServer - struct for the gRPC metrics service
+// backed by the same service.MetricsService as the HTTP handlers
+type MetricsServer struct {
+	metricspb.UnimplementedMetricsServer
+
+	service service.MetricsService
+	key     []byte
+	logger  *zap.Logger
+	hub     *watchHub
+}
+
+// NewMetricsServer - method for creating a new gRPC metrics service
+// the service gets an observer that feeds the Watch streams
+// key - key for checking the request hashes, empty disables the check
+func NewMetricsServer(service service.MetricsService, key string, logger *zap.Logger) *MetricsServer {
+	hub := newWatchHub()
+	service.RegisterObserver(hub)
+
+	return &MetricsServer{
+		service: service,
+		key:     []byte(key),
+		logger:  logger,
+		hub:     hub,
+	}
+}
+
+// Register - method for registering the service on the gRPC server
+func (s *MetricsServer) Register(srv *grpc.Server) {
+	metricspb.RegisterMetricsServer(srv, s)
+}
+
+// Close - method for ending all Watch streams
+func (s *MetricsServer) Close() {
+	s.hub.close()
+}
+
+// Update - method for updating a metric
+// if the hash doesn't match, return unauthenticated
+// if the metric is invalid, return invalid argument
+// if success, return the stored value of the metric
+func (s *MetricsServer) Update(ctx context.Context, req *metricspb.UpdateRequest) (*metricspb.UpdateResponse, error) {
+	if len(s.key) > 0 && !metricspb.Verify(req, s.key) {
+		return nil, status.Error(codes.Unauthenticated, "hash mismatch")
+	}
+
+	metric, err := s.metricFromProto(req.GetMetric())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.service.UpdateMetric(s.withRequest(ctx), metric); err != nil {
+		return nil, status.Errorf(storageCode(err), "failed to update metric: %v", err)
+	}
+
+	stored, ok := s.lookup(ctx, metric.MType, metric.SeriesKey())
+	if !ok {
+		return nil, status.Error(codes.Internal, "metric not found after update")
+	}
+
+	return &metricspb.UpdateResponse{Metric: metricspb.MetricFromModel(stored)}, nil
+}
+
+// Get - method for getting the value of a metric
+// the write times of the series, if the storage tracks them, are sent
+// in the created-at and last-updated headers as RFC 3339 times
+// if the metric doesn't exist, return not found
+func (s *MetricsServer) Get(ctx context.Context, req *metricspb.GetRequest) (*metricspb.GetResponse, error) {
+	mType, err := metricspb.TypeToModel(req.GetType())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	key := models.SeriesKey(req.GetId(), req.GetLabels())
+	metric, ok := s.lookup(ctx, mType, key)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "metric not found")
+	}
+
+	if times, ok := s.service.GetTimes(ctx, mType, key); ok {
+		grpc.SetHeader(ctx, metadata.Pairs(
+			"created-at", times.CreatedAt.UTC().Format(time.RFC3339Nano),
+			"last-updated", times.LastUpdated.UTC().Format(time.RFC3339Nano),
+		))
+	}
+
+	return &metricspb.GetResponse{Metric: metricspb.MetricFromModel(metric)}, nil
+}
+
+// PushMetrics - method for updating metrics from a client stream
+// every stream message is stored as one batch
+// if a message is invalid, the stream is aborted and the earlier batches stay stored
+// if success, return the number of stored metrics
+func (s *MetricsServer) PushMetrics(stream grpc.ClientStreamingServer[metricspb.PushMetricsRequest, metricspb.PushMetricsResponse]) error {
+	ctx := s.withRequest(stream.Context())
+	var accepted uint64
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&metricspb.PushMetricsResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(s.key) > 0 && !metricspb.Verify(req, s.key) {
+			return status.Error(codes.Unauthenticated, "hash mismatch")
+		}
+
+		batch := make([]models.Metrics, 0, len(req.GetMetrics()))
+		for _, pm := range req.GetMetrics() {
+			metric, err := s.metricFromProto(pm)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, metric)
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := s.service.UpdateMetricBatch(ctx, batch); err != nil {
+			return status.Errorf(storageCode(err), "failed to update metrics: %v", err)
+		}
+		accepted += uint64(len(batch))
+	}
+}
+
+// Watch - method for streaming the values of updated metrics
+// the stream ends when the client leaves or the server is closed
+func (s *MetricsServer) Watch(req *metricspb.WatchRequest, stream grpc.ServerStreamingServer[metricspb.Metric]) error {
+	var mType string
+	if req.GetType() != metricspb.Metric_UNSPECIFIED {
+		t, err := metricspb.TypeToModel(req.GetType())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		mType = t
+	}
+
+	ids := make(map[string]bool, len(req.GetIds()))
+	for _, id := range req.GetIds() {
+		ids[id] = true
+	}
+
+	ch, unsubscribe := s.hub.subscribe()
+	defer unsubscribe()
+
+	// headers tell the client that updates are delivered from now on
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case key, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			name, _, err := models.ParseSeriesKey(key)
+			if err != nil || (len(ids) > 0 && !ids[name]) {
+				continue
+			}
+
+			for _, t := range []string{models.Gauge, models.Counter, models.Histogram} {
+				if mType != "" && mType != t {
+					continue
+				}
+				metric, ok := s.lookup(ctx, t, key)
+				if !ok {
+					continue
+				}
+				if err := stream.Send(metricspb.MetricFromModel(metric)); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// metricFromProto - method for converting and validating a metric of a request
+func (s *MetricsServer) metricFromProto(pm *metricspb.Metric) (models.Metrics, error) {
+	if pm == nil {
+		return models.Metrics{}, status.Error(codes.InvalidArgument, "metric is empty")
+	}
+
+	metric, err := metricspb.MetricToModel(pm)
+	if err != nil {
+		return models.Metrics{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := models.ValidateSeries(metric.ID, metric.Labels); err != nil {
+		return models.Metrics{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return metric, nil
+}
+
+// lookup - method for getting the stored value of a series
+func (s *MetricsServer) lookup(ctx context.Context, mType string, key string) (models.Metrics, bool) {
+	name, labels, err := models.ParseSeriesKey(key)
+	if err != nil {
+		return models.Metrics{}, false
+	}
+	metric := models.Metrics{ID: name, MType: mType, Labels: labels}
+
+	switch mType {
+	case models.Gauge:
+		v, ok := s.service.GetGauge(ctx, key)
+		if !ok {
+			return metric, false
+		}
+		metric.Value = &v
+	case models.Counter:
+		d, ok := s.service.GetCounter(ctx, key)
+		if !ok {
+			return metric, false
+		}
+		metric.Delta = &d
+	case models.Histogram:
+		h, ok := s.service.GetHistogram(ctx, key)
+		if !ok {
+			return metric, false
+		}
+		metric.Histogram = &h
+	default:
+		return metric, false
+	}
+
+	return metric, true
+}
+
+// withRequest - puts the request info into the context for the audit events
+// the peer address, method, user agent and x-request-id come from the call
+// a missing or invalid request ID is replaced with a generated one and sent back in the header
+func (s *MetricsServer) withRequest(ctx context.Context) context.Context {
+	info := observer.RequestInfo{
+		IPAddress: "unknown",
+		Signed:    len(s.key) > 0,
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		info.IPAddress = p.Addr.String()
+		if host, _, err := net.SplitHostPort(info.IPAddress); err == nil {
+			info.IPAddress = host
+		}
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chains := tlsInfo.State.VerifiedChains
+			if len(chains) > 0 && len(chains[0]) > 0 {
+				info.Identity = chains[0][0].Subject.CommonName
+			}
+		}
+	}
+
+	if method, ok := grpc.Method(ctx); ok {
+		info.Endpoint = method
+		info.Method = "gRPC"
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			info.UserAgent = ua[0]
+		}
+		if id := md.Get("x-request-id"); len(id) > 0 {
+			info.RequestID = id[0]
+		}
+	}
+	info.RequestID = observer.RequestID(info.RequestID)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", info.RequestID))
+
+	return observer.WithRequest(ctx, info)
+}
+
+// storageCode - method for getting the status code of a storage error
+// the open circuit breaker is reported as unavailable, so clients back off
+// a metric that can't be stored is reported as an invalid argument, it must not be retried
+func storageCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, breaker.ErrOpen):
+		return codes.Unavailable
+	case errors.Is(err, models.ErrInvalidMetric):
+		return codes.InvalidArgument
+	}
+	return codes.Internal
+}
@@ -0,0 +1,270 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
+	"github.com/makimaki04/go-metrics-agent.git/internal/metricspb"
+	"github.com/makimaki04/go-metrics-agent.git/internal/migrations"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T, key string) (metricspb.MetricsClient, *MetricsServer) {
+	t.Helper()
+	return newTestClientWith(t, key, repository.NewStorage())
+}
+
+// newTestClientWith - test client of a server on the storage
+func newTestClientWith(t *testing.T, key string, storage repository.Repository) (metricspb.MetricsClient, *MetricsServer) {
+	t.Helper()
+
+	svc := service.NewService(storage, zap.NewNop())
+
+	ln := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	metricsServer := NewMetricsServer(svc, key, zap.NewNop())
+	metricsServer.Register(srv)
+	go srv.Serve(ln)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		metricsServer.Close()
+		srv.Stop()
+	})
+
+	return metricspb.NewMetricsClient(conn), metricsServer
+}
+
+func TestMetricsServer_UpdateGet(t *testing.T) {
+	d := int64(5)
+	v := 1.5
+
+	tests := []struct {
+		name     string
+		key      string
+		sign     bool
+		metric   *metricspb.Metric
+		wantCode codes.Code
+		want     *metricspb.Metric
+	}{
+		{
+			name:     "Counter",
+			metric:   &metricspb.Metric{Id: "jobs", Type: metricspb.Metric_COUNTER, Delta: &d},
+			wantCode: codes.OK,
+			want:     &metricspb.Metric{Id: "jobs", Type: metricspb.Metric_COUNTER, Delta: &d},
+		},
+		{
+			name:     "Gauge with labels and hash",
+			key:      "secret",
+			sign:     true,
+			metric:   &metricspb.Metric{Id: "cpu", Type: metricspb.Metric_GAUGE, Labels: map[string]string{"host": "web03"}, Value: &v},
+			wantCode: codes.OK,
+			want:     &metricspb.Metric{Id: "cpu", Type: metricspb.Metric_GAUGE, Labels: map[string]string{"host": "web03"}, Value: &v},
+		},
+		{
+			name:     "Missing hash",
+			key:      "secret",
+			metric:   &metricspb.Metric{Id: "cpu", Type: metricspb.Metric_GAUGE, Value: &v},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "Unknown type",
+			metric:   &metricspb.Metric{Id: "cpu", Value: &v},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTestClient(t, tt.key)
+			ctx := context.Background()
+
+			req := &metricspb.UpdateRequest{Metric: tt.metric}
+			if tt.sign {
+				hash, err := metricspb.Sign(req, []byte(tt.key))
+				require.NoError(t, err)
+				req.Hash = hash
+			}
+
+			resp, err := client.Update(ctx, req)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantCode != codes.OK {
+				return
+			}
+			assert.Equal(t, tt.want.String(), resp.GetMetric().String())
+
+			got, err := client.Get(ctx, &metricspb.GetRequest{Id: tt.metric.Id, Type: tt.metric.Type, Labels: tt.metric.Labels})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.String(), got.GetMetric().String())
+		})
+	}
+}
+
+func TestMetricsServer_GetTimes(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "metrics.db")
+	require.NoError(t, migrations.RunMigration(dsn))
+	db, err := repository.OpenSQLite(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	client, _ := newTestClientWith(t, "", repository.NewSQLiteStorage(db, zap.NewNop()))
+	ctx := context.Background()
+
+	v := 1.5
+	_, err = client.Update(ctx, &metricspb.UpdateRequest{Metric: &metricspb.Metric{Id: "load", Type: metricspb.Metric_GAUGE, Value: &v}})
+	require.NoError(t, err)
+
+	var header metadata.MD
+	_, err = client.Get(ctx, &metricspb.GetRequest{Id: "load", Type: metricspb.Metric_GAUGE}, grpc.Header(&header))
+	require.NoError(t, err)
+
+	require.Len(t, header.Get("created-at"), 1)
+	createdAt, err := time.Parse(time.RFC3339Nano, header.Get("created-at")[0])
+	require.NoError(t, err)
+	require.Len(t, header.Get("last-updated"), 1)
+	lastUpdated, err := time.Parse(time.RFC3339Nano, header.Get("last-updated")[0])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), createdAt, time.Minute)
+	assert.Equal(t, createdAt, lastUpdated)
+
+	// the in-memory storage doesn't track the times
+	client, _ = newTestClient(t, "")
+	_, err = client.Update(ctx, &metricspb.UpdateRequest{Metric: &metricspb.Metric{Id: "load", Type: metricspb.Metric_GAUGE, Value: &v}})
+	require.NoError(t, err)
+	header = nil
+	_, err = client.Get(ctx, &metricspb.GetRequest{Id: "load", Type: metricspb.Metric_GAUGE}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get("created-at"))
+}
+
+func TestMetricsServer_UpdateInvalid(t *testing.T) {
+	client, _ := newTestClient(t, "")
+	ctx := context.Background()
+
+	histogram := func(bounds []float64) *metricspb.UpdateRequest {
+		counts := make([]uint64, len(bounds)+1)
+		counts[0] = 1
+		return &metricspb.UpdateRequest{Metric: &metricspb.Metric{Id: "latency", Type: metricspb.Metric_HISTOGRAM, Histogram: &metricspb.Histogram{
+			Bounds: bounds, Counts: counts, Sum: 0.5, Count: 1,
+		}}}
+	}
+
+	_, err := client.Update(ctx, histogram([]float64{1, 5}))
+	require.NoError(t, err)
+
+	// the bounds don't fit the stored histogram
+	_, err = client.Update(ctx, histogram([]float64{1, 10}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the counts don't add up to the count
+	req := histogram([]float64{1, 5})
+	req.Metric.Histogram.Count = 2
+	_, err = client.Update(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err := client.PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&metricspb.PushMetricsRequest{Metrics: []*metricspb.Metric{histogram([]float64{2}).Metric}}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestStorageCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{
+			name: "Open breaker",
+			err:  fmt.Errorf("failed to update metric: %w", &breaker.OpenError{RetryAfter: time.Second}),
+			want: codes.Unavailable,
+		},
+		{
+			name: "Invalid metric",
+			err:  fmt.Errorf("failed to update metric: %w", models.Invalid(errors.New("unknown metric type"))),
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "Bounds mismatch",
+			err:  fmt.Errorf("histogram latency: %w", models.ErrBoundsMismatch),
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "Storage error",
+			err:  errors.New("disk is full"),
+			want: codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, storageCode(tt.err))
+		})
+	}
+}
+
+func TestMetricsServer_GetNotFound(t *testing.T) {
+	client, _ := newTestClient(t, "")
+
+	_, err := client.Get(context.Background(), &metricspb.GetRequest{Id: "none", Type: metricspb.Metric_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestMetricsServer_PushMetricsWatch(t *testing.T) {
+	client, _ := newTestClient(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := client.Watch(ctx, &metricspb.WatchRequest{Ids: []string{"jobs"}})
+	require.NoError(t, err)
+	// the stream is registered once the headers arrive
+	_, err = watch.Header()
+	require.NoError(t, err)
+
+	d := int64(2)
+	v := 3.5
+	push, err := client.PushMetrics(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, push.Send(&metricspb.PushMetricsRequest{Metrics: []*metricspb.Metric{
+			metricspb.MetricFromModel(models.Metrics{ID: "jobs", MType: models.Counter, Delta: &d}),
+			metricspb.MetricFromModel(models.Metrics{ID: "cpu", MType: models.Gauge, Value: &v}),
+		}}))
+	}
+	resp, err := push.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), resp.GetAccepted())
+
+	first, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "jobs", first.GetId())
+	assert.Equal(t, metricspb.Metric_COUNTER, first.GetType())
+
+	second, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), second.GetDelta())
+}
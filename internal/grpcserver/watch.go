@@ -0,0 +1,86 @@
+package grpcserver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+)
+
+// watchBuffer - updates buffered per Watch stream, later updates are dropped for a slow stream
+const watchBuffer = 256
+
+// watchHub - observer fanning the updated series keys out to the Watch streams
+type watchHub struct {
+	mu     sync.Mutex
+	subs   map[chan string]struct{}
+	closed bool
+}
+
+// newWatchHub - method for creating a new watch hub
+func newWatchHub() *watchHub {
+	return &watchHub{
+		subs: make(map[chan string]struct{}),
+	}
+}
+
+// Notify - method for passing the metrics of an audit event to the subscribers
+// never blocks, a full subscriber misses the update
+func (h *watchHub) Notify(ctx context.Context, event observer.AuditEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		for _, key := range event.Metrics {
+			select {
+			case ch <- key:
+			default:
+			}
+		}
+	}
+}
+
+// Idle - method for reporting if the hub has no subscribers
+// the service doesn't compute the changes of a write for an idle hub
+func (h *watchHub) Idle() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs) == 0
+}
+
+// subscribe - method for adding a subscriber
+// returns the channel of series keys and the function removing the subscriber
+func (h *watchHub) subscribe() (<-chan string, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan string, watchBuffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close - method for closing all subscriber channels
+func (h *watchHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
@@ -2,6 +2,7 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -161,8 +162,8 @@ func ExampleHandler_GetAllMetrics() {
 	service := service.NewService(repo, zap.NewExample())
 	handler := handler.NewHandler(service, "test")
 
-	service.UpdateCounter("PollCount", 1)
-	service.UpdateGauge("Metric", 10.5)
+	service.UpdateCounter(context.Background(), "PollCount", 1)
+	service.UpdateGauge(context.Background(), "Metric", 10.5)
 
 	r := chi.NewRouter()
 	r.Get("/", handler.GetAllMetrics)
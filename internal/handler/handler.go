@@ -4,49 +4,131 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"net/netip"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/makimaki04/go-metrics-agent.git/internal/audit"
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
+	"github.com/makimaki04/go-metrics-agent.git/internal/lineprotocol"
+	"github.com/makimaki04/go-metrics-agent.git/internal/middleware"
 	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
 	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+	"github.com/makimaki04/go-metrics-agent.git/internal/otlp"
+	"github.com/makimaki04/go-metrics-agent.git/internal/prometheus"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
 	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 // Handler - struct for handling requests
 // generate:reset
 type Handler struct {
-	service service.MetricsService
-	key     []byte
+	service    service.MetricsService
+	key        []byte
+	breaker    *breaker.Breaker
+	audit      []observer.StatusReporter
+	store      audit.Store
+	cumulative *otlp.Cumulative
+	proxies    []netip.Prefix
 }
 
 // NewHandler - constructor for Handler
 func NewHandler(service service.MetricsService, key string) *Handler {
 	return &Handler{
-		service: service,
-		key:     []byte(key),
+		service:    service,
+		key:        []byte(key),
+		cumulative: otlp.NewCumulative(),
 	}
 }
 
+// SetBreaker - method for setting the circuit breaker of the storage
+// while it is open the handlers respond with service unavailable
+// the state of the breaker is reported by PingDatabase
+func (h *Handler) SetBreaker(b *breaker.Breaker) {
+	h.breaker = b
+}
+
+// SetAuditStatus - method for setting the audit observers reported by AuditStatus
+func (h *Handler) SetAuditStatus(reporters ...observer.StatusReporter) {
+	h.audit = reporters
+}
+
+// SetAuditStore - method for setting the audit store queried by QueryAudit
+func (h *Handler) SetAuditStore(store audit.Store) {
+	h.store = store
+}
+
+// SetTrustedProxies - method for setting the proxies X-Forwarded-For is taken from
+// without them the client of the audit events is the peer address
+func (h *Handler) SetTrustedProxies(proxies []netip.Prefix) {
+	h.proxies = proxies
+}
+
+// ParseTrustedProxies - method for parsing a comma-separated list of proxy addresses
+// an entry is a CIDR or a single IP address
+// if error, return error
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
 // GetAllMetrics - method for getting all metrics
-// returns all gauges and counters in html format
+// returns all gauges, counters and histograms in html format
 // if error, returns internal server error
 func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
-	gauges, err := h.service.GetAllGauges()
+	gauges, err := h.service.GetAllGauges(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondStorageError(w, err)
 		return
 	}
-	counters, err := h.service.GetAllCounters()
+	counters, err := h.service.GetAllCounters(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondStorageError(w, err)
+		return
+	}
+	histograms, err := h.service.GetAllHistograms(r.Context())
+	if err != nil {
+		respondStorageError(w, err)
 		return
 	}
 	const marking = `
@@ -105,6 +187,15 @@ func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 								</li>
 								{{end}}
 							</ul>
+							<h2>Histograms</h2>
+							<ul>
+								{{range $key, $value := .Histograms}}
+								<li style="list-style-type:none">
+									<span class="metric-name">{{ $key }}</span>
+									<span class="metric-value">count={{ $value.Count }} sum={{ $value.Sum }} buckets={{ $value.Bounds }}:{{ $value.Counts }}</span>
+								</li>
+								{{end}}
+							</ul>
 						</body>
 					</html>`
 	tmpl, err := template.New("metrics").Parse(marking)
@@ -113,11 +204,13 @@ func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	templateData := struct {
-		Counters map[string]int64
-		Gauges   map[string]float64
+		Counters   map[string]int64
+		Gauges     map[string]float64
+		Histograms map[string]models.HistogramValue
 	}{
-		Counters: counters,
-		Gauges:   gauges,
+		Counters:   counters,
+		Gauges:     gauges,
+		Histograms: histograms,
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -127,6 +220,37 @@ func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetPrometheusMetrics - method for getting all metrics for Prometheus
+// returns all gauges, counters and histograms in the text exposition format
+// if error, returns internal server error
+func (h *Handler) GetPrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	gauges, err := h.service.GetAllGauges(r.Context())
+	if err != nil {
+		respondStorageError(w, err)
+		return
+	}
+	counters, err := h.service.GetAllCounters(r.Context())
+	if err != nil {
+		respondStorageError(w, err)
+		return
+	}
+	histograms, err := h.service.GetAllHistograms(r.Context())
+	if err != nil {
+		respondStorageError(w, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := prometheus.WriteText(&buf, gauges, counters, histograms); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheus.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
 // HandleReq - method for handling requests
 // handle GET and POST requests
 // if method is not allowed, return method not allowed
@@ -145,44 +269,66 @@ func (h *Handler) HandleReq(w http.ResponseWriter, r *http.Request) {
 
 // GetMetric - method for getting a metric
 // return the value of the metric
+// query parameters are matched against the series labels
 // if error, return not found
 // if success, return the value of the metric
 func (h *Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	metric := models.Metrics{
-		ID:    chi.URLParam(r, ("ID")),
-		MType: chi.URLParam(r, "MType"),
+		ID:     chi.URLParam(r, ("ID")),
+		MType:  chi.URLParam(r, "MType"),
+		Labels: labelsFromQuery(r),
 	}
+	if h.unavailable(w) {
+		return
+	}
+	key := metric.SeriesKey()
 	var value string
+	contentType := "text/plain"
 	switch metric.MType {
 	case models.Counter:
-		m, ok := h.service.GetCounter(metric.ID)
+		m, ok := h.service.GetCounter(r.Context(), key)
 		if !ok {
 			respondWithError(w, http.StatusNotFound, `{"error": "invalid metric"}`)
 			return
 		}
 		value = fmt.Sprintf(`%v`, m)
 	case models.Gauge:
-		m, ok := h.service.GetGauge(r.Context(), metric.ID)
+		m, ok := h.service.GetGauge(r.Context(), key)
 		if !ok {
 			respondWithError(w, http.StatusNotFound, `{"error": "invalid metric"}`)
 			return
 		}
 		value = fmt.Sprintf(`%v`, m)
+	case models.Histogram:
+		m, ok := h.service.GetHistogram(r.Context(), key)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, `{"error": "invalid metric"}`)
+			return
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, `{"error": "failed to encode histogram"}`)
+			return
+		}
+		value = string(data)
+		contentType = "application/json"
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(value))
 }
 
 // PostMetric - method for posting a metric
 // update the value of the metric
+// query parameters are stored as the series labels
 // if error, return bad request
 // if success, return ok
 func (h *Handler) PostMetric(w http.ResponseWriter, r *http.Request) {
 	metric := models.Metrics{
-		ID:    chi.URLParam(r, "ID"),
-		MType: chi.URLParam(r, "MType"),
+		ID:     chi.URLParam(r, "ID"),
+		MType:  chi.URLParam(r, "MType"),
+		Labels: labelsFromQuery(r),
 	}
 	metricValue := chi.URLParam(r, "value")
 
@@ -191,6 +337,11 @@ func (h *Handler) PostMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := models.ValidateSeries(metric.ID, metric.Labels); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+
 	switch metric.MType {
 	case models.Counter:
 		delta, err := strconv.ParseInt(metricValue, 10, 64)
@@ -206,14 +357,25 @@ func (h *Handler) PostMetric(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		metric.Value = &value
+	case models.Histogram:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid observation value"}`)
+			return
+		}
+		metric.Value = &value
 	default:
 		respondWithError(w, http.StatusBadRequest, `{"error": "unknown metric type"}`)
 		return
 	}
 
-	ctx := context.WithValue(context.Background(), observer.ReqIDKey, getClientID(r))
+	ctx := h.withRequest(w, r, false)
+
+	if err := h.service.UpdateMetric(ctx, metric); err != nil {
+		respondStorageError(w, err)
+		return
+	}
 
-	h.service.UpdateMetric(ctx, metric)
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 }
@@ -237,10 +399,10 @@ func (h *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.WithValue(context.Background(), observer.ReqIDKey, getClientID(r))
+	ctx := h.withRequest(w, r, false)
 
 	if err := h.service.UpdateMetric(ctx, metric); err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf(`{"error": "%v"}`, err))
+		respondStorageError(w, err)
 		return
 	}
 
@@ -262,16 +424,9 @@ func (h *Handler) UpdateMetricBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(h.key) > 0 {
-		headerHex := r.Header.Get("HashSHA256")
-		checkHash := sha256.Sum256(append(buf.Bytes(), h.key...))
-		checkHex := hex.EncodeToString(checkHash[:])
-
-		if checkHex != headerHex {
-			respondWithError(w, http.StatusBadRequest, `{"error": "something went wrong"}`)
-			return
-		}
-		log.Printf("Hashes are equal:\n %s\n %s", headerHex, checkHex)
+	if !h.validHash(r, buf.Bytes()) {
+		respondWithError(w, http.StatusBadRequest, `{"error": "something went wrong"}`)
+		return
 	}
 
 	if err := json.Unmarshal(buf.Bytes(), &metrics); err != nil {
@@ -280,9 +435,9 @@ func (h *Handler) UpdateMetricBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.WithValue(r.Context(), observer.ReqIDKey, getClientID(r))
+	ctx := h.withRequest(w, r, true)
 	if err := h.service.UpdateMetricBatch(ctx, metrics); err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf(`{"error": "%v"}`, err))
+		respondStorageError(w, err)
 		return
 	}
 
@@ -290,6 +445,297 @@ func (h *Handler) UpdateMetricBatch(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// ImportPrometheus - method for importing metrics in the Prometheus text format
+// gauge and untyped samples become gauges
+// counter samples carry totals, the storage moves the stored counter to the pushed total
+// in the write, a total below the stored counter is taken as a restart of the producer
+// lines that can't be imported are reported with their numbers
+// if nothing was imported and there are line errors, return bad request
+// if success, return the number of imported samples and the line errors
+func (h *Handler) ImportPrometheus(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+
+	_, err := buf.ReadFrom(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, `{"error": "failed to read request body"}`)
+		return
+	}
+
+	if !h.validHash(r, buf.Bytes()) {
+		respondWithError(w, http.StatusBadRequest, `{"error": "something went wrong"}`)
+		return
+	}
+
+	samples, lineErrs, err := prometheus.Parse(&buf)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+
+	metrics := make([]models.Metrics, 0, len(samples))
+
+	for _, s := range samples {
+		metric := models.Metrics{
+			ID:     s.Name,
+			Labels: s.Labels,
+		}
+
+		switch s.Type {
+		case prometheus.TypeGauge, prometheus.TypeUntyped:
+			value := s.Value
+			metric.MType = models.Gauge
+			metric.Value = &value
+		case prometheus.TypeCounter:
+			if s.Value < 0 || s.Value != math.Trunc(s.Value) || s.Value >= math.MaxInt64 {
+				lineErrs = append(lineErrs, prometheus.LineError{Line: s.Line, Error: "counter value must be a non-negative integer"})
+				continue
+			}
+
+			total := int64(s.Value)
+			metric.MType = models.Counter
+			metric.Total = &total
+		default:
+			lineErrs = append(lineErrs, prometheus.LineError{Line: s.Line, Error: fmt.Sprintf("unsupported metric type %q", s.Type)})
+			continue
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	sort.Slice(lineErrs, func(i, j int) bool {
+		return lineErrs[i].Line < lineErrs[j].Line
+	})
+
+	if len(metrics) > 0 {
+		ctx := h.withRequest(w, r, true)
+		if err := h.service.UpdateMetricBatch(ctx, metrics); err != nil {
+			respondStorageError(w, err)
+			return
+		}
+	}
+
+	resp, err := json.Marshal(struct {
+		Accepted int                    `json:"accepted"`
+		Errors   []prometheus.LineError `json:"errors"`
+	}{len(metrics), lineErrs})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "empty response body"}`)
+		return
+	}
+
+	status := http.StatusOK
+	if len(metrics) == 0 && len(lineErrs) > 0 {
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// WriteLineProtocol - method for writing metrics in the InfluxDB line protocol
+// every field becomes a metric named measurement_field, tags become labels
+// float and boolean fields become gauges, boolean is 1 or 0
+// integer fields carry totals, the storage moves the stored counter to the pushed total
+// in the write, a total below the stored counter is taken as a restart of the producer
+// timestamps are not stored: a line with a malformed timestamp is reported as a line error,
+// a valid one is dropped and the metrics get the time of the write, like the other endpoints,
+// because the storage keeps the last value of a series and stamps the history itself
+// lines and fields that can't be written are reported with their line numbers
+// if nothing was written and there are line errors, return bad request
+// if success, return the number of written metrics and the line errors
+func (h *Handler) WriteLineProtocol(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+
+	_, err := buf.ReadFrom(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, `{"error": "failed to read request body"}`)
+		return
+	}
+
+	if !h.validHash(r, buf.Bytes()) {
+		respondWithError(w, http.StatusBadRequest, `{"error": "something went wrong"}`)
+		return
+	}
+
+	precision, err := lineprotocol.Precision(r.URL.Query().Get("precision"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+
+	points, lineErrs, err := lineprotocol.Parse(&buf, precision)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+
+	metrics := make([]models.Metrics, 0, len(points))
+
+	for _, p := range points {
+		for _, f := range p.Fields {
+			metric := models.Metrics{
+				ID:     p.Measurement + "_" + f.Key,
+				Labels: p.Tags,
+			}
+
+			switch f.Kind {
+			case lineprotocol.KindFloat, lineprotocol.KindBool:
+				value := f.Float
+				metric.MType = models.Gauge
+				metric.Value = &value
+			case lineprotocol.KindInt, lineprotocol.KindUint:
+				total := f.Int
+				if f.Kind == lineprotocol.KindUint {
+					if f.Uint > math.MaxInt64 {
+						lineErrs = append(lineErrs, lineprotocol.LineError{Line: p.Line, Error: fmt.Sprintf("field %q: value out of range", f.Key)})
+						continue
+					}
+					total = int64(f.Uint)
+				}
+				if total < 0 {
+					lineErrs = append(lineErrs, lineprotocol.LineError{Line: p.Line, Error: fmt.Sprintf("field %q: counter value must be non-negative", f.Key)})
+					continue
+				}
+
+				metric.MType = models.Counter
+				metric.Total = &total
+			default:
+				lineErrs = append(lineErrs, lineprotocol.LineError{Line: p.Line, Error: fmt.Sprintf("field %q: string fields are not supported", f.Key)})
+				continue
+			}
+
+			if err := models.ValidateSeries(metric.ID, metric.Labels); err != nil {
+				lineErrs = append(lineErrs, lineprotocol.LineError{Line: p.Line, Error: err.Error()})
+				continue
+			}
+
+			metrics = append(metrics, metric)
+		}
+	}
+
+	sort.SliceStable(lineErrs, func(i, j int) bool {
+		return lineErrs[i].Line < lineErrs[j].Line
+	})
+
+	if len(metrics) > 0 {
+		ctx := h.withRequest(w, r, true)
+		if err := h.service.UpdateMetricBatch(ctx, metrics); err != nil {
+			respondStorageError(w, err)
+			return
+		}
+	}
+
+	resp, err := json.Marshal(struct {
+		Accepted int                      `json:"accepted"`
+		Errors   []lineprotocol.LineError `json:"errors"`
+	}{len(metrics), lineErrs})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "empty response body"}`)
+		return
+	}
+
+	status := http.StatusOK
+	if len(metrics) == 0 && len(lineErrs) > 0 {
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// Content types of the OTLP/HTTP requests
+const (
+	otlpProtobuf = "application/x-protobuf"
+	otlpJSON     = "application/json"
+)
+
+// ExportOTLPMetrics - method for importing an OTLP/HTTP metrics export
+// the body is an ExportMetricsServiceRequest in protobuf or JSON encoding,
+// chosen by the Content-Type header, the response uses the same encoding
+// gauges become gauges, monotonic sums become counters, explicit histograms become histograms
+// data points that can't be stored are reported in the partial success
+// if the body can't be decoded, return bad request with a status message
+// if success, return the export response
+func (h *Handler) ExportOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	contentType = strings.TrimSpace(contentType)
+	if contentType != otlpProtobuf && contentType != otlpJSON {
+		respondWithError(w, http.StatusUnsupportedMediaType, `{"error": "unsupported content type"}`)
+		return
+	}
+
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		respondOTLP(w, contentType, http.StatusBadRequest, &spb.Status{Code: int32(codes.InvalidArgument), Message: "failed to read request body"})
+		return
+	}
+
+	if !h.validHash(r, buf.Bytes()) {
+		respondOTLP(w, contentType, http.StatusBadRequest, &spb.Status{Code: int32(codes.InvalidArgument), Message: "something went wrong"})
+		return
+	}
+
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	var err error
+	if contentType == otlpJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(buf.Bytes(), req)
+	} else {
+		err = proto.Unmarshal(buf.Bytes(), req)
+	}
+	if err != nil {
+		respondOTLP(w, contentType, http.StatusBadRequest, &spb.Status{Code: int32(codes.InvalidArgument), Message: err.Error()})
+		return
+	}
+
+	result := otlp.Convert(r.Context(), req, h.service, h.cumulative)
+
+	if len(result.Metrics) > 0 {
+		ctx := h.withRequest(w, r, true)
+		if err := h.service.UpdateMetricBatch(ctx, result.Metrics); err != nil {
+			result.Rollback()
+			retryAfter, open := retryAfterOf(err)
+			switch {
+			case open:
+				w.Header().Set("Retry-After", retryAfter)
+				fallthrough
+			case repository.IsUnavailable(err):
+				respondOTLP(w, contentType, http.StatusServiceUnavailable, &spb.Status{Code: int32(codes.Unavailable), Message: err.Error()})
+			case errors.Is(err, models.ErrInvalidMetric):
+				respondOTLP(w, contentType, http.StatusBadRequest, &spb.Status{Code: int32(codes.InvalidArgument), Message: err.Error()})
+			default:
+				respondOTLP(w, contentType, http.StatusInternalServerError, &spb.Status{Code: int32(codes.Internal), Message: err.Error()})
+			}
+			return
+		}
+	}
+
+	respondOTLP(w, contentType, http.StatusOK, &colmetricspb.ExportMetricsServiceResponse{
+		PartialSuccess: result.PartialSuccess(),
+	})
+}
+
+// respondOTLP - method for writing an OTLP response message in the request encoding
+func respondOTLP(w http.ResponseWriter, contentType string, code int, msg proto.Message) {
+	var body []byte
+	var err error
+	if contentType == otlpJSON {
+		body, err = protojson.Marshal(msg)
+	} else {
+		body, err = proto.Marshal(msg)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "empty response body"}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
 // PostMetricInfo - method for posting metric information
 // return the value of the metric
 // if error, return bad request
@@ -309,21 +755,40 @@ func (h *Handler) PostMetricInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.unavailable(w) {
+		return
+	}
+
+	key := metric.SeriesKey()
+
 	switch metric.MType {
 	case models.Counter:
-		d, ok := h.service.GetCounter(metric.ID)
+		d, ok := h.service.GetCounter(r.Context(), key)
 		if !ok {
 			respondWithError(w, http.StatusNotFound, `{"error": "invalid metric"}`)
 			return
 		}
 		metric.Delta = &d
 	case models.Gauge:
-		v, ok := h.service.GetGauge(r.Context(), metric.ID)
+		v, ok := h.service.GetGauge(r.Context(), key)
 		if !ok {
 			respondWithError(w, http.StatusNotFound, `{"error": "invalid metric"}`)
 			return
 		}
 		metric.Value = &v
+	case models.Histogram:
+		hv, ok := h.service.GetHistogram(r.Context(), key)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, `{"error": "invalid metric"}`)
+			return
+		}
+		metric.Value = nil
+		metric.Histogram = &hv
+	}
+
+	if times, ok := h.service.GetTimes(r.Context(), metric.MType, key); ok {
+		metric.CreatedAt = &times.CreatedAt
+		metric.LastUpdated = &times.LastUpdated
 	}
 
 	resp, err := json.MarshalIndent(metric, "", "	")
@@ -338,12 +803,187 @@ func (h *Handler) PostMetricInfo(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
+// GetHistory - method for getting the history of a metric
+// query parameters from and to limit the time range, RFC3339 or unix seconds
+// the default range is the last hour
+// step aggregates the samples into step-aligned buckets, e.g. step=1m
+// other query parameters are matched against the series labels
+// if the request is invalid, return bad request
+// if the storage fails, return internal server error or service unavailable
+// if success, return the samples in json format
+func (h *Handler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	metric := models.Metrics{
+		ID:     chi.URLParam(r, "ID"),
+		MType:  chi.URLParam(r, "MType"),
+		Labels: labelsFromQuery(r, "from", "to", "step"),
+	}
+
+	query := r.URL.Query()
+
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid to"}`)
+			return
+		}
+		to = t
+	}
+
+	from := to.Add(-time.Hour)
+	if v := query.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid from"}`)
+			return
+		}
+		from = t
+	}
+
+	var step time.Duration
+	if v := query.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid step"}`)
+			return
+		}
+		step = d
+	}
+
+	samples, err := h.service.GetHistory(r.Context(), metric.MType, metric.SeriesKey(), from, to, step)
+	if err != nil {
+		if errors.Is(err, service.ErrNoHistory) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+			return
+		}
+		respondStorageError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(struct {
+		ID      string            `json:"id"`
+		MType   string            `json:"type"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Samples []models.Sample   `json:"samples"`
+	}{metric.ID, metric.MType, metric.Labels, samples})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "failed to encode history"}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// parseTime - method for parsing a time query parameter
+// accepts RFC3339 or unix seconds
+func parseTime(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// AuditStatus - method for getting the delivery state of the audit observers
+// returns the queues and the HTTP spool in json format
+func (h *Handler) AuditStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]observer.Status, 0, len(h.audit))
+	for _, reporter := range h.audit {
+		statuses = append(statuses, reporter.Status())
+	}
+
+	resp, err := json.Marshal(statuses)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "empty response body"}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// QueryAudit - method for querying the audit events
+// query parameters metric, ip, from and to filter the events, from and to are RFC3339 or unix seconds
+// limit sets the page size, cursor takes the next value of the previous page
+// returns the events from the newest to the oldest in json format
+// if there is no audit store, return not implemented
+func (h *Handler) QueryAudit(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		respondWithError(w, http.StatusNotImplemented, `{"error": "audit store is not configured"}`)
+		return
+	}
+
+	query := r.URL.Query()
+	q := audit.Query{
+		Metric: query.Get("metric"),
+		IP:     query.Get("ip"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if v := query.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid from"}`)
+			return
+		}
+		q.From = t
+	}
+	if v := query.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid to"}`)
+			return
+		}
+		q.To = t
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid limit"}`)
+			return
+		}
+		q.Limit = limit
+	}
+
+	if err := q.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+
+	page, err := h.store.Query(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, audit.ErrInvalidCursor) {
+			respondWithError(w, http.StatusBadRequest, `{"error": "invalid cursor"}`)
+			return
+		}
+		respondStorageError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(page)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "failed to encode audit events"}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
 // PingDatabase - method for pinging the database
 // ping the database
+// if the storage has a circuit breaker, its state is returned in json format
 // if error, return internal server error
 // if success, return ok
 func (h *Handler) PingDatabase(w http.ResponseWriter, r *http.Request) {
-	err := h.service.PingDB()
+	err := h.service.PingDB(r.Context())
+	if h.breaker != nil {
+		h.respondBreakerState(w, err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, `{"error}": "failed database connection"`)
 		return
@@ -353,6 +993,56 @@ func (h *Handler) PingDatabase(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// respondBreakerState - method for responding with the ping result and the circuit breaker state
+// retry_after is the number of seconds left until the breaker lets a probe call through
+func (h *Handler) respondBreakerState(w http.ResponseWriter, pingErr error) {
+	state := struct {
+		Database   string `json:"database"`
+		Breaker    string `json:"breaker"`
+		RetryAfter int64  `json:"retry_after"`
+	}{
+		Database:   "ok",
+		Breaker:    h.breaker.State().String(),
+		RetryAfter: int64(math.Ceil(h.breaker.RetryAfter().Seconds())),
+	}
+
+	code := http.StatusOK
+	if pingErr != nil {
+		state.Database = "unavailable"
+		code = http.StatusInternalServerError
+	}
+
+	resp, err := json.Marshal(state)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, `{"error": "empty response body"}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(resp)
+}
+
+// validHash - method for checking the HashSHA256 header of the request
+// the hash is checked only when the server has a key
+// returns true if there is no key or the hash matches the body
+func (h *Handler) validHash(r *http.Request, body []byte) bool {
+	if len(h.key) == 0 {
+		return true
+	}
+
+	headerHex := r.Header.Get("HashSHA256")
+	checkHash := sha256.Sum256(append(body, h.key...))
+	checkHex := hex.EncodeToString(checkHash[:])
+
+	if checkHex != headerHex {
+		return false
+	}
+	log.Printf("Hashes are equal:\n %s\n %s", headerHex, checkHex)
+
+	return true
+}
+
 // respondWithError - method for responding with an error
 // respond with an error
 // if error, return error
@@ -362,21 +1052,144 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	w.Write([]byte(message))
 }
 
-// getClientID - method for getting the client ID
-// get the client ID from the request
-// if error, return the remote address
-// if success, return the client ID
-func getClientID(r *http.Request) string {
-	if frw := r.Header.Get("X-Forwarded-For"); frw != "" {
-		ips := strings.Split(frw, ",")
+// respondStorageError - method for responding with a storage error
+// if the circuit breaker is open, respond with service unavailable and Retry-After
+// if the metric can't be stored, respond with bad request
+// otherwise respond with internal server error
+func respondStorageError(w http.ResponseWriter, err error) {
+	if retryAfter, ok := retryAfterOf(err); ok {
+		w.Header().Set("Retry-After", retryAfter)
+		respondWithError(w, http.StatusServiceUnavailable, `{"error": "storage is unavailable"}`)
+		return
+	}
+	if errors.Is(err, models.ErrInvalidMetric) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, fmt.Sprintf(`{"error": "%v"}`, err))
+}
 
-		return strings.TrimSpace(ips[0])
+// unavailable - method for failing fast while the circuit breaker is open
+// returns true if the response has been written
+func (h *Handler) unavailable(w http.ResponseWriter) bool {
+	if h.breaker == nil || h.breaker.State() != breaker.Open {
+		return false
 	}
+	respondStorageError(w, &breaker.OpenError{RetryAfter: h.breaker.RetryAfter()})
+	return true
+}
+
+// retryAfterOf - method for getting the Retry-After value of a breaker error
+// the value is in whole seconds, at least 1
+// returns false if the error is not a breaker error
+func retryAfterOf(err error) (string, bool) {
+	var openErr *breaker.OpenError
+	if !errors.As(err, &openErr) {
+		return "", false
+	}
+	seconds := int64(math.Ceil(openErr.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10), true
+}
+
+// labelsFromQuery - method for getting the labels from the query string
+// every query parameter is treated as a label, e.g. ?host=web03&region=eu
+// reserved parameters are skipped
+// returns nil if there are no labels
+func labelsFromQuery(r *http.Request, reserved ...string) map[string]string {
+	query := r.URL.Query()
+	for _, k := range reserved {
+		query.Del(k)
+	}
+	if len(query) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(query))
+	for k, v := range query {
+		labels[k] = v[0]
+	}
+
+	return labels
+}
 
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+// withRequest - method for putting the request info for the audit events into the context
+// the request ID is taken from a valid X-Request-ID or generated, and echoed in the response
+// signed - the HashSHA256 header was checked against the body
+func (h *Handler) withRequest(w http.ResponseWriter, r *http.Request, signed bool) context.Context {
+	requestID := observer.RequestID(r.Header.Get("X-Request-ID"))
+	w.Header().Set("X-Request-ID", requestID)
+
+	endpoint := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		endpoint = rctx.RoutePattern()
+	}
+
+	return observer.WithRequest(r.Context(), observer.RequestInfo{
+		IPAddress: clientIP(r, h.proxies),
+		Endpoint:  endpoint,
+		Method:    r.Method,
+		RequestID: requestID,
+		UserAgent: r.UserAgent(),
+		Signed:    signed && len(h.key) > 0,
+		Encrypted: middleware.Decrypted(r.Context()),
+		Identity:  peerIdentity(r.TLS),
+	})
+}
+
+// peerIdentity - method for getting the identity of a client authenticated with a certificate
+// returns the common name of the verified client certificate, empty without one
+func peerIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// clientIP - method for getting the address of the client
+// the peer address is taken, X-Forwarded-For is read only if the peer is a trusted proxy:
+// its hops are walked from the right and the first one that isn't a trusted proxy is the client
+// a hop that isn't an IP address stops the walk at the last trusted one
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr
+		client = r.RemoteAddr
+	}
+	if !trustedProxy(client, proxies) {
+		return client
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+		if !trustedProxy(hop, proxies) {
+			break
+		}
 	}
 
-	return host
+	return client
+}
+
+// trustedProxy - method for checking if an address is one of the trusted proxies
+func trustedProxy(address string, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
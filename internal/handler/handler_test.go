@@ -1,17 +1,30 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/makimaki04/go-metrics-agent.git/internal/audit"
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
 	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
 	"github.com/makimaki04/go-metrics-agent.git/internal/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestHandler_PostMetric(t *testing.T) {
@@ -75,6 +88,48 @@ func TestHandler_PostMetric(t *testing.T) {
 				response: `{"error": "invalid delta value"}`,
 			},
 		},
+		{
+			name:    "positive histogram test",
+			request: "/update/histogram/latency/0.3",
+			want: want{
+				code:        200,
+				contentType: "text/plain",
+				response:    "",
+			},
+		},
+		{
+			name:    "positive labeled gauge test",
+			request: "/update/gauge/CPUutilization1/12.5?host=web03",
+			want: want{
+				code:        200,
+				contentType: "text/plain",
+				response:    "",
+			},
+		},
+		{
+			name:    "negative labeled gauge test with wrong label",
+			request: "/update/gauge/CPUutilization1/12.5?1host=web03",
+			want: want{
+				code:     400,
+				response: `{"error": "metric \"CPUutilization1\": invalid label name \"1host\""}`,
+			},
+		},
+		{
+			name:    "negative histogram test with wrong value",
+			request: "/update/histogram/latency/slow",
+			want: want{
+				code:     400,
+				response: `{"error": "invalid observation value"}`,
+			},
+		},
+		{
+			name:    "negative histogram test with infinite value",
+			request: "/update/histogram/latency/Inf",
+			want: want{
+				code:     400,
+				response: `{"error": "failed to update metric: histogram latency: histogram observation is not finite"}`,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -127,7 +182,7 @@ func TestHandler_GetAllMetrics(t *testing.T) {
 			service := service.NewService(storage, &zap.Logger{})
 			handler := NewHandler(service, "")
 
-			service.UpdateCounter("PollCount", 1)
+			service.UpdateCounter(context.Background(), "PollCount", 1)
 
 			r := chi.NewRouter()
 			r.Get("/", handler.GetAllMetrics)
@@ -166,6 +221,32 @@ func TestHandler_GetMetric(t *testing.T) {
 				response:    "1",
 			},
 		},
+		{
+			name:    "Get histogram positive test",
+			request: "/value/histogram/latency",
+			want: want{
+				code:        200,
+				contentType: "application/json",
+				response:    `{"bounds":[1,5],"counts":[1,0,0],"sum":0.5,"count":1}`,
+			},
+		},
+		{
+			name:    "Get labeled metric positive test",
+			request: "/value/counter/requests?host=web03",
+			want: want{
+				code:        200,
+				contentType: "text/plain",
+				response:    "7",
+			},
+		},
+		{
+			name:    "Get labeled metric negative test",
+			request: "/value/counter/requests?host=web04",
+			want: want{
+				code:     404,
+				response: `{"error": "invalid metric"}`,
+			},
+		},
 		{
 			name:    "Get metric negative test",
 			request: "/value/counter/SomeMetric",
@@ -181,7 +262,14 @@ func TestHandler_GetMetric(t *testing.T) {
 			service := service.NewService(storage, &zap.Logger{})
 			handler := NewHandler(service, "")
 
-			service.UpdateCounter("PollCount", 1)
+			service.UpdateCounter(context.Background(), "PollCount", 1)
+			service.UpdateCounter(context.Background(), `requests{host="web03"}`, 7)
+			service.UpdateHistogram(context.Background(), "latency", models.HistogramValue{
+				Bounds: []float64{1, 5},
+				Counts: []uint64{1, 0, 0},
+				Sum:    0.5,
+				Count:  1,
+			})
 
 			r := chi.NewRouter()
 			r.Get("/value/{MType}/{ID}", handler.GetMetric)
@@ -203,3 +291,578 @@ func TestHandler_GetMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_GetHistory(t *testing.T) {
+	type want struct {
+		code    int
+		samples int
+	}
+	tests := []struct {
+		name    string
+		request string
+		want    want
+	}{
+		{
+			name:    "Raw history",
+			request: "/history/gauge/CPU",
+			want:    want{code: 200, samples: 3},
+		},
+		{
+			name:    "Aggregated history",
+			request: "/history/gauge/CPU?step=1h",
+			want:    want{code: 200, samples: 1},
+		},
+		{
+			name:    "Unknown series",
+			request: "/history/gauge/CPU?host=web03",
+			want:    want{code: 200, samples: 0},
+		},
+		{
+			name:    "Invalid step",
+			request: "/history/gauge/CPU?step=abc",
+			want:    want{code: 400},
+		},
+		{
+			name:    "Histogram history",
+			request: "/history/histogram/latency",
+			want:    want{code: 400},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewStorage()
+			service := service.NewService(storage, &zap.Logger{})
+			handler := NewHandler(service, "")
+
+			for _, v := range []float64{1, 2, 3} {
+				service.UpdateGauge(context.Background(), "CPU", v)
+			}
+
+			r := chi.NewRouter()
+			r.Get("/history/{MType}/{ID}", handler.GetHistory)
+
+			req := httptest.NewRequest(http.MethodGet, tt.request, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, tt.want.code, res.StatusCode)
+			if tt.want.code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Samples []map[string]any `json:"samples"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Len(t, body.Samples, tt.want.samples)
+		})
+	}
+}
+
+func TestHandler_ImportPrometheus(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+		counter  int64
+	}
+	tests := []struct {
+		name     string
+		payloads []string
+		want     want
+	}{
+		{
+			name: "Import gauges and counters",
+			payloads: []string{
+				"# TYPE jobs counter\njobs 5\ntemperature 21.5\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":2,"errors":[]}`,
+				counter:  5,
+			},
+		},
+		{
+			name: "Counter moves to the pushed total",
+			payloads: []string{
+				"# TYPE jobs counter\njobs 5\n",
+				"# TYPE jobs counter\njobs 8\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":1,"errors":[]}`,
+				counter:  8,
+			},
+		},
+		{
+			name: "Counter reset is taken as a restart",
+			payloads: []string{
+				"# TYPE jobs counter\njobs 5\n",
+				"# TYPE jobs counter\njobs 2\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":1,"errors":[]}`,
+				counter:  7,
+			},
+		},
+		{
+			name: "Partial import",
+			payloads: []string{
+				"# TYPE jobs counter\njobs 5\njobs{x} 1\n# TYPE lat histogram\nlat_bucket{le=\"1\"} 1\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":1,"errors":[{"line":3,"error":"label without value"},{"line":5,"error":"unsupported metric type \"histogram\""}]}`,
+				counter:  5,
+			},
+		},
+		{
+			name: "Nothing imported",
+			payloads: []string{
+				"# TYPE jobs counter\njobs 1.5\n",
+			},
+			want: want{
+				code:     400,
+				response: `{"accepted":0,"errors":[{"line":2,"error":"counter value must be a non-negative integer"}]}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewStorage()
+			service := service.NewService(storage, &zap.Logger{})
+			handler := NewHandler(service, "")
+
+			r := chi.NewRouter()
+			r.Post("/import/prometheus", handler.ImportPrometheus)
+
+			var res *http.Response
+			for _, payload := range tt.payloads {
+				req := httptest.NewRequest(http.MethodPost, "/import/prometheus", strings.NewReader(payload))
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				res = w.Result()
+			}
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.code, res.StatusCode)
+			assert.Equal(t, tt.want.response, string(body))
+
+			counter, _ := storage.GetCounter(context.Background(), "jobs")
+			assert.Equal(t, tt.want.counter, counter)
+		})
+	}
+}
+
+func TestHandler_WriteLineProtocol(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+		counter  int64
+		gauge    float64
+	}
+	tests := []struct {
+		name     string
+		payloads []string
+		want     want
+	}{
+		{
+			name: "Write gauges and counters",
+			payloads: []string{
+				"cpu,host=web03 usage=87.5,jobs=5i 1700000000000000000\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":2,"errors":[]}`,
+				counter:  5,
+				gauge:    87.5,
+			},
+		},
+		{
+			name: "Counter moves to the pushed total",
+			payloads: []string{
+				"cpu,host=web03 jobs=5i\n",
+				"cpu,host=web03 jobs=8i\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":1,"errors":[]}`,
+				counter:  8,
+			},
+		},
+		{
+			name: "Counter reset is taken as a restart",
+			payloads: []string{
+				"cpu,host=web03 jobs=5i\n",
+				"cpu,host=web03 jobs=2i\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":1,"errors":[]}`,
+				counter:  7,
+			},
+		},
+		{
+			name: "Partial write",
+			payloads: []string{
+				"cpu,host=web03 usage=87.5,state=\"ok\"\ncpu usage=\n",
+			},
+			want: want{
+				code:     200,
+				response: `{"accepted":1,"errors":[{"line":1,"error":"field \"state\": string fields are not supported"},{"line":2,"error":"field \"usage\" has no value"}]}`,
+				gauge:    87.5,
+			},
+		},
+		{
+			name: "Nothing written",
+			payloads: []string{
+				"cpu,host=web03 jobs=-1i\n",
+			},
+			want: want{
+				code:     400,
+				response: `{"accepted":0,"errors":[{"line":1,"error":"field \"jobs\": counter value must be non-negative"}]}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewStorage()
+			service := service.NewService(storage, &zap.Logger{})
+			handler := NewHandler(service, "")
+
+			r := chi.NewRouter()
+			r.Post("/write", handler.WriteLineProtocol)
+
+			var res *http.Response
+			for _, payload := range tt.payloads {
+				req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(payload))
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				res = w.Result()
+			}
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.code, res.StatusCode)
+			assert.Equal(t, tt.want.response, string(body))
+
+			labels := map[string]string{"host": "web03"}
+			counter, _ := storage.GetCounter(context.Background(), models.SeriesKey("cpu_jobs", labels))
+			assert.Equal(t, tt.want.counter, counter)
+			gauge, _ := storage.GetGauge(context.Background(), models.SeriesKey("cpu_usage", labels))
+			assert.Equal(t, tt.want.gauge, gauge)
+		})
+	}
+}
+
+func TestHandler_ExportOTLPMetrics(t *testing.T) {
+	exportReq := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{
+					{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+						IsMonotonic:            true,
+						DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 7}}},
+					}}},
+					{Name: "latency", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+						DataPoints: []*metricspb.SummaryDataPoint{{}},
+					}}},
+				},
+			}},
+		}},
+	}
+	protoBody, err := proto.Marshal(exportReq)
+	require.NoError(t, err)
+
+	type want struct {
+		code     int
+		counter  int64
+		rejected int64
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        want
+	}{
+		{
+			name:        "Protobuf with partial success",
+			contentType: "application/x-protobuf",
+			body:        string(protoBody),
+			want:        want{code: 200, counter: 7, rejected: 1},
+		},
+		{
+			name:        "JSON",
+			contentType: "application/json",
+			body: `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"requests","sum":{
+				"aggregationTemporality":1,"isMonotonic":true,"dataPoints":[{"asInt":"7"}]}}]}]}]}`,
+			want: want{code: 200, counter: 7},
+		},
+		{
+			name:        "Invalid body",
+			contentType: "application/json",
+			body:        `{"resourceMetrics":5}`,
+			want:        want{code: 400},
+		},
+		{
+			name:        "Unsupported content type",
+			contentType: "text/plain",
+			body:        "requests 7",
+			want:        want{code: 415},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewStorage()
+			service := service.NewService(storage, &zap.Logger{})
+			handler := NewHandler(service, "")
+
+			r := chi.NewRouter()
+			r.Post("/v1/metrics", handler.ExportOTLPMetrics)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.want.code, res.StatusCode)
+
+			counter, _ := storage.GetCounter(context.Background(), "requests")
+			assert.Equal(t, tt.want.counter, counter)
+
+			if tt.want.code != http.StatusOK || tt.contentType != "application/x-protobuf" {
+				return
+			}
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			var resp colmetricspb.ExportMetricsServiceResponse
+			require.NoError(t, proto.Unmarshal(body, &resp))
+			assert.Equal(t, tt.want.rejected, resp.GetPartialSuccess().GetRejectedDataPoints())
+		})
+	}
+}
+
+func TestHandler_CircuitBreaker(t *testing.T) {
+	b := breaker.New(1, time.Minute)
+	require.NoError(t, b.Allow())
+	b.Failure()
+
+	storage := repository.NewBreakerStorage(repository.NewStorage(), b)
+	h := NewHandler(service.NewService(storage, zap.NewNop()), "")
+	h.SetBreaker(b)
+
+	r := chi.NewRouter()
+	r.Post("/update/{MType}/{ID}/{value}", h.PostMetric)
+	r.Get("/value/{MType}/{ID}", h.GetMetric)
+	r.Get("/ping", h.PingDatabase)
+
+	send := func(method, target string) *http.Response {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Result()
+	}
+
+	res := send(http.MethodPost, "/update/gauge/load/1")
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "60", res.Header.Get("Retry-After"))
+
+	res = send(http.MethodGet, "/value/gauge/load")
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+
+	res = send(http.MethodGet, "/ping")
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"database":"ok","breaker":"closed","retry_after":0}`, string(body))
+
+	res = send(http.MethodPost, "/update/gauge/load/1")
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+type staticStatus observer.Status
+
+func (s staticStatus) Status() observer.Status {
+	return observer.Status(s)
+}
+
+func TestHandler_AuditStatus(t *testing.T) {
+	h := NewHandler(service.NewService(repository.NewStorage(), zap.NewNop()), "")
+	h.SetAuditStatus(staticStatus{Name: "http", Pending: 3, SpoolBytes: 120, LastError: "connection refused"})
+
+	w := httptest.NewRecorder()
+	h.AuditStatus(w, httptest.NewRequest(http.MethodGet, "/status/audit", nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `[{"name":"http","pending":3,"spool_bytes":120,"dropped":0,"last_error":"connection refused"}]`, string(body))
+}
+
+// recordObserver - observer keeping the received events
+type recordObserver struct {
+	events []observer.AuditEvent
+}
+
+func (r *recordObserver) Notify(ctx context.Context, event observer.AuditEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestHandler_AuditRequestInfo(t *testing.T) {
+	svc := service.NewService(repository.NewStorage(), zap.NewNop())
+	recorder := &recordObserver{}
+	svc.RegisterObserver(recorder)
+	h := NewHandler(svc, "")
+
+	r := chi.NewRouter()
+	r.Post("/update/{MType}/{ID}/{value}", h.PostMetric)
+
+	send := func(requestID string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/update/counter/requests/2", nil)
+		req.Header.Set("User-Agent", "agent/1.0")
+		req.Header.Set("X-Forwarded-For", "10.0.0.7, 10.0.0.1")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	res := send("req-1")
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "req-1", res.Header.Get("X-Request-ID"))
+
+	res = send("")
+	res.Body.Close()
+	generated := res.Header.Get("X-Request-ID")
+	assert.Len(t, generated, 32)
+
+	// an ID with a character out of the allowed set is replaced
+	res = send("req\r\nX-Injected: 1")
+	res.Body.Close()
+	assert.Len(t, res.Header.Get("X-Request-ID"), 32)
+
+	require.Len(t, recorder.events, 3)
+	event := recorder.events[0]
+	// the peer isn't a trusted proxy, so X-Forwarded-For is ignored
+	assert.Equal(t, "192.0.2.1", event.IPAddress)
+	assert.Equal(t, "/update/{MType}/{ID}/{value}", event.Endpoint)
+	assert.Equal(t, http.MethodPost, event.Method)
+	assert.Equal(t, "req-1", event.RequestID)
+	assert.Equal(t, "agent/1.0", event.UserAgent)
+	assert.False(t, event.Signed)
+	assert.False(t, event.Encrypted)
+
+	two, four := int64(2), int64(4)
+	assert.Equal(t, []observer.MetricChange{
+		{ID: "requests", MType: models.Counter, OldTotal: &two, NewTotal: &four},
+	}, recorder.events[1].Changes)
+	assert.Equal(t, generated, recorder.events[1].RequestID)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		proxies    []netip.Prefix
+		want       string
+	}{
+		{name: "No trusted proxies", remoteAddr: "10.0.0.1:1234", forwarded: []string{"1.2.3.4"}, want: "10.0.0.1"},
+		{name: "Untrusted peer", remoteAddr: "203.0.113.5:1234", forwarded: []string{"1.2.3.4"}, proxies: proxies, want: "203.0.113.5"},
+		{name: "Trusted peer", remoteAddr: "192.0.2.1:1234", forwarded: []string{"1.2.3.4"}, proxies: proxies, want: "1.2.3.4"},
+		{name: "Spoofed left hop", remoteAddr: "10.0.0.1:1234", forwarded: []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, proxies: proxies, want: "1.2.3.4"},
+		{name: "Several headers", remoteAddr: "10.0.0.1:1234", forwarded: []string{"6.6.6.6", "1.2.3.4"}, proxies: proxies, want: "1.2.3.4"},
+		{name: "Only trusted hops", remoteAddr: "10.0.0.1:1234", forwarded: []string{"10.0.0.3, 10.0.0.2"}, proxies: proxies, want: "10.0.0.3"},
+		{name: "Broken hop", remoteAddr: "10.0.0.1:1234", forwarded: []string{"<script>, 10.0.0.2"}, proxies: proxies, want: "10.0.0.2"},
+		{name: "Mapped peer", remoteAddr: "[::ffff:10.0.0.1]:1234", forwarded: []string{"1.2.3.4"}, proxies: proxies, want: "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, clientIP(req, tt.proxies))
+		})
+	}
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.Error(t, err)
+}
+
+func TestHandler_QueryAudit(t *testing.T) {
+	h := NewHandler(service.NewService(repository.NewStorage(), zap.NewNop()), "")
+
+	w := httptest.NewRecorder()
+	h.QueryAudit(w, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	store, err := audit.NewFileStore(filepath.Join(t.TempDir(), "audit.store"), 0, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+	for ts := 1; ts <= 3; ts++ {
+		store.Notify(context.Background(), observer.AuditEvent{TimeStamp: ts, Metrics: []string{"load"}, IPAddress: "10.0.0.1"})
+	}
+	h.SetAuditStore(store)
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		want   []int
+		next   bool
+	}{
+		{name: "first page", target: "/audit?metric=load&ip=10.0.0.1&limit=2", code: http.StatusOK, want: []int{3, 2}, next: true},
+		{name: "time range", target: "/audit?from=1&to=2", code: http.StatusOK, want: []int{2, 1}},
+		{name: "other ip", target: "/audit?ip=10.0.0.2", code: http.StatusOK, want: []int{}},
+		{name: "bad limit", target: "/audit?limit=0", code: http.StatusBadRequest},
+		{name: "bad from", target: "/audit?from=yesterday", code: http.StatusBadRequest},
+		{name: "bad range", target: "/audit?from=2&to=1", code: http.StatusBadRequest},
+		{name: "bad cursor", target: "/audit?cursor=x", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.QueryAudit(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			require.Equal(t, tt.code, w.Code, w.Body.String())
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var page audit.Page
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			got := make([]int, 0, len(page.Events))
+			for _, e := range page.Events {
+				got = append(got, e.TimeStamp)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.next, page.Next != "")
+		})
+	}
+}
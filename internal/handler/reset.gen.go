@@ -11,4 +11,16 @@ func (s *Handler) Reset() {
 		s.key = (s.key)[:0]
 	}
 
+	s.breaker = nil
+
+	if s.audit != nil {
+		s.audit = (s.audit)[:0]
+	}
+
+	s.cumulative = nil
+
+	if s.proxies != nil {
+		s.proxies = (s.proxies)[:0]
+	}
+
 }
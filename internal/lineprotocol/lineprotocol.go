@@ -0,0 +1,260 @@
+package lineprotocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants for the field value kinds
+const (
+	KindFloat = iota
+	KindInt
+	KindUint
+	KindBool
+	KindString
+)
+
+// Field - struct for one field of a point
+// Key - field key
+// Kind - kind of the value, one of the Kind constants
+// Float - value of float and bool fields, bool is 1 or 0
+// Int - value of integer fields
+// Uint - value of unsigned integer fields
+// String - value of string fields
+type Field struct {
+	Key    string
+	Kind   int
+	Float  float64
+	Int    int64
+	Uint   uint64
+	String string
+}
+
+// Point - struct for one parsed line
+// Line - number of the line in the payload, starting from 1
+// Measurement - measurement name
+// Tags - tag set of the point
+// Fields - field set of the point
+// Timestamp - time of the point, nil if the line has none, the server checks it but doesn't store it
+type Point struct {
+	Line        int
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Timestamp   *time.Time
+}
+
+// LineError - struct for an error of one payload line
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Precision - returns the duration of one timestamp unit
+// accepts ns, us, ms and s, empty means ns
+func Precision(p string) (time.Duration, error) {
+	switch p {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("unknown precision %q", p)
+}
+
+// Parse - parses a payload in the InfluxDB line protocol
+// comments and empty lines are skipped
+// lines that can't be parsed are reported as line errors and skipped
+// precision - duration of one timestamp unit
+// returns error only if the payload can't be read
+func Parse(r io.Reader, precision time.Duration) ([]Point, []LineError, error) {
+	points := make([]Point, 0)
+	lineErrs := make([]LineError, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := parseLine(line, precision)
+		if err != nil {
+			lineErrs = append(lineErrs, LineError{Line: n, Error: err.Error()})
+			continue
+		}
+		p.Line = n
+
+		points = append(points, p)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return points, lineErrs, nil
+}
+
+// parseLine - parses measurement[,tag=v...] field=v[,field=v...] [timestamp]
+func parseLine(line string, precision time.Duration) (Point, error) {
+	var p Point
+
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 {
+		return p, fmt.Errorf("missing fields")
+	}
+	if len(sections) > 3 {
+		return p, fmt.Errorf("unexpected text after timestamp")
+	}
+
+	series := splitUnescaped(sections[0], ',', false)
+	p.Measurement = unescape(series[0])
+	if p.Measurement == "" {
+		return p, fmt.Errorf("missing measurement")
+	}
+
+	for _, tag := range series[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		eq := indexUnescaped(field, '=')
+		if eq <= 0 {
+			return p, fmt.Errorf("invalid field %q", field)
+		}
+
+		f, err := parseField(unescape(field[:eq]), field[eq+1:])
+		if err != nil {
+			return p, err
+		}
+		p.Fields = append(p.Fields, f)
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		t := time.Unix(0, ts*int64(precision))
+		p.Timestamp = &t
+	}
+
+	return p, nil
+}
+
+// parseField - parses the field value by its suffix or quotes
+func parseField(key string, v string) (Field, error) {
+	f := Field{Key: key}
+
+	switch {
+	case v == "":
+		return f, fmt.Errorf("field %q has no value", key)
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return f, fmt.Errorf("field %q: unterminated string", key)
+		}
+		f.Kind = KindString
+		f.String = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+	case strings.HasSuffix(v, "i"):
+		i, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("field %q: invalid integer %q", key, v)
+		}
+		f.Kind = KindInt
+		f.Int = i
+	case strings.HasSuffix(v, "u"):
+		u, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("field %q: invalid unsigned integer %q", key, v)
+		}
+		f.Kind = KindUint
+		f.Uint = u
+	default:
+		switch v {
+		case "t", "T", "true", "True", "TRUE":
+			f.Kind = KindBool
+			f.Float = 1
+			return f, nil
+		case "f", "F", "false", "False", "FALSE":
+			f.Kind = KindBool
+			return f, nil
+		}
+
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return f, fmt.Errorf("field %q: invalid float %q", key, v)
+		}
+		f.Kind = KindFloat
+		f.Float = x
+	}
+
+	return f, nil
+}
+
+// splitUnescaped - splits s at sep characters that are not escaped with a backslash
+// quoted - whether double quoted strings are kept whole
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	var parts []string
+	start := 0
+	inQuotes := false
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quoted:
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// indexUnescaped - finds the first sep character that is not escaped
+func indexUnescaped(s string, sep byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescape - removes the backslashes before escaped characters
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package lineprotocol
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	payload := `# comment
+cpu,host=web03,region=eu usage=87.5,cores=8i 1700000000000000000
+disk\ io,path=/var\,log used=12u,ok=t
+weather,city=New\ York temp=-1.5e1,desc="rain, \"heavy\""
+
+cpu usage=
+cpu,host usage=1
+cpu
+cpu usage=1 123abc
+`
+
+	points, lineErrs, err := Parse(strings.NewReader(payload), time.Nanosecond)
+	require.NoError(t, err)
+	require.Len(t, points, 3)
+
+	ts := time.Unix(0, 1700000000000000000)
+	assert.Equal(t, Point{
+		Line:        2,
+		Measurement: "cpu",
+		Tags:        map[string]string{"host": "web03", "region": "eu"},
+		Fields: []Field{
+			{Key: "usage", Kind: KindFloat, Float: 87.5},
+			{Key: "cores", Kind: KindInt, Int: 8},
+		},
+		Timestamp: &ts,
+	}, points[0])
+
+	assert.Equal(t, Point{
+		Line:        3,
+		Measurement: "disk io",
+		Tags:        map[string]string{"path": "/var,log"},
+		Fields: []Field{
+			{Key: "used", Kind: KindUint, Uint: 12},
+			{Key: "ok", Kind: KindBool, Float: 1},
+		},
+	}, points[1])
+
+	assert.Equal(t, Point{
+		Line:        4,
+		Measurement: "weather",
+		Tags:        map[string]string{"city": "New York"},
+		Fields: []Field{
+			{Key: "temp", Kind: KindFloat, Float: -15},
+			{Key: "desc", Kind: KindString, String: `rain, "heavy"`},
+		},
+	}, points[2])
+
+	lines := make([]int, 0, len(lineErrs))
+	for _, e := range lineErrs {
+		lines = append(lines, e.Line)
+	}
+	assert.Equal(t, []int{6, 7, 8, 9}, lines)
+}
+
+func TestPrecision(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "Default", in: "", want: time.Nanosecond},
+		{name: "Milliseconds", in: "ms", want: time.Millisecond},
+		{name: "Seconds", in: "s", want: time.Second},
+		{name: "Unknown", in: "h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Precision(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package metricspb holds the protobuf messages and the gRPC service of the metrics API.
+package metricspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// hashField - name of the field holding the request hash
+const hashField = "hash"
+
+// TypeFromModel - converts a models metric type into the protobuf enum
+func TypeFromModel(mType string) Metric_Type {
+	switch mType {
+	case models.Gauge:
+		return Metric_GAUGE
+	case models.Counter:
+		return Metric_COUNTER
+	case models.Histogram:
+		return Metric_HISTOGRAM
+	}
+	return Metric_UNSPECIFIED
+}
+
+// TypeToModel - converts the protobuf enum into a models metric type
+// if the type is unspecified or unknown, return error
+func TypeToModel(t Metric_Type) (string, error) {
+	switch t {
+	case Metric_GAUGE:
+		return models.Gauge, nil
+	case Metric_COUNTER:
+		return models.Counter, nil
+	case Metric_HISTOGRAM:
+		return models.Histogram, nil
+	}
+	return "", fmt.Errorf("unknown metric type: %v", t)
+}
+
+// MetricFromModel - converts models.Metrics into the protobuf message
+func MetricFromModel(m models.Metrics) *Metric {
+	pm := &Metric{
+		Id:     m.ID,
+		Type:   TypeFromModel(m.MType),
+		Labels: m.Labels,
+		Delta:  m.Delta,
+		Value:  m.Value,
+	}
+
+	if m.Histogram != nil {
+		pm.Histogram = &Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}
+	}
+
+	return pm
+}
+
+// MetricToModel - converts the protobuf message into models.Metrics
+// if the type is unknown, return error
+func MetricToModel(pm *Metric) (models.Metrics, error) {
+	mType, err := TypeToModel(pm.GetType())
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	m := models.Metrics{
+		ID:     pm.GetId(),
+		MType:  mType,
+		Labels: pm.GetLabels(),
+		Delta:  pm.Delta,
+		Value:  pm.Value,
+	}
+
+	if h := pm.GetHistogram(); h != nil {
+		m.Histogram = &models.HistogramValue{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Sum:    h.GetSum(),
+			Count:  h.GetCount(),
+		}
+	}
+
+	return m, nil
+}
+
+// Sign - computes the hex SHA256 of the deterministic encoding
+// of the message with an empty hash field, followed by the key
+// if error, return error
+func Sign(msg proto.Message, key []byte) (string, error) {
+	c := proto.Clone(msg)
+	r := c.ProtoReflect()
+	if fd := r.Descriptor().Fields().ByName(hashField); fd != nil {
+		r.Clear(fd)
+	}
+
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	sum := sha256.Sum256(append(body, key...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify - checks the hash field of the message against the key
+func Verify(msg interface {
+	proto.Message
+	GetHash() string
+}, key []byte) bool {
+	want, err := Sign(msg, key)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal([]byte(want), []byte(msg.GetHash()))
+}
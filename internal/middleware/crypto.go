@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -9,6 +10,18 @@ import (
 	"net/http"
 )
 
+// contextKey - type for the context keys of the middlewares
+type contextKey string
+
+// decryptedKey - context key marking a request whose body was decrypted
+const decryptedKey contextKey = "decrypted"
+
+// Decrypted - method for checking if the body of the request was decrypted by CryptoMiddleware
+func Decrypted(ctx context.Context) bool {
+	decrypted, _ := ctx.Value(decryptedKey).(bool)
+	return decrypted
+}
+
 func CryptoMiddleware(privateKey *rsa.PrivateKey, next http.HandlerFunc) http.HandlerFunc {
 	if privateKey == nil {
 		return next
@@ -53,6 +66,7 @@ func CryptoMiddleware(privateKey *rsa.PrivateKey, next http.HandlerFunc) http.Ha
 
 		r.Body = io.NopCloser(bytes.NewReader(decryptedData))
 		r.ContentLength = int64(len(decryptedData))
+		r = r.WithContext(context.WithValue(r.Context(), decryptedKey, true))
 
 		next(w, r)
 	}
@@ -0,0 +1,13 @@
+DELETE FROM metrics WHERE metric_type = 'histogram';
+
+ALTER TABLE metrics DROP CONSTRAINT value_check;
+ALTER TABLE metrics ADD CONSTRAINT value_check CHECK (
+    (metric_type = 'gauge' AND gauge_value IS NOT NULL AND counter_value IS NULL) OR
+    (metric_type = 'counter' AND counter_value IS NOT NULL AND gauge_value IS NULL)
+);
+
+ALTER TABLE metrics DROP CONSTRAINT metrics_metric_type_check;
+ALTER TABLE metrics ADD CONSTRAINT metrics_metric_type_check
+    CHECK (metric_type IN ('gauge', 'counter'));
+
+ALTER TABLE metrics DROP COLUMN histogram_value;
@@ -0,0 +1,7 @@
+DELETE FROM metrics WHERE labels <> '{}'::jsonb;
+
+DROP INDEX idx_metrics_series;
+
+CREATE UNIQUE INDEX idx_metrics_name_type ON metrics(name, metric_type);
+
+ALTER TABLE metrics DROP COLUMN labels;
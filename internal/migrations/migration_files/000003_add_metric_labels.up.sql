@@ -0,0 +1,5 @@
+ALTER TABLE metrics ADD COLUMN labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+DROP INDEX idx_metrics_name_type;
+
+CREATE UNIQUE INDEX idx_metrics_series ON metrics(name, metric_type, labels);
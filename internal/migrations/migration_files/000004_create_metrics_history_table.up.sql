@@ -0,0 +1,14 @@
+CREATE TABLE metrics_history (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    metric_type VARCHAR(10) NOT NULL CHECK (metric_type IN ('gauge', 'counter')),
+    ts TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    value DOUBLE PRECISION NOT NULL,
+    min_value DOUBLE PRECISION NOT NULL,
+    max_value DOUBLE PRECISION NOT NULL,
+    sum_value DOUBLE PRECISION NOT NULL,
+    sample_count BIGINT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_metrics_history_series_ts ON metrics_history(name, metric_type, labels, ts);
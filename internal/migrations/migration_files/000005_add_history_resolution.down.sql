@@ -0,0 +1,5 @@
+DELETE FROM metrics_history WHERE resolution_seconds > 0;
+
+DROP INDEX IF EXISTS idx_metrics_history_name_resolution_ts;
+
+ALTER TABLE metrics_history DROP COLUMN resolution_seconds;
@@ -0,0 +1,3 @@
+ALTER TABLE metrics_history ADD COLUMN resolution_seconds INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_metrics_history_name_resolution_ts ON metrics_history(name, resolution_seconds, ts);
@@ -0,0 +1,13 @@
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    ts TIMESTAMPTZ NOT NULL,
+    ip_address TEXT NOT NULL,
+    metrics TEXT[] NOT NULL,
+    names TEXT[] NOT NULL,
+    event JSONB NOT NULL
+);
+
+CREATE INDEX idx_audit_events_ts ON audit_events(ts);
+CREATE INDEX idx_audit_events_ip_ts ON audit_events(ip_address, ts);
+CREATE INDEX idx_audit_events_metrics ON audit_events USING GIN (metrics);
+CREATE INDEX idx_audit_events_names ON audit_events USING GIN (names);
@@ -0,0 +1,12 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM metrics WHERE counter_value NOT BETWEEN -2147483648 AND 2147483647) THEN
+        RAISE EXCEPTION 'metrics has counters out of the INTEGER range, lower or remove them before the down migration';
+    END IF;
+END
+$$;
+
+ALTER TABLE metrics ALTER COLUMN timestamp DROP NOT NULL;
+ALTER TABLE metrics DROP COLUMN created_at;
+
+ALTER TABLE metrics ALTER COLUMN counter_value TYPE INTEGER;
@@ -0,0 +1,8 @@
+ALTER TABLE metrics ALTER COLUMN counter_value TYPE BIGINT;
+
+ALTER TABLE metrics ADD COLUMN created_at TIMESTAMPTZ;
+UPDATE metrics SET timestamp = CURRENT_TIMESTAMP WHERE timestamp IS NULL;
+UPDATE metrics SET created_at = timestamp;
+ALTER TABLE metrics ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE metrics ALTER COLUMN created_at SET NOT NULL;
+ALTER TABLE metrics ALTER COLUMN timestamp SET NOT NULL;
@@ -4,30 +4,53 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
-//go:embed migration_files/*.sql
+//go:embed migration_files/*.sql sqlite_files/*.sql
 var migrationsDir embed.FS
 
-//RunMigration - method for running the migrations
-//run the migrations
+//migrationFiles - method for getting the directory of the migrations for the dsn
+//sqlite:// dsns get the sqlite dialect, the rest get the postgres one
+func migrationFiles(dsn string) string {
+	if strings.HasPrefix(dsn, "sqlite://") {
+		return "sqlite_files"
+	}
+	return "migration_files"
+}
+
+//newMigrate - method for creating the migrate of the dsn
 //if error, return error
-//if success, return nil
-func RunMigration(dsn string) error {
-	d, err := iofs.New(migrationsDir, "migration_files")
+func newMigrate(dsn string) (*migrate.Migrate, error) {
+	d, err := iofs.New(migrationsDir, migrationFiles(dsn))
 	if err != nil {
-		return fmt.Errorf("failed to return a FS drive: %w", err)
+		return nil, fmt.Errorf("failed to return a FS drive: %w", err)
 	}
 
 	m, err := migrate.NewWithSourceInstance("iofs", d, dsn)
 
 	if err != nil {
-		return fmt.Errorf("failed to return a new migrate: %w", err)
+		return nil, fmt.Errorf("failed to return a new migrate: %w", err)
+	}
+
+	return m, nil
+}
+
+//RunMigration - method for running the migrations
+//run the migrations
+//if error, return error
+//if success, return nil
+func RunMigration(dsn string) error {
+	m, err := newMigrate(dsn)
+	if err != nil {
+		return err
 	}
+	defer m.Close()
 
 	if err := m.Up(); err != nil {
 		if !errors.Is(err, migrate.ErrNoChange) {
@@ -0,0 +1,144 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// stepAll - runs the migrations one at a time up to the last one, down to none and up again
+// so every up and down file is applied to the database once at least
+func stepAll(t *testing.T, dsn string) {
+	m, err := newMigrate(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+
+	steps := 0
+	for ; ; steps++ {
+		err := m.Steps(1)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		require.NoError(t, err, "up step %d", steps+1)
+	}
+	require.NotZero(t, steps)
+
+	for i := steps; i > 0; i-- {
+		require.NoError(t, m.Steps(-1), "down step %d", i)
+	}
+	_, _, err = m.Version()
+	assert.ErrorIs(t, err, migrate.ErrNilVersion)
+
+	require.NoError(t, m.Up())
+}
+
+func TestMigrations_SQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	stepAll(t, "sqlite://"+path)
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UnixNano()
+	_, err = db.Exec(`
+		INSERT INTO metrics (name, labels, metric_type, counter_value, timestamp, created_at)
+		VALUES ('requests', '{}', 'counter', ?1, ?2, ?2)`, int64(3_000_000_000), now)
+	require.NoError(t, err)
+
+	var counter, createdAt int64
+	require.NoError(t, db.QueryRow(`SELECT counter_value, created_at FROM metrics`).Scan(&counter, &createdAt))
+	assert.Equal(t, int64(3_000_000_000), counter)
+	assert.Equal(t, now, createdAt)
+}
+
+// TestMigrations_Postgres - runs the migrations in a database created for the test
+// TEST_DATABASE_DSN is a dsn of a user allowed to create databases, the test is skipped without it
+func TestMigrations_Postgres(t *testing.T) {
+	adminDSN := os.Getenv("TEST_DATABASE_DSN")
+	if adminDSN == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	admin, err := sql.Open("pgx", adminDSN)
+	require.NoError(t, err)
+	defer admin.Close()
+
+	name := fmt.Sprintf("metrics_migrations_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE DATABASE " + name)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP DATABASE IF EXISTS " + name + " WITH (FORCE)")
+		assert.NoError(t, err)
+	})
+
+	u, err := url.Parse(adminDSN)
+	require.NoError(t, err)
+	u.Path = "/" + name
+	dsn := u.String()
+
+	stepAll(t, dsn)
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// counters past 2^31 fit the column
+	_, err = db.Exec(`
+		INSERT INTO metrics (name, labels, metric_type, counter_value)
+		VALUES ('requests', '{}', 'counter', $1)`, int64(3_000_000_000))
+	require.NoError(t, err)
+
+	var counter int64
+	var createdAt, updated time.Time
+	require.NoError(t, db.QueryRow(`SELECT counter_value, created_at, timestamp FROM metrics`).
+		Scan(&counter, &createdAt, &updated))
+	assert.Equal(t, int64(3_000_000_000), counter)
+	assert.Equal(t, createdAt, updated)
+
+	// the down migration refuses to run while a counter doesn't fit the narrow column,
+	// the counter is kept
+	m, err := newMigrate(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+	assert.Error(t, m.Steps(-1))
+	require.NoError(t, db.QueryRow(`SELECT counter_value FROM metrics`).Scan(&counter))
+	assert.Equal(t, int64(3_000_000_000), counter)
+
+	// once the counter fits, it goes down with its value
+	version, _, err := m.Version()
+	require.NoError(t, err)
+	require.NoError(t, m.Force(int(version)))
+	_, err = db.Exec(`UPDATE metrics SET counter_value = 5`)
+	require.NoError(t, err)
+	require.NoError(t, m.Steps(-1))
+
+	// the rows written before the migration get their times too
+	_, err = db.Exec(`
+		INSERT INTO metrics (name, labels, metric_type, gauge_value, timestamp)
+		VALUES ('load', '{}', 'gauge', 1.5, NULL)`)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+
+	var missing int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM metrics WHERE created_at IS NULL OR timestamp IS NULL`).Scan(&missing))
+	assert.Zero(t, missing)
+
+	// no rows are lost on the way down and up
+	var rows int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM metrics`).Scan(&rows))
+	assert.Equal(t, 2, rows)
+	require.NoError(t, db.QueryRow(`SELECT counter_value FROM metrics WHERE name = 'requests'`).Scan(&counter))
+	assert.Equal(t, int64(5), counter)
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS metrics_history;
+DROP TABLE IF EXISTS metrics;
@@ -0,0 +1,34 @@
+CREATE TABLE metrics (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    metric_type TEXT NOT NULL CHECK (metric_type IN ('gauge', 'counter', 'histogram')),
+    gauge_value REAL NULL,
+    counter_value INTEGER NULL,
+    histogram_value TEXT NULL,
+    timestamp INTEGER NOT NULL,
+    CONSTRAINT value_check CHECK (
+        (metric_type = 'gauge' AND gauge_value IS NOT NULL AND counter_value IS NULL AND histogram_value IS NULL) OR
+        (metric_type = 'counter' AND counter_value IS NOT NULL AND gauge_value IS NULL AND histogram_value IS NULL) OR
+        (metric_type = 'histogram' AND histogram_value IS NOT NULL AND gauge_value IS NULL AND counter_value IS NULL)
+    )
+);
+
+CREATE UNIQUE INDEX idx_metrics_series ON metrics(name, metric_type, labels);
+
+CREATE TABLE metrics_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    metric_type TEXT NOT NULL CHECK (metric_type IN ('gauge', 'counter')),
+    ts INTEGER NOT NULL,
+    value REAL NOT NULL,
+    min_value REAL NOT NULL,
+    max_value REAL NOT NULL,
+    sum_value REAL NOT NULL,
+    sample_count INTEGER NOT NULL DEFAULT 1,
+    resolution_seconds INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_metrics_history_series_ts ON metrics_history(name, metric_type, labels, ts);
+CREATE INDEX idx_metrics_history_name_resolution_ts ON metrics_history(name, resolution_seconds, ts);
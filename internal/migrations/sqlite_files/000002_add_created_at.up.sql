@@ -0,0 +1,2 @@
+ALTER TABLE metrics ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+UPDATE metrics SET created_at = timestamp;
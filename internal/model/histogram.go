@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrBoundsMismatch - error returned when two histograms with different bucket bounds are merged
+var ErrBoundsMismatch = Invalid(errors.New("histogram bucket bounds mismatch"))
+
+// ErrNotFinite - error returned when a NaN or infinite value is observed
+var ErrNotFinite = Invalid(errors.New("histogram observation is not finite"))
+
+// DefaultHistogramBounds - bucket bounds used when a histogram is created from a single observation
+var DefaultHistogramBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramValue - struct for the histogram metric payload
+// Bounds - upper bounds of the buckets in ascending order
+// Counts - observations per bucket, the last element is the +Inf bucket
+// Sum - sum of all observed values
+// Count - total number of observations
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// NewHistogram - creates an empty histogram with the given bucket bounds
+func NewHistogram(bounds []float64) *HistogramValue {
+	b := make([]float64, len(bounds))
+	copy(b, bounds)
+
+	return &HistogramValue{
+		Bounds: b,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe - method for adding a single observation to the histogram
+// NaN and infinite values would make the sum unusable, they are rejected
+// if the value isn't finite, return ErrNotFinite
+func (h *HistogramValue) Observe(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ErrNotFinite
+	}
+
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+	return nil
+}
+
+// Validate - method for checking the histogram consistency
+// bounds must be strictly ascending and finite
+// counts must have one more element than bounds and sum up to count
+// sum must be finite
+// the errors match ErrInvalidMetric
+func (h HistogramValue) Validate() error {
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return Invalid(fmt.Errorf("histogram sum is not finite"))
+	}
+
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return Invalid(fmt.Errorf("histogram has %d bounds but %d counts", len(h.Bounds), len(h.Counts)))
+	}
+
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return Invalid(fmt.Errorf("histogram bound %d is not finite", i))
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return Invalid(fmt.Errorf("histogram bounds must be strictly ascending"))
+		}
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return Invalid(fmt.Errorf("histogram count %d doesn't match bucket total %d", h.Count, total))
+	}
+
+	return nil
+}
+
+// Merge - method for adding another histogram to this one
+// both histograms must have the same bucket bounds
+func (h *HistogramValue) Merge(other HistogramValue) error {
+	if !sameBounds(h.Bounds, other.Bounds) {
+		return ErrBoundsMismatch
+	}
+
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// Clone - method for making a deep copy of the histogram
+func (h HistogramValue) Clone() HistogramValue {
+	c := HistogramValue{
+		Bounds: make([]float64, len(h.Bounds)),
+		Counts: make([]uint64, len(h.Counts)),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+	copy(c.Bounds, h.Bounds)
+	copy(c.Counts, h.Counts)
+
+	return c
+}
+
+// sameBounds - checks that two bound lists are equal
+func sameBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
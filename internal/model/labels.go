@@ -0,0 +1,110 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labelNameRe - allowed label names, same as in Prometheus
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// SeriesKey - method for getting the series identity of the metric
+// the identity is the metric ID followed by its sorted labels
+func (m Metrics) SeriesKey() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// SeriesKey - builds the series identity from the name and labels
+// returns the bare name when there are no labels
+// otherwise returns name{k1="v1",k2="v2"} with keys sorted
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// ParseSeriesKey - splits the series identity into the name and labels
+// reverse of SeriesKey
+// returns error if the key is malformed
+func ParseSeriesKey(key string) (string, map[string]string, error) {
+	open := strings.IndexByte(key, '{')
+	if open < 0 {
+		return key, nil, nil
+	}
+	if !strings.HasSuffix(key, "}") {
+		return "", nil, fmt.Errorf("series %q: missing closing brace", key)
+	}
+
+	name := key[:open]
+	rest := key[open+1 : len(key)-1]
+	labels := make(map[string]string)
+
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return "", nil, fmt.Errorf("series %q: label without value", key)
+		}
+		k := rest[:eq]
+
+		quoted, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return "", nil, fmt.Errorf("series %q: bad value of label %q", key, k)
+		}
+		v, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", nil, fmt.Errorf("series %q: bad value of label %q", key, k)
+		}
+		labels[k] = v
+
+		rest = rest[eq+1+len(quoted):]
+		if rest != "" {
+			if rest[0] != ',' {
+				return "", nil, fmt.Errorf("series %q: expected comma after label %q", key, k)
+			}
+			rest = rest[1:]
+		}
+	}
+
+	return name, labels, nil
+}
+
+// ValidateSeries - checks the metric name and labels
+// the name must not contain braces and label names must be identifiers
+// the errors match ErrInvalidMetric
+func ValidateSeries(name string, labels map[string]string) error {
+	if strings.ContainsAny(name, "{}") {
+		return Invalid(fmt.Errorf("metric %q: name must not contain braces", name))
+	}
+
+	for k := range labels {
+		if !labelNameRe.MatchString(k) {
+			return Invalid(fmt.Errorf("metric %q: invalid label name %q", name, k))
+		}
+	}
+
+	return nil
+}
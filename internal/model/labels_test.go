@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name: "No labels",
+			id:   "CPUutilization1",
+			want: "CPUutilization1",
+		},
+		{
+			name:   "Sorted labels",
+			id:     "CPUutilization1",
+			labels: map[string]string{"region": "eu", "host": "web03"},
+			want:   `CPUutilization1{host="web03",region="eu"}`,
+		},
+		{
+			name:   "Escaped value",
+			id:     "requests",
+			labels: map[string]string{"path": `/a,b="c"`},
+			want:   `requests{path="/a,b=\"c\""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := SeriesKey(tt.id, tt.labels)
+			assert.Equal(t, tt.want, key)
+
+			id, labels, err := ParseSeriesKey(key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.id, id)
+			assert.Equal(t, len(tt.labels), len(labels))
+			for k, v := range tt.labels {
+				assert.Equal(t, v, labels[k])
+			}
+		})
+	}
+}
+
+func TestParseSeriesKey_Malformed(t *testing.T) {
+	for _, key := range []string{
+		`cpu{host="web03"`,
+		`cpu{host}`,
+		`cpu{host=web03}`,
+		`cpu{host="web03"region="eu"}`,
+	} {
+		_, _, err := ParseSeriesKey(key)
+		assert.Error(t, err, key)
+	}
+}
+
+func TestValidateSeries(t *testing.T) {
+	assert.NoError(t, ValidateSeries("cpu", map[string]string{"host": "web03"}))
+	assert.Error(t, ValidateSeries("cpu{", nil))
+	assert.Error(t, ValidateSeries("cpu", map[string]string{"1host": "web03"}))
+}
@@ -1,21 +1,80 @@
 package models
 
+import (
+	"errors"
+	"time"
+)
+
 // Constants for the metric types
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
 )
 
 // Metrics - struct for metrics
 // ID - id of the metric
 // MType - type of the metric
+// Labels - optional dimensions, together with ID they identify the series
 // Delta - delta of the metric
 // Value - value of the metric
+// Histogram - buckets, sum and count of the histogram metric
 // Hash - hash of the metric
+// Total - cumulative counter total pushed instead of Delta by the importers, never encoded,
+// the storage turns it into a delta against the stored counter in the write
+// GaugeDelta - change of a gauge pushed instead of Value by the importers, never encoded,
+// the storage adds it to the stored gauge in the write
+// CreatedAt - time of the first write of the series, set by the database storages
+// LastUpdated - time of the last write of the series, set by the database storages
+// the times are encoded by POST /value and sent in the headers of the gRPC Get, the other read paths leave them out
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID          string            `json:"id"`
+	MType       string            `json:"type"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Delta       *int64            `json:"delta,omitempty"`
+	Value       *float64          `json:"value,omitempty"`
+	Histogram   *HistogramValue   `json:"histogram,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	Total       *int64            `json:"-"`
+	GaugeDelta  *float64          `json:"-"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	LastUpdated *time.Time        `json:"last_updated,omitempty"`
+}
+
+// MetricChange - struct for the change a write makes to a single metric
+// ID - series key of the metric
+// MType - type of the metric
+// OldValue, NewValue - gauge value or histogram sum before and after the update
+// OldTotal, NewTotal - counter total or histogram observations count before and after the update
+// the old fields are empty if the metric didn't exist
+type MetricChange struct {
+	ID       string   `json:"id"`
+	MType    string   `json:"type"`
+	OldValue *float64 `json:"old_value,omitempty"`
+	NewValue *float64 `json:"new_value,omitempty"`
+	OldTotal *int64   `json:"old_total,omitempty"`
+	NewTotal *int64   `json:"new_total,omitempty"`
+}
+
+// ErrInvalidMetric - error matched by the errors of the metrics that can't be stored,
+// an invalid series, type or value, or a value that doesn't fit the stored one
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// invalidError - error of a metric that can't be stored
+// the message is the one of err, errors.Is matches both err and ErrInvalidMetric
+type invalidError struct {
+	err error
+}
+
+func (e *invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidError) Unwrap() []error {
+	return []error{ErrInvalidMetric, e.err}
+}
+
+// Invalid - method for marking an error as the error of a metric that can't be stored
+func Invalid(err error) error {
+	return &invalidError{err: err}
 }
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Sample - struct for a point of the metric history
+// Timestamp - time of the write, or start of the step for aggregates
+// Value - last value of the gauge or counter total after the write
+// Min - minimal gauge value or counter delta in the step
+// Max - maximal gauge value or counter delta in the step
+// Sum - sum of gauge values or counter deltas in the step
+// Count - number of writes in the step
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+	Min       float64
+	Max       float64
+	Sum       float64
+	Count     int64
+}
+
+// NewGaugeSample - creates a raw sample of a gauge write
+func NewGaugeSample(ts time.Time, value float64) Sample {
+	return Sample{Timestamp: ts, Value: value, Min: value, Max: value, Sum: value, Count: 1}
+}
+
+// NewCounterSample - creates a raw sample of a counter write
+// total - counter value after the write
+// delta - increment accepted by the write
+func NewCounterSample(ts time.Time, total int64, delta int64) Sample {
+	d := float64(delta)
+	return Sample{Timestamp: ts, Value: float64(total), Min: d, Max: d, Sum: d, Count: 1}
+}
+
+// Avg - method for getting the average gauge value or counter delta
+func (s Sample) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Merge - method for adding a later sample to this one
+// keeps the timestamp, takes the value of the later sample
+func (s *Sample) Merge(other Sample) {
+	if s.Count == 0 {
+		ts := s.Timestamp
+		*s = other
+		s.Timestamp = ts
+		return
+	}
+
+	s.Value = other.Value
+	s.Min = min(s.Min, other.Min)
+	s.Max = max(s.Max, other.Max)
+	s.Sum += other.Sum
+	s.Count += other.Count
+}
+
+// MarshalJSON - method for encoding the sample with its average
+func (s Sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Timestamp time.Time `json:"ts"`
+		Value     float64   `json:"value"`
+		Min       float64   `json:"min"`
+		Max       float64   `json:"max"`
+		Avg       float64   `json:"avg"`
+		Sum       float64   `json:"sum"`
+		Count     int64     `json:"count"`
+	}{s.Timestamp, s.Value, s.Min, s.Max, s.Avg(), s.Sum, s.Count})
+}
+
+// Downsample - aggregates time ordered samples into step-aligned buckets
+// every bucket starts at a multiple of step and merges the samples inside it
+// step <= 0 returns the samples unchanged
+func Downsample(samples []Sample, step time.Duration) []Sample {
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	result := make([]Sample, 0)
+	for _, s := range samples {
+		bucket := s.Timestamp.Truncate(step)
+		if n := len(result); n > 0 && result[n-1].Timestamp.Equal(bucket) {
+			result[n-1].Merge(s)
+			continue
+		}
+
+		agg := Sample{Timestamp: bucket}
+		agg.Merge(s)
+		result = append(result, agg)
+	}
+
+	return result
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsample(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	samples := []Sample{
+		NewGaugeSample(base.Add(5*time.Second), 1),
+		NewGaugeSample(base.Add(30*time.Second), 5),
+		NewGaugeSample(base.Add(61*time.Second), 3),
+	}
+
+	got := Downsample(samples, time.Minute)
+
+	assert.Equal(t, []Sample{
+		{Timestamp: base, Value: 5, Min: 1, Max: 5, Sum: 6, Count: 2},
+		{Timestamp: base.Add(time.Minute), Value: 3, Min: 3, Max: 3, Sum: 3, Count: 1},
+	}, got)
+	assert.Equal(t, 3.0, got[0].Avg())
+
+	assert.Equal(t, samples, Downsample(samples, 0))
+}
+
+func TestDownsample_Counter(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	samples := []Sample{
+		NewCounterSample(base, 2, 2),
+		NewCounterSample(base.Add(time.Second), 5, 3),
+	}
+
+	got := Downsample(samples, time.Minute)
+
+	assert.Equal(t, []Sample{
+		{Timestamp: base, Value: 5, Min: 2, Max: 3, Sum: 5, Count: 2},
+	}, got)
+}
@@ -0,0 +1,362 @@
+package observer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// hashField - start of the field closing every chained line
+// the hash and the hmac are hex, so the field can't appear inside a string value
+const hashField = `,"hash":"`
+
+var (
+	// ErrMalformed - the line isn't a chained audit entry
+	ErrMalformed = errors.New("malformed entry")
+	// ErrModified - the entry doesn't match its hash
+	ErrModified = errors.New("entry modified")
+	// ErrBadSignature - the hmac of the entry is missing or doesn't match the key
+	ErrBadSignature = errors.New("bad signature")
+	// ErrMissingEntries - entries are missing between two lines
+	ErrMissingEntries = errors.New("missing entries")
+	// ErrBrokenLink - the entry doesn't point to the hash of the previous one
+	ErrBrokenLink = errors.New("broken link")
+)
+
+// chainHeader - struct for the chain fields of an entry
+// Seq - number of the entry, starts at 1
+// Prev - hash of the previous entry, empty for the first one
+type chainHeader struct {
+	Seq  uint64 `json:"seq"`
+	Prev string `json:"prev"`
+}
+
+// chainedEvent - struct for the signed content of an entry
+// the event fields come first, the chain fields are appended
+type chainedEvent struct {
+	AuditEvent
+	chainHeader
+}
+
+// chainTrailer - struct for the fields closing an entry
+type chainTrailer struct {
+	Hash string `json:"hash"`
+	HMAC string `json:"hmac"`
+}
+
+// chain - struct for the hash chain of the audit file
+// every entry has the sequence number and the hash of the previous entry
+// the hash covers the whole entry except the hash and hmac fields
+// the hmac is added when the key is set
+type chain struct {
+	key  []byte
+	seq  uint64
+	prev string
+}
+
+// seal - method for encoding the event as the next entry of the chain
+// returns the json line without the newline
+// if error, return error
+func (c *chain) seal(event AuditEvent) ([]byte, error) {
+	header := chainHeader{Seq: c.seq + 1, Prev: c.prev}
+	content, err := json.Marshal(chainedEvent{AuditEvent: event, chainHeader: header})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := entryHash(content)
+
+	line := make([]byte, 0, len(content)+160)
+	line = append(line, content[:len(content)-1]...)
+	line = append(line, hashField...)
+	line = append(line, hash...)
+	line = append(line, '"')
+	if len(c.key) > 0 {
+		line = append(line, `,"hmac":"`...)
+		line = append(line, entryHMAC(c.key, content)...)
+		line = append(line, '"')
+	}
+	line = append(line, '}')
+
+	c.seq = header.Seq
+	c.prev = hash
+
+	return line, nil
+}
+
+// resume - method for continuing the chain after the last entry written to the file
+// the active file is checked first, the newest rotated segment if it is empty
+// only empty or missing files start a new chain
+// if the last line isn't a valid entry, return error: restarting at seq 1 would hide
+// the entries before it, the file has to be checked and moved aside first
+// if error, return error
+func (c *chain) resume(path string) error {
+	candidates := []string{path}
+	segments, err := Segments(path)
+	if err != nil {
+		return err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		candidates = append(candidates, segments[i])
+	}
+
+	for _, candidate := range candidates {
+		line, err := lastLine(candidate)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if line == nil {
+			continue
+		}
+
+		entry, err := parseEntry(line)
+		if err != nil {
+			return fmt.Errorf("last entry of %s: %w", candidate, err)
+		}
+		c.seq = entry.Seq
+		c.prev = entry.Hash
+		return nil
+	}
+
+	return nil
+}
+
+// chainEntry - struct for a parsed line of the audit file
+// content - the line without the hash and hmac fields, the hashed bytes
+type chainEntry struct {
+	chainHeader
+	chainTrailer
+	content []byte
+}
+
+// parseEntry - method for splitting a line into the chain fields and the hashed content
+// checks that the hash matches the content
+// if error, return error
+func parseEntry(line []byte) (chainEntry, error) {
+	idx := bytes.LastIndex(line, []byte(hashField))
+	if idx < 0 || line[len(line)-1] != '}' {
+		return chainEntry{}, ErrMalformed
+	}
+
+	entry := chainEntry{content: append(line[:idx:idx], '}')}
+
+	if err := json.Unmarshal(append([]byte{'{'}, line[idx+1:]...), &entry.chainTrailer); err != nil {
+		return chainEntry{}, ErrMalformed
+	}
+	if err := json.Unmarshal(entry.content, &entry.chainHeader); err != nil || entry.Seq == 0 {
+		return chainEntry{}, ErrMalformed
+	}
+
+	if entryHash(entry.content) != entry.Hash {
+		return chainEntry{}, ErrModified
+	}
+
+	return entry, nil
+}
+
+// entryHash - method for getting the hex sha256 of the entry content
+func entryHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// entryHMAC - method for getting the hex hmac-sha256 of the entry content
+func entryHMAC(key, content []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lastLine - method for reading the last complete line of a plain or gzipped file
+// returns nil if the file has no complete lines
+// if error, return error
+func lastLine(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	var last []byte
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+				last = trimmed
+			}
+		}
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ChainError - struct for the first problem found in the audit files
+// Path, Line - file and line number of the entry
+// Err - one of the chain errors, with the details
+type ChainError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// ChainReport - struct for the result of a verification
+// Files - number of the files checked
+// Entries - number of the entries checked
+// FirstSeq, LastSeq - sequence numbers of the first and the last entry
+// FirstSeq > 1 means the older segments were pruned
+type ChainReport struct {
+	Files    int
+	Entries  int
+	FirstSeq uint64
+	LastSeq  uint64
+}
+
+// ChainVerifier - struct for checking the entries of the audit files in order
+// key - key of the hmac, empty skips the signature check
+type ChainVerifier struct {
+	key    []byte
+	report ChainReport
+	prev   string
+}
+
+// NewChainVerifier - constructor for ChainVerifier
+func NewChainVerifier(key []byte) *ChainVerifier {
+	return &ChainVerifier{key: key}
+}
+
+// Verify - method for checking the next entry
+// the first entry may start anywhere, the older segments could have been pruned
+// if error, return error
+func (v *ChainVerifier) Verify(line []byte) error {
+	entry, err := parseEntry(line)
+	if err != nil {
+		return err
+	}
+
+	if len(v.key) > 0 && !hmac.Equal([]byte(entry.HMAC), []byte(entryHMAC(v.key, entry.content))) {
+		return fmt.Errorf("%w: seq %d", ErrBadSignature, entry.Seq)
+	}
+
+	if v.report.Entries == 0 {
+		if entry.Seq == 1 && entry.Prev != "" {
+			return fmt.Errorf("%w: first entry points to %s", ErrBrokenLink, entry.Prev)
+		}
+		v.report.FirstSeq = entry.Seq
+	} else {
+		switch {
+		case entry.Seq > v.report.LastSeq+1:
+			return fmt.Errorf("%w: seq %d to %d", ErrMissingEntries, v.report.LastSeq+1, entry.Seq-1)
+		case entry.Seq != v.report.LastSeq+1:
+			return fmt.Errorf("%w: seq %d after %d", ErrBrokenLink, entry.Seq, v.report.LastSeq)
+		case entry.Prev != v.prev:
+			return fmt.Errorf("%w: seq %d doesn't point to seq %d", ErrBrokenLink, entry.Seq, v.report.LastSeq)
+		}
+	}
+
+	v.report.Entries++
+	v.report.LastSeq = entry.Seq
+	v.prev = entry.Hash
+
+	return nil
+}
+
+// VerifyFile - method for checking the entries of a plain or gzipped file
+// the chain continues from the previous file
+// if error, return *ChainError
+func (v *ChainVerifier) VerifyFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	buffered := bufio.NewReader(reader)
+	for n := 1; ; n++ {
+		line, err := buffered.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF {
+			return &ChainError{Path: path, Line: n, Err: fmt.Errorf("%w: torn last line", ErrMalformed)}
+		}
+
+		if verr := v.Verify(bytes.TrimSpace(line)); verr != nil {
+			return &ChainError{Path: path, Line: n, Err: verr}
+		}
+	}
+
+	v.report.Files++
+	return nil
+}
+
+// Report - method for getting the result of the entries checked so far
+func (v *ChainVerifier) Report() ChainReport {
+	return v.report
+}
+
+// VerifyChain - method for checking an audit file with its rotated segments
+// the segments are checked from the oldest to the newest, the active file last
+// if error, return error
+func VerifyChain(path string, key []byte) (ChainReport, error) {
+	segments, err := Segments(path)
+	if err != nil {
+		return ChainReport{}, err
+	}
+
+	v := NewChainVerifier(key)
+	for _, file := range append(segments, path) {
+		if err := v.VerifyFile(file); err != nil {
+			return v.Report(), err
+		}
+	}
+
+	return v.Report(), nil
+}
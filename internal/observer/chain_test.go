@@ -0,0 +1,149 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// writeChain - writes the events to a chained audit file and closes it
+func writeChain(t *testing.T, cfg FileConfig, from, to int) {
+	f, err := NewFileObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+	for ts := from; ts <= to; ts++ {
+		f.Notify(context.Background(), event(ts))
+	}
+	require.NoError(t, f.Close(context.Background()))
+}
+
+// editLines - rewrites the lines of the file with edit
+func editLines(t *testing.T, path string, edit func(lines [][]byte) [][]byte) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	lines = edit(lines)
+	require.NoError(t, os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0640))
+}
+
+func TestChain_VerifyRotatedAndRestarted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	cfg := FileConfig{Path: path, SyncInterval: time.Hour, MaxSize: 600, Compress: true, Key: []byte("secret")}
+
+	writeChain(t, cfg, 1, 5)
+	writeChain(t, cfg, 6, 9)
+
+	segments, err := Segments(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+
+	report, err := VerifyChain(path, cfg.Key)
+	require.NoError(t, err)
+	assert.Equal(t, ChainReport{Files: len(segments) + 1, Entries: 9, FirstSeq: 1, LastSeq: 9}, report)
+
+	_, err = VerifyChain(path, []byte("other"))
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestChain_DetectsTampering(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(lines [][]byte) [][]byte
+		want error
+		line int
+	}{
+		{
+			name: "edited entry",
+			edit: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("10.0.0.1"), []byte("10.0.0.2"), 1)
+				return lines
+			},
+			want: ErrModified,
+			line: 2,
+		},
+		{
+			name: "removed entry",
+			edit: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			want: ErrMissingEntries,
+			line: 2,
+		},
+		{
+			name: "swapped entries",
+			edit: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			want: ErrMissingEntries,
+			line: 2,
+		},
+		{
+			name: "rewritten chain",
+			edit: func(lines [][]byte) [][]byte {
+				// the edited entry gets a new valid hash, the next one no longer points to it
+				c := chain{seq: 1, prev: mustParse(t, lines[0]).Hash}
+				forged := event(2)
+				forged.IPAddress = "10.0.0.2"
+				line, err := c.seal(forged)
+				require.NoError(t, err)
+				lines[1] = line
+				return lines
+			},
+			want: ErrBrokenLink,
+			line: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			writeChain(t, FileConfig{Path: path, SyncInterval: time.Hour}, 1, 4)
+			editLines(t, path, tt.edit)
+
+			_, err := VerifyChain(path, nil)
+			assert.ErrorIs(t, err, tt.want)
+
+			var chainErr *ChainError
+			require.ErrorAs(t, err, &chainErr)
+			assert.Equal(t, tt.line, chainErr.Line)
+		})
+	}
+}
+
+func mustParse(t *testing.T, line []byte) chainEntry {
+	entry, err := parseEntry(line)
+	require.NoError(t, err)
+	return entry
+}
+
+func TestChain_ResumeBrokenLastEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	cfg := FileConfig{Path: path, SyncInterval: time.Hour}
+	writeChain(t, cfg, 1, 3)
+
+	// the chain isn't restarted at seq 1 over an entry it can't read
+	editLines(t, path, func(lines [][]byte) [][]byte {
+		lines[2] = bytes.Replace(lines[2], []byte("10.0.0.1"), []byte("10.0.0.2"), 1)
+		return lines
+	})
+	_, err := NewFileObserver(cfg, zap.NewNop())
+	assert.ErrorIs(t, err, ErrModified)
+
+	require.NoError(t, os.WriteFile(path, []byte("not an entry\n"), 0640))
+	_, err = NewFileObserver(cfg, zap.NewNop())
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	// an empty file starts a new chain
+	require.NoError(t, os.WriteFile(path, nil, 0640))
+	writeChain(t, cfg, 1, 2)
+	report, err := VerifyChain(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), report.LastSeq)
+}
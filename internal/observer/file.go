@@ -0,0 +1,408 @@
+package observer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rotatedSuffix - time layout of the suffix of the rotated segments
+// sorts in the order the segments were rotated
+const rotatedSuffix = "20060102T150405.000000000"
+
+// FileConfig - struct for the file observer settings
+// Path - path to the audit file
+// SyncInterval - how often the buffer is flushed and the file is fsynced
+// MaxSize - size in bytes the file is rotated at, 0 disables size rotation
+// RotateInterval - age the file is rotated at, 0 disables time rotation
+// MaxFiles - rotated segments kept, 0 keeps all of them
+// Compress - gzip the rotated segments
+// Key - key of the hmac added to every entry, empty writes only the hash chain
+type FileConfig struct {
+	Path           string
+	SyncInterval   time.Duration
+	MaxSize        int64
+	RotateInterval time.Duration
+	MaxFiles       int
+	Compress       bool
+	Key            []byte
+}
+
+// Validate - method for validating the file observer settings
+// if error, return error
+func (c FileConfig) Validate() error {
+	switch {
+	case c.Path == "":
+		return errors.New("audit file path is empty")
+	case c.SyncInterval <= 0:
+		return fmt.Errorf("invalid audit fsync interval: %s", c.SyncInterval)
+	case c.MaxSize < 0:
+		return fmt.Errorf("invalid audit file size limit: %d", c.MaxSize)
+	case c.RotateInterval < 0:
+		return fmt.Errorf("invalid audit rotation interval: %s", c.RotateInterval)
+	case c.MaxFiles < 0:
+		return fmt.Errorf("invalid audit max files: %d", c.MaxFiles)
+	}
+	return nil
+}
+
+// FileObserver - struct for the file observer
+// events are written as json lines through a buffer to a long-lived file handle
+// the buffer is flushed and the file is fsynced every SyncInterval
+// the file is rotated by size and age, old segments are gzipped and pruned
+// every entry is chained to the previous one by its hash, the chain goes on through the rotations
+type FileObserver struct {
+	cfg    FileConfig
+	logger *zap.Logger
+	chain  chain
+
+	mu       sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	openedAt time.Time
+	closed   bool
+
+	// compressMu guards compressed, the segments being gzipped that prune must keep
+	compressing sync.WaitGroup
+	compressMu  sync.Mutex
+	compressed  map[string]struct{}
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewFileObserver - constructor for FileObserver
+// the file is opened for appending, the segment age starts now
+// the chain continues from the last entry of the file or of the newest segment
+// if error, return error
+func NewFileObserver(cfg FileConfig, logger *zap.Logger) (*FileObserver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	f := &FileObserver{
+		cfg:        cfg,
+		logger:     logger,
+		chain:      chain{key: cfg.Key},
+		stop:       make(chan struct{}),
+		compressed: make(map[string]struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := f.chain.resume(cfg.Path); err != nil {
+		return nil, fmt.Errorf("couldn't read the last audit entry: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	go f.syncLoop()
+
+	return f, nil
+}
+
+// Notify - method for notifying the file observer
+// the event is written as a single json line with the chain fields
+// the file is rotated first if it is due
+func (f *FileObserver) Notify(ctx context.Context, event AuditEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		f.logger.Error("Audit event written after the audit file was closed")
+		return
+	}
+
+	data, err := f.chain.seal(event)
+	if err != nil {
+		f.logger.Error("Failed to marshal audit event", zap.Error(err))
+		return
+	}
+	data = append(data, '\n')
+
+	if f.rotationDue(int64(len(data))) {
+		if err := f.rotate(); err != nil {
+			f.logger.Error("Failed to rotate audit file", zap.Error(err))
+		}
+	}
+
+	n, err := f.writer.Write(data)
+	f.size += int64(n)
+	if err != nil {
+		f.logger.Error("Failed to write audit event to file", zap.Error(err))
+	}
+}
+
+// Reopen - method for reopening the audit file
+// used after the file has been moved by an external tool, e.g. logrotate on SIGHUP
+// the moved files aren't segments of the observer, see Segments
+// if error, return error
+func (f *FileObserver) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	if err := f.closeFile(); err != nil {
+		f.logger.Error("Failed to close audit file", zap.Error(err))
+	}
+	return f.open()
+}
+
+// Close - method for closing the audit file
+// the buffer is flushed and the file is fsynced, running compressions are waited for
+// if error, return error
+func (f *FileObserver) Close(ctx context.Context) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	err := f.closeFile()
+	f.mu.Unlock()
+
+	close(f.stop)
+
+	finished := make(chan struct{})
+	go func() {
+		<-f.done
+		f.compressing.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return fmt.Errorf("audit file observer: %w", ctx.Err())
+	}
+	return err
+}
+
+// syncLoop - method for flushing and fsyncing the file every SyncInterval
+// a file past its rotation interval is rotated even without new events
+func (f *FileObserver) syncLoop() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.cfg.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.mu.Lock()
+			if !f.closed {
+				if f.size > 0 && f.rotationDue(0) {
+					if err := f.rotate(); err != nil {
+						f.logger.Error("Failed to rotate audit file", zap.Error(err))
+					}
+				}
+				if err := f.sync(); err != nil {
+					f.logger.Error("Failed to sync audit file", zap.Error(err))
+				}
+			}
+			f.mu.Unlock()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// open - method for opening the audit file for appending
+// must be called with the lock held
+func (f *FileObserver) open() error {
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("couldn't open audit file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't stat audit file: %w", err)
+	}
+
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	f.size = info.Size()
+	f.openedAt = time.Now()
+
+	return nil
+}
+
+// sync - method for flushing the buffer and fsyncing the file
+// must be called with the lock held
+func (f *FileObserver) sync() error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// closeFile - method for flushing, fsyncing and closing the file
+// must be called with the lock held
+func (f *FileObserver) closeFile() error {
+	err := f.sync()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rotationDue - method for checking if the file must be rotated before writing n bytes
+// an empty file is never rotated
+// must be called with the lock held
+func (f *FileObserver) rotationDue(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.cfg.MaxSize > 0 && f.size+n > f.cfg.MaxSize {
+		return true
+	}
+	return f.cfg.RotateInterval > 0 && time.Since(f.openedAt) >= f.cfg.RotateInterval
+}
+
+// rotate - method for moving the file to a timestamped segment and opening a new one
+// the segment is gzipped in the background, the oldest segments over MaxFiles are removed
+// must be called with the lock held
+func (f *FileObserver) rotate() error {
+	if err := f.closeFile(); err != nil {
+		f.logger.Error("Failed to close audit file", zap.Error(err))
+	}
+
+	segment := f.cfg.Path + "." + time.Now().UTC().Format(rotatedSuffix)
+	if err := os.Rename(f.cfg.Path, segment); err != nil {
+		if oerr := f.open(); oerr != nil {
+			return errors.Join(err, oerr)
+		}
+		return fmt.Errorf("couldn't rotate audit file: %w", err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.cfg.Compress {
+		f.compressMu.Lock()
+		f.compressed[segment] = struct{}{}
+		f.compressMu.Unlock()
+
+		f.compressing.Add(1)
+		go func() {
+			defer f.compressing.Done()
+			err := compressSegment(segment)
+
+			f.compressMu.Lock()
+			delete(f.compressed, segment)
+			f.compressMu.Unlock()
+
+			if err != nil {
+				f.logger.Error("Failed to compress audit segment", zap.String("segment", segment), zap.Error(err))
+			}
+			f.prune()
+		}()
+		return nil
+	}
+
+	f.prune()
+	return nil
+}
+
+// prune - method for removing the oldest rotated segments over MaxFiles
+// a segment still being gzipped is kept with the newer ones, its compression prunes again when done
+func (f *FileObserver) prune() {
+	if f.cfg.MaxFiles <= 0 {
+		return
+	}
+
+	segments, err := Segments(f.cfg.Path)
+	if err != nil {
+		f.logger.Error("Failed to list audit segments", zap.Error(err))
+		return
+	}
+
+	f.compressMu.Lock()
+	defer f.compressMu.Unlock()
+
+	for len(segments) > f.cfg.MaxFiles {
+		if _, ok := f.compressed[segments[0]]; ok {
+			return
+		}
+		if err := os.Remove(segments[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			f.logger.Error("Failed to remove audit segment", zap.String("segment", segments[0]), zap.Error(err))
+		}
+		segments = segments[1:]
+	}
+}
+
+// Segments - method for listing the rotated segments of an audit file
+// only the segments rotated by the observer are listed, named path.<time>[.gz] with the rotatedSuffix layout
+// files moved by an external tool like logrotate (path.1, path.2.gz, path-20240101) are not listed,
+// so they aren't pruned, verified or used to resume the chain, the tool has to keep them itself
+// returns the paths from the oldest to the newest, the active file is not included
+// if error, return error
+func Segments(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	segments := matches[:0]
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		if _, err := time.Parse(rotatedSuffix, suffix); err == nil {
+			segments = append(segments, m)
+		}
+	}
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+// compressSegment - method for replacing a segment with its gzipped copy
+// if error, return error
+func compressSegment(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if serr := dst.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,158 @@
+package observer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// readEvents - reads the json lines of a plain or gzipped audit file
+func readEvents(t *testing.T, path string) []int {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var scanner *bufio.Scanner
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		defer zr.Close()
+		scanner = bufio.NewScanner(zr)
+	} else {
+		scanner = bufio.NewScanner(file)
+	}
+
+	var ts []int
+	for scanner.Scan() {
+		var e AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e), "line %q", scanner.Text())
+		ts = append(ts, e.TimeStamp)
+	}
+	require.NoError(t, scanner.Err())
+	return ts
+}
+
+func TestFileObserver_JSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := NewFileObserver(FileConfig{Path: path, SyncInterval: time.Hour}, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 3; ts++ {
+		f.Notify(context.Background(), event(ts))
+	}
+	require.NoError(t, f.Close(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+	assert.Equal(t, []int{1, 2, 3}, readEvents(t, path))
+}
+
+func TestFileObserver_SyncInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := NewFileObserver(FileConfig{Path: path, SyncInterval: 5 * time.Millisecond}, zap.NewNop())
+	require.NoError(t, err)
+	defer f.Close(context.Background())
+
+	f.Notify(context.Background(), event(1))
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() > 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestFileObserver_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// every line but the first has the hash of the previous one
+	c := chain{}
+	_, err := c.seal(event(1))
+	require.NoError(t, err)
+	line, err := c.seal(event(2))
+	require.NoError(t, err)
+
+	f, err := NewFileObserver(FileConfig{
+		Path:         path,
+		SyncInterval: time.Hour,
+		MaxSize:      int64(len(line)+1) * 2,
+		MaxFiles:     2,
+		Compress:     true,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 7; ts++ {
+		f.Notify(context.Background(), event(ts))
+		// segment names have nanosecond timestamps
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, f.Close(context.Background()))
+
+	segments, err := Segments(path)
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+
+	var got []int
+	for _, s := range segments {
+		assert.True(t, strings.HasSuffix(s, ".gz"), s)
+		got = append(got, readEvents(t, s)...)
+	}
+	got = append(got, readEvents(t, path)...)
+	assert.Equal(t, []int{3, 4, 5, 6, 7}, got)
+}
+
+func TestFileObserver_PruneKeepsCompressing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	f, err := NewFileObserver(FileConfig{Path: path, SyncInterval: time.Hour, MaxFiles: 1}, zap.NewNop())
+	require.NoError(t, err)
+	defer f.Close(context.Background())
+
+	var segments []string
+	for i := 0; i < 3; i++ {
+		segment := path + "." + time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC).Format(rotatedSuffix)
+		require.NoError(t, os.WriteFile(segment, nil, 0640))
+		segments = append(segments, segment)
+	}
+
+	// the oldest segment is being gzipped, nothing is removed until it is done
+	f.compressed[segments[0]] = struct{}{}
+	f.prune()
+	got, err := Segments(path)
+	require.NoError(t, err)
+	assert.Equal(t, segments, got)
+
+	delete(f.compressed, segments[0])
+	f.prune()
+	got, err = Segments(path)
+	require.NoError(t, err)
+	assert.Equal(t, segments[2:], got)
+}
+
+func TestFileObserver_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	f, err := NewFileObserver(FileConfig{Path: path, SyncInterval: time.Hour}, zap.NewNop())
+	require.NoError(t, err)
+
+	f.Notify(context.Background(), event(1))
+
+	// logrotate moves the file and sends SIGHUP
+	moved := filepath.Join(dir, "audit.log.1")
+	require.NoError(t, os.Rename(path, moved))
+	require.NoError(t, f.Reopen())
+
+	f.Notify(context.Background(), event(2))
+	require.NoError(t, f.Close(context.Background()))
+
+	assert.Equal(t, []int{1}, readEvents(t, moved))
+	assert.Equal(t, []int{2}, readEvents(t, path))
+}
@@ -0,0 +1,494 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HTTPConfig - struct for the HTTP observer settings
+// URL - address the events are posted to
+// BatchSize - events per request, a batch of 1 is posted as a single json object
+// FlushInterval - how long a partial batch waits for more events
+// Attempts - delivery attempts before the events are spooled
+// Backoff - delay before the first retry, doubled for every next one
+// MaxBackoff - upper bound of the delay, also between the spool replays
+// Timeout - timeout of a single request
+// SpoolDir - directory of the dead-letter spool, undeliverable events are dropped if empty
+// SpoolMaxBytes - size limit of the spool, new events are dropped once it is reached
+type HTTPConfig struct {
+	URL           string
+	BatchSize     int
+	FlushInterval time.Duration
+	Attempts      int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	Timeout       time.Duration
+	SpoolDir      string
+	SpoolMaxBytes int64
+}
+
+// Validate - method for validating the HTTP observer settings
+// if error, return error
+func (c HTTPConfig) Validate() error {
+	switch {
+	case c.URL == "":
+		return errors.New("audit url is empty")
+	case c.BatchSize <= 0:
+		return fmt.Errorf("invalid audit batch size: %d", c.BatchSize)
+	case c.FlushInterval <= 0:
+		return fmt.Errorf("invalid audit flush interval: %s", c.FlushInterval)
+	case c.Attempts <= 0:
+		return fmt.Errorf("invalid audit delivery attempts: %d", c.Attempts)
+	case c.Backoff <= 0 || c.MaxBackoff < c.Backoff:
+		return fmt.Errorf("invalid audit backoff: %s, max %s", c.Backoff, c.MaxBackoff)
+	case c.Timeout <= 0:
+		return fmt.Errorf("invalid audit request timeout: %s", c.Timeout)
+	case c.SpoolDir != "" && c.SpoolMaxBytes <= 0:
+		return fmt.Errorf("invalid audit spool size limit: %d", c.SpoolMaxBytes)
+	}
+	return nil
+}
+
+// permanentError - error of a request that won't succeed on a retry
+type permanentError struct {
+	err error
+}
+
+// Error - method for getting the error message
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap - method for getting the wrapped error
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// HTTPObserver - struct for the HTTP observer
+// events are collected into batches and posted by a single goroutine, so the order is kept
+// a failed batch is retried with exponential backoff and then written to the spool
+// while the spool is not empty new batches go behind it, the spool is replayed until the endpoint recovers
+type HTTPObserver struct {
+	cfg    HTTPConfig
+	client *http.Client
+	logger *zap.Logger
+
+	// batchMu guards the current batch and closed, senders counts the full
+	// batches handed over by Notify, the spool is kept open until they are done
+	batchMu sync.Mutex
+	batch   []AuditEvent
+	closed  bool
+	senders sync.WaitGroup
+
+	batches chan []AuditEvent
+	closing chan struct{}
+	done    chan struct{}
+
+	// mu guards the spool and the delivery state
+	mu           sync.Mutex
+	spool        *spillFile
+	lastErr      string
+	lastDelivery time.Time
+
+	dropped atomic.Uint64
+}
+
+// NewHTTPObserver - constructor for HTTPObserver
+// events spooled by the previous run are replayed first
+// if error, return error
+func NewHTTPObserver(cfg HTTPConfig, logger *zap.Logger) (*HTTPObserver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	h := &HTTPObserver{
+		cfg:     cfg,
+		client:  &http.Client{},
+		logger:  logger,
+		batches: make(chan []AuditEvent),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if cfg.SpoolDir != "" {
+		spool, err := openSpill(filepath.Join(cfg.SpoolDir, "http.spool"))
+		if err != nil {
+			return nil, err
+		}
+		h.spool = spool
+		if spool.pending > 0 {
+			logger.Warn("Audit spool has undelivered events", zap.Int("events", spool.pending))
+		}
+	}
+
+	go h.run()
+
+	return h, nil
+}
+
+// Notify - method for notifying the HTTP observer
+// the event is added to the current batch, a full batch is handed to the sender
+// blocks while the sender is busy, so a queue in front of the observer applies its overflow policy
+// a full batch still waiting when the observer is closed is taken by Close
+func (h *HTTPObserver) Notify(ctx context.Context, event AuditEvent) {
+	h.batchMu.Lock()
+	if h.closed {
+		h.batchMu.Unlock()
+		h.drop([]AuditEvent{event}, errors.New("observer is closed"))
+		return
+	}
+	h.batch = append(h.batch, event)
+	if len(h.batch) < h.cfg.BatchSize {
+		h.batchMu.Unlock()
+		return
+	}
+	batch := h.batch
+	h.batch = nil
+	h.senders.Add(1)
+	h.batchMu.Unlock()
+	defer h.senders.Done()
+
+	h.batches <- batch
+}
+
+// Status - method for getting the delivery state
+// Pending counts the events of the current batch and of the spool
+func (h *HTTPObserver) Status() Status {
+	h.batchMu.Lock()
+	pending := len(h.batch)
+	h.batchMu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := Status{
+		Name:      "http",
+		Pending:   pending,
+		Dropped:   h.dropped.Load(),
+		LastError: h.lastErr,
+	}
+	if h.spool != nil {
+		status.Pending += h.spool.pending
+		status.SpoolBytes = h.spool.size()
+	}
+	if !h.lastDelivery.IsZero() {
+		last := h.lastDelivery
+		status.LastDelivery = &last
+	}
+
+	return status
+}
+
+// Close - method for stopping the HTTP observer
+// the batches blocked in Notify and the current batch get one delivery attempt,
+// undelivered events stay in the spool
+// if ctx is done first, return error
+func (h *HTTPObserver) Close(ctx context.Context) error {
+	select {
+	case <-h.closing:
+	default:
+		close(h.closing)
+	}
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit http observer: %w", ctx.Err())
+	}
+}
+
+// run - method for sending the batches and replaying the spool
+func (h *HTTPObserver) run() {
+	defer close(h.done)
+
+	flush := time.NewTicker(h.cfg.FlushInterval)
+	defer flush.Stop()
+
+	var replay <-chan time.Time
+	delay := h.cfg.Backoff
+
+	for {
+		if replay == nil && h.spooled() > 0 {
+			replay = time.After(delay)
+		}
+
+		select {
+		case batch := <-h.batches:
+			h.send(batch)
+		case <-flush.C:
+			if batch := h.takeBatch(); len(batch) > 0 {
+				h.send(batch)
+			}
+		case <-replay:
+			replay = nil
+			if h.replay() {
+				delay = h.cfg.Backoff
+			} else {
+				delay = min(delay*2, h.cfg.MaxBackoff)
+			}
+		case <-h.closing:
+			h.batchMu.Lock()
+			h.closed = true
+			batch := h.batch
+			h.batch = nil
+			h.batchMu.Unlock()
+
+			// the older batches blocked in Notify go first, they end up in the spool
+			// behind a failed delivery like the current one
+			sent := make(chan struct{})
+			go func() {
+				h.senders.Wait()
+				close(sent)
+			}()
+			for waiting := true; waiting; {
+				select {
+				case blocked := <-h.batches:
+					h.send(blocked)
+				case <-sent:
+					waiting = false
+				}
+			}
+			if len(batch) > 0 {
+				h.send(batch)
+			}
+
+			h.mu.Lock()
+			if h.spool != nil {
+				if err := h.spool.close(); err != nil {
+					h.logger.Error("Failed to close audit spool", zap.Error(err))
+				}
+			}
+			h.mu.Unlock()
+			return
+		}
+	}
+}
+
+// takeBatch - method for taking the current partial batch
+func (h *HTTPObserver) takeBatch() []AuditEvent {
+	h.batchMu.Lock()
+	defer h.batchMu.Unlock()
+
+	batch := h.batch
+	h.batch = nil
+	return batch
+}
+
+// send - method for delivering a batch
+// the batch goes to the spool if older events are still there or the delivery fails
+func (h *HTTPObserver) send(batch []AuditEvent) {
+	if h.spooled() > 0 {
+		h.toSpool(batch)
+		return
+	}
+
+	err := h.deliver(batch)
+	var permanent *permanentError
+	switch {
+	case err == nil:
+	case errors.As(err, &permanent):
+		h.drop(batch, err)
+	default:
+		h.logger.Warn("Audit endpoint unavailable, spooling events", zap.Int("events", len(batch)), zap.Error(err))
+		h.toSpool(batch)
+	}
+}
+
+// deliver - method for posting a batch with retries
+// stops retrying when the observer is closing
+// if error, return the last error
+func (h *HTTPObserver) deliver(batch []AuditEvent) error {
+	delay := h.cfg.Backoff
+
+	var err error
+	for attempt := 0; attempt < h.cfg.Attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-h.closing:
+				timer.Stop()
+				return err
+			}
+			delay = min(delay*2, h.cfg.MaxBackoff)
+		}
+
+		err = h.post(batch)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// replay - method for delivering the spooled events in order
+// a single attempt per batch, the replay stops at the first failure
+// returns true if the spool is empty
+func (h *HTTPObserver) replay() bool {
+	for {
+		h.mu.Lock()
+		lines, size, err := h.spool.peek(h.cfg.BatchSize)
+		h.mu.Unlock()
+		if err != nil {
+			h.setError(err)
+			h.logger.Error("Failed to read audit spool", zap.Error(err))
+			return false
+		}
+		if len(lines) == 0 {
+			h.logger.Info("Audit spool replayed")
+			return true
+		}
+
+		batch := make([]AuditEvent, 0, len(lines))
+		for _, line := range lines {
+			var event AuditEvent
+			if err := json.Unmarshal(line, &event); err != nil {
+				h.logger.Error("Dropping corrupted spooled audit event", zap.Error(err))
+				h.dropped.Add(1)
+				continue
+			}
+			batch = append(batch, event)
+		}
+
+		err = nil
+		if len(batch) > 0 {
+			err = h.post(batch)
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			h.drop(batch, err)
+			err = nil
+		}
+		if err != nil {
+			h.logger.Warn("Audit spool replay failed", zap.Int("spooled", h.spooled()), zap.Error(err))
+			return false
+		}
+
+		h.mu.Lock()
+		err = h.spool.advance(len(lines), size)
+		h.mu.Unlock()
+		if err != nil {
+			h.logger.Error("Failed to truncate audit spool", zap.Error(err))
+		}
+	}
+}
+
+// post - method for posting a batch to the endpoint
+// 4xx responses other than 408 and 429 are permanent errors
+// if error, return error
+func (h *HTTPObserver) post(batch []AuditEvent) error {
+	var body []byte
+	var err error
+	if h.cfg.BatchSize == 1 && len(batch) == 1 {
+		body, err = json.Marshal(batch[0])
+	} else {
+		body, err = json.Marshal(batch)
+	}
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.setError(err)
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			err = &permanentError{err: fmt.Errorf("audit endpoint rejected the events with status %d", resp.StatusCode)}
+		} else {
+			err = fmt.Errorf("audit endpoint returned status %d", resp.StatusCode)
+		}
+		h.setError(err)
+		return err
+	}
+
+	h.mu.Lock()
+	h.lastErr = ""
+	h.lastDelivery = time.Now()
+	h.mu.Unlock()
+
+	return nil
+}
+
+// toSpool - method for writing a batch to the spool
+// the batch is dropped if there is no spool or it is full
+func (h *HTTPObserver) toSpool(batch []AuditEvent) {
+	h.mu.Lock()
+	if h.spool == nil {
+		h.mu.Unlock()
+		h.drop(batch, errors.New("no spool configured"))
+		return
+	}
+	if h.spool.size() >= h.cfg.SpoolMaxBytes {
+		h.mu.Unlock()
+		h.drop(batch, errors.New("spool is full"))
+		return
+	}
+
+	for i, event := range batch {
+		if err := h.spool.push(event); err != nil {
+			h.mu.Unlock()
+			h.drop(batch[i:], fmt.Errorf("couldn't write spool: %w", err))
+			return
+		}
+	}
+	if err := h.spool.sync(); err != nil {
+		h.logger.Error("Failed to sync audit spool", zap.Error(err))
+	}
+	h.mu.Unlock()
+}
+
+// spooled - method for getting the number of spooled events
+func (h *HTTPObserver) spooled() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.spool == nil {
+		return 0
+	}
+	return h.spool.pending
+}
+
+// setError - method for recording the last delivery error
+func (h *HTTPObserver) setError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastErr = err.Error()
+}
+
+// drop - method for dropping undeliverable events
+func (h *HTTPObserver) drop(batch []AuditEvent, reason error) {
+	total := h.dropped.Add(uint64(len(batch)))
+	h.logger.Error("Audit events dropped",
+		zap.Int("events", len(batch)),
+		zap.Uint64("dropped_total", total),
+		zap.Error(reason),
+	)
+}
@@ -0,0 +1,244 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// auditReceiver - test endpoint recording the delivered events
+// status returns the response code of the next request
+type auditReceiver struct {
+	status   atomic.Int32
+	requests atomic.Int32
+	mu       sync.Mutex
+	bodies   []string
+	events   []int
+}
+
+func newAuditReceiver(t *testing.T) (*auditReceiver, *httptest.Server) {
+	r := &auditReceiver{}
+	r.status.Store(http.StatusOK)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests.Add(1)
+		code := int(r.status.Load())
+		if code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		var batch []AuditEvent
+		if err := json.Unmarshal(body, &batch); err != nil {
+			var event AuditEvent
+			require.NoError(t, json.Unmarshal(body, &event))
+			batch = []AuditEvent{event}
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.bodies = append(r.bodies, string(body))
+		for _, e := range batch {
+			r.events = append(r.events, e.TimeStamp)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return r, srv
+}
+
+func (r *auditReceiver) received() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.events...)
+}
+
+func httpConfig(url string) HTTPConfig {
+	return HTTPConfig{
+		URL:           url,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		Attempts:      3,
+		Backoff:       time.Millisecond,
+		MaxBackoff:    5 * time.Millisecond,
+		Timeout:       time.Second,
+		SpoolMaxBytes: 1 << 20,
+	}
+}
+
+func TestHTTPObserver_SingleEvent(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+
+	h, err := NewHTTPObserver(httpConfig(srv.URL), zap.NewNop())
+	require.NoError(t, err)
+
+	h.Notify(context.Background(), event(1))
+	require.NoError(t, h.Close(context.Background()))
+
+	assert.Equal(t, []int{1}, receiver.received())
+	assert.JSONEq(t, `{"ts":1,"metrics":["m1"],"ip_address":"10.0.0.1"}`, receiver.bodies[0])
+}
+
+func TestHTTPObserver_Batch(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+
+	cfg := httpConfig(srv.URL)
+	cfg.BatchSize = 3
+	h, err := NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 4; ts++ {
+		h.Notify(context.Background(), event(ts))
+	}
+	require.Eventually(t, func() bool { return len(receiver.received()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, h.Status().Pending, "a partial batch waits for the flush")
+
+	require.NoError(t, h.Close(context.Background()))
+	assert.Equal(t, []int{1, 2, 3, 4}, receiver.received())
+	assert.Equal(t, int32(2), receiver.requests.Load())
+}
+
+func TestHTTPObserver_Retry(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+	receiver.status.Store(http.StatusServiceUnavailable)
+
+	h, err := NewHTTPObserver(httpConfig(srv.URL), zap.NewNop())
+	require.NoError(t, err)
+
+	go func() {
+		for receiver.requests.Load() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		receiver.status.Store(http.StatusOK)
+	}()
+
+	h.Notify(context.Background(), event(1))
+	require.Eventually(t, func() bool { return len(receiver.received()) == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, h.Close(context.Background()))
+
+	assert.GreaterOrEqual(t, receiver.requests.Load(), int32(2))
+	assert.Empty(t, h.Status().LastError)
+}
+
+func TestHTTPObserver_SpoolReplay(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+	receiver.status.Store(http.StatusBadGateway)
+
+	cfg := httpConfig(srv.URL)
+	cfg.SpoolDir = t.TempDir()
+	h, err := NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 3; ts++ {
+		h.Notify(context.Background(), event(ts))
+	}
+	require.Eventually(t, func() bool { return h.Status().Pending == 3 }, time.Second, time.Millisecond)
+	status := h.Status()
+	assert.Positive(t, status.SpoolBytes)
+	assert.NotEmpty(t, status.LastError)
+
+	receiver.status.Store(http.StatusOK)
+	require.Eventually(t, func() bool { return h.Status().Pending == 0 }, time.Second, time.Millisecond)
+
+	h.Notify(context.Background(), event(4))
+	require.NoError(t, h.Close(context.Background()))
+
+	assert.Equal(t, []int{1, 2, 3, 4}, receiver.received())
+	assert.Equal(t, uint64(0), h.Status().Dropped)
+}
+
+func TestHTTPObserver_SpoolSurvivesRestart(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+	receiver.status.Store(http.StatusBadGateway)
+
+	cfg := httpConfig(srv.URL)
+	cfg.SpoolDir = t.TempDir()
+	h, err := NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	h.Notify(context.Background(), event(1))
+	h.Notify(context.Background(), event(2))
+	require.NoError(t, h.Close(context.Background()))
+	assert.Empty(t, receiver.received())
+
+	receiver.status.Store(http.StatusOK)
+	h, err = NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(receiver.received()) == 2 }, time.Second, time.Millisecond)
+	require.NoError(t, h.Close(context.Background()))
+
+	assert.Equal(t, []int{1, 2}, receiver.received())
+}
+
+func TestHTTPObserver_CloseSpoolsBlockedBatches(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+	receiver.status.Store(http.StatusBadGateway)
+
+	cfg := httpConfig(srv.URL)
+	cfg.SpoolDir = t.TempDir()
+	cfg.Backoff = time.Hour
+	cfg.MaxBackoff = time.Hour
+	h, err := NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	// the sender waits to retry the first batch, the second one blocks in Notify
+	h.Notify(context.Background(), event(1))
+	require.Eventually(t, func() bool { return receiver.requests.Load() == 1 }, time.Second, time.Millisecond)
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		h.Notify(context.Background(), event(2))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	require.NoError(t, h.Close(context.Background()))
+	<-blocked
+	assert.Equal(t, uint64(0), h.Status().Dropped)
+
+	receiver.status.Store(http.StatusOK)
+	cfg.Backoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	h, err = NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(receiver.received()) == 2 }, time.Second, time.Millisecond)
+	require.NoError(t, h.Close(context.Background()))
+
+	assert.Equal(t, []int{1, 2}, receiver.received())
+}
+
+func TestHTTPObserver_Drops(t *testing.T) {
+	receiver, srv := newAuditReceiver(t)
+	receiver.status.Store(http.StatusBadRequest)
+
+	cfg := httpConfig(srv.URL)
+	cfg.SpoolDir = t.TempDir()
+	cfg.SpoolMaxBytes = 1
+	h, err := NewHTTPObserver(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	h.Notify(context.Background(), event(1))
+	require.Eventually(t, func() bool { return h.Status().Dropped == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), receiver.requests.Load(), "rejected events are not retried")
+
+	receiver.status.Store(http.StatusBadGateway)
+	h.Notify(context.Background(), event(2))
+	h.Notify(context.Background(), event(3))
+	require.NoError(t, h.Close(context.Background()))
+
+	status := h.Status()
+	assert.Equal(t, uint64(2), status.Dropped, "the second event doesn't fit the spool")
+	assert.Equal(t, 1, status.Pending)
+}
@@ -1,14 +1,13 @@
 package observer
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"net/http"
-	"os"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
 	"time"
 
-	"go.uber.org/zap"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
 )
 
 // Observer - interface for the observer
@@ -21,96 +20,119 @@ type Observer interface {
 
 // AuditEvent - struct for the audit event
 // TimeStamp - timestamp of the event
-// Metrics - metrics of the event
-// IPAddress - IP address of the event
+// Metrics - series keys of the updated metrics
+// IPAddress - IP address of the client
+// Changes - types and values of the metrics before and after the update
+// Endpoint, Method - path and method of the request, or the name of the listener
+// RequestID - id of the request, taken from X-Request-ID or generated
+// UserAgent - user agent of the client
+// Signed - the payload carried a valid HashSHA256 signature
+// Encrypted - the payload was encrypted with the server public key
+// Identity - authenticated identity of the client, empty without authentication
 type AuditEvent struct {
-	TimeStamp int      `json:"ts"`
-	Metrics   []string `json:"metrics"`
-	IPAddress string   `json:"ip_address"`
+	TimeStamp int            `json:"ts"`
+	Metrics   []string       `json:"metrics"`
+	IPAddress string         `json:"ip_address"`
+	Changes   []MetricChange `json:"changes,omitempty"`
+	Endpoint  string         `json:"endpoint,omitempty"`
+	Method    string         `json:"method,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	UserAgent string         `json:"user_agent,omitempty"`
+	Signed    bool           `json:"signed,omitempty"`
+	Encrypted bool           `json:"encrypted,omitempty"`
+	Identity  string         `json:"identity,omitempty"`
 }
 
-// FileObserver - struct for the file observer
-// FilePath - path to the file
-// Logger - logger
-type FileObserver struct {
-	FilePath string
-	Logger   *zap.Logger
+// MetricChange - struct for the change of a single metric
+// computed by the storage in the write, see models.MetricChange
+type MetricChange = models.MetricChange
+
+// RequestInfo - struct for the request the audit events come from
+// the fields are copied into the AuditEvent of the same name
+type RequestInfo struct {
+	IPAddress string
+	Endpoint  string
+	Method    string
+	RequestID string
+	UserAgent string
+	Signed    bool
+	Encrypted bool
+	Identity  string
 }
 
-// Notify - method for notifying the file observer
-// notify the file observer
-func (f *FileObserver) Notify(ctx context.Context, event AuditEvent) {
-	file, err := os.OpenFile(f.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		f.Logger.Error("Failed to open audit file for writing", zap.Error(err))
-		return
-	}
-	defer file.Close()
-
-	data, err := json.MarshalIndent(event, "", "	")
-	if err != nil {
-		f.Logger.Error("Failed to mershal audit event", zap.Error(err))
-		return
-	}
-
-	if _, err := file.Write(data); err != nil {
-		f.Logger.Error("failed to write audit event to file", zap.Error(err))
-		return
-	}
+// Status - struct for the delivery state of an observer
+// Name - name of the observer
+// Pending - events waiting for delivery, in memory and on disk
+// SpoolBytes - size of the on-disk spool
+// Dropped - events lost for good
+// LastError - last delivery error, empty after a successful delivery
+// LastDelivery - time of the last successful delivery
+type Status struct {
+	Name         string     `json:"name"`
+	Pending      int        `json:"pending"`
+	SpoolBytes   int64      `json:"spool_bytes"`
+	Dropped      uint64     `json:"dropped"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+}
 
-	f.Logger.Info("audit event successfully added to the audit local file in ./data/audit_file.json")
+// StatusReporter - interface for the observers reporting their delivery state
+type StatusReporter interface {
+	Status() Status
 }
 
-// HTTPObserver - struct for the HTTP observer
-// URL - URL of the HTTP server
-// Logger - logger
-type HTTPObserver struct {
-	URL    string
-	Logger *zap.Logger
+// Idler - interface for the observers that can have nobody to pass the events to
+// Idle - reports if the observer would drop an event now, the service skips
+// building the changes of a write while all the observers are idle
+type Idler interface {
+	Idle() bool
 }
 
-// Notify - method for notifying the HTTP observer
-// notify the HTTP observer
-func (h *HTTPObserver) Notify(ctx context.Context, event AuditEvent) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+type contextKey string
 
-	body, err := json.Marshal(event)
-	if err != nil {
-		h.Logger.Error("Failed to create POST request", zap.Error(err))
-		return
-	}
+// requestKey - context key for storing the RequestInfo
+const requestKey contextKey = "request"
 
-	req, err := http.NewRequestWithContext(
-		ctx, http.MethodPost,
-		h.URL,
-		bytes.NewReader(body),
-	)
-	if err != nil {
-		h.Logger.Error("Failed to create POST request", zap.Error(err))
-		return
+// maxRequestIDLen - longest request ID taken from a client
+const maxRequestIDLen = 128
+
+// RequestID - method for getting the request ID to store and echo to the client
+// the ID sent by the client is kept if it has at most 128 characters,
+// all of them letters, digits or one of -_.:/+=, otherwise a random one is generated
+func RequestID(id string) string {
+	if validRequestID(id) {
+		return id
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		h.Logger.Error("Failed to send POST request", zap.Error(err))
-		return
+// validRequestID - method for checking the request ID sent by a client
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		h.Logger.Error("HTTP POST returned non-OK status", zap.Int("status", resp.StatusCode))
-		return
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:/+=", c):
+		default:
+			return false
+		}
 	}
-
-	h.Logger.Info("audit event successfully sent to HTTP server")
+	return true
 }
 
-type contextKey string
+// WithRequest - method for putting the request info into the context for the audit events
+func WithRequest(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestKey, info)
+}
 
-// ReqIDKey - context key for storing request ID (client IP address)
-// used to identify the source of requests in audit events
-const ReqIDKey contextKey = "reqID"
+// RequestFrom - method for getting the request info from the context
+// returns false if there is none
+func RequestFrom(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestKey).(RequestInfo)
+	return info, ok
+}
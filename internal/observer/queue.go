@@ -0,0 +1,423 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// OverflowPolicy - what a full audit queue does with a new event
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest - the oldest queued event is dropped to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowBlock - the caller waits for room or until its context is done
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowSpill - the event is written to a spill file and queued later
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// ParseOverflowPolicy - method for parsing the overflow policy
+// if error, return error
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowDropOldest, OverflowBlock, OverflowSpill:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q, want drop-oldest, block or spill", s)
+	}
+}
+
+// QueueConfig - struct for the audit queue settings
+// Size - number of events the queue holds
+// Workers - number of goroutines delivering the events
+// Overflow - what to do with a new event when the queue is full
+// SpillDir - directory of the spill files, used by OverflowSpill
+// SpillMaxBytes - size limit of the spill file on the disk, new events are dropped once it is reached
+type QueueConfig struct {
+	Size          int
+	Workers       int
+	Overflow      OverflowPolicy
+	SpillDir      string
+	SpillMaxBytes int64
+}
+
+// Validate - method for validating the queue settings
+// if error, return error
+func (c QueueConfig) Validate() error {
+	if c.Size <= 0 {
+		return fmt.Errorf("invalid audit queue size: %d", c.Size)
+	}
+	if c.Workers <= 0 {
+		return fmt.Errorf("invalid audit workers count: %d", c.Workers)
+	}
+	if _, err := ParseOverflowPolicy(string(c.Overflow)); err != nil {
+		return err
+	}
+	if c.Overflow == OverflowSpill && c.SpillDir == "" {
+		return errors.New("audit spill directory is required by the spill policy")
+	}
+	if c.Overflow == OverflowSpill && c.SpillMaxBytes <= 0 {
+		return fmt.Errorf("invalid audit spill size limit: %d", c.SpillMaxBytes)
+	}
+	return nil
+}
+
+// queuedEvent - struct for an event waiting in the queue
+type queuedEvent struct {
+	ctx   context.Context
+	event AuditEvent
+}
+
+// QueueObserver - struct for the asynchronous observer
+// events are put in a bounded queue and delivered to the wrapped observer by workers
+// so a slow observer doesn't hold up the request
+type QueueObserver struct {
+	name   string
+	next   Observer
+	policy OverflowPolicy
+	logger *zap.Logger
+
+	queue   chan queuedEvent
+	refill  chan struct{}
+	closing chan struct{}
+	stop    chan struct{}
+
+	// mu is held for reading while an event is enqueued
+	mu     sync.RWMutex
+	closed bool
+
+	spillMu  sync.Mutex
+	spill    *spillFile
+	spillMax int64
+
+	dropped atomic.Uint64
+	spilled atomic.Uint64
+
+	workers  sync.WaitGroup
+	refiller sync.WaitGroup
+}
+
+// NewQueueObserver - constructor for QueueObserver
+// name - name of the queue, used in logs and for the spill file
+// next - observer the events are delivered to
+// spilled events left by the previous run are delivered first
+// if error, return error
+func NewQueueObserver(name string, next Observer, cfg QueueConfig, logger *zap.Logger) (*QueueObserver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	q := &QueueObserver{
+		name:    name,
+		next:    next,
+		policy:  cfg.Overflow,
+		logger:  logger,
+		queue:   make(chan queuedEvent, cfg.Size),
+		refill:  make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	if cfg.Overflow == OverflowSpill {
+		q.spillMax = cfg.SpillMaxBytes
+		spill, err := openSpill(filepath.Join(cfg.SpillDir, name+".spill"))
+		if err != nil {
+			return nil, err
+		}
+		q.spill = spill
+
+		q.refiller.Add(1)
+		go q.refillLoop()
+	}
+
+	q.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go q.work()
+	}
+
+	return q, nil
+}
+
+// Notify - method for queueing an audit event
+// the event is delivered later with a context that is not canceled with the request
+// after Close the event is delivered right away
+func (q *QueueObserver) Notify(ctx context.Context, event AuditEvent) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	item := queuedEvent{ctx: context.WithoutCancel(ctx), event: event}
+
+	if q.closed {
+		q.next.Notify(item.ctx, event)
+		return
+	}
+
+	switch q.policy {
+	case OverflowBlock:
+		select {
+		case q.queue <- item:
+		case <-ctx.Done():
+			q.drop()
+		}
+	case OverflowSpill:
+		q.enqueueOrSpill(item)
+	default:
+		q.enqueueDropOldest(item)
+	}
+}
+
+// Dropped - method for getting the number of dropped events
+func (q *QueueObserver) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+// Spilled - method for getting the number of events written to the spill file
+func (q *QueueObserver) Spilled() uint64 {
+	return q.spilled.Load()
+}
+
+// Status - method for getting the state of the queue
+// Pending counts the queued and the spilled events
+func (q *QueueObserver) Status() Status {
+	status := Status{
+		Name:    q.name + " queue",
+		Pending: len(q.queue),
+		Dropped: q.Dropped(),
+	}
+
+	if q.spill != nil {
+		q.spillMu.Lock()
+		status.Pending += q.spill.pending
+		status.SpoolBytes = q.spill.size()
+		q.spillMu.Unlock()
+	}
+
+	return status
+}
+
+// Close - method for draining the queue
+// waits until every queued and spilled event is delivered or ctx is done
+// if ctx is done first, the events left in the queue are spilled or dropped
+// if error, return error
+func (q *QueueObserver) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	close(q.closing)
+
+	drained := make(chan struct{})
+	go func() {
+		q.refiller.Wait()
+		close(q.queue)
+		q.workers.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		close(q.stop)
+		q.refiller.Wait()
+		left := q.saveQueued()
+		err = fmt.Errorf("audit queue %s: drain aborted with %d events left: %w", q.name, left, ctx.Err())
+	}
+
+	if q.spill != nil {
+		q.spillMu.Lock()
+		if cerr := q.spill.close(); cerr != nil && err == nil {
+			err = fmt.Errorf("audit queue %s: %w", q.name, cerr)
+		}
+		q.spillMu.Unlock()
+	}
+
+	q.logger.Info("Audit queue closed",
+		zap.String("queue", q.name),
+		zap.Uint64("dropped", q.Dropped()),
+		zap.Uint64("spilled", q.Spilled()),
+	)
+
+	return err
+}
+
+// enqueueDropOldest - method for queueing an event
+// if the queue is full, the oldest event is dropped
+func (q *QueueObserver) enqueueDropOldest(item queuedEvent) {
+	for {
+		select {
+		case q.queue <- item:
+			return
+		default:
+		}
+
+		select {
+		case <-q.queue:
+			q.drop()
+		default:
+		}
+	}
+}
+
+// enqueueOrSpill - method for queueing an event
+// if the queue is full or older events are still spilled, the event goes to the spill file
+// the spilled event is synced to the disk, a full spill file drops the new event
+func (q *QueueObserver) enqueueOrSpill(item queuedEvent) {
+	q.spillMu.Lock()
+	if q.spill.pending == 0 {
+		select {
+		case q.queue <- item:
+			q.spillMu.Unlock()
+			return
+		default:
+		}
+	}
+
+	if q.spill.fileSize() >= q.spillMax {
+		q.spillMu.Unlock()
+		q.drop()
+		return
+	}
+
+	err := q.spill.push(item.event)
+	if err == nil {
+		err = q.spill.sync()
+	}
+	q.spillMu.Unlock()
+	if err != nil {
+		q.logger.Error("Failed to spill audit event", zap.String("queue", q.name), zap.Error(err))
+		q.drop()
+		return
+	}
+	q.spilled.Add(1)
+
+	select {
+	case q.refill <- struct{}{}:
+	default:
+	}
+}
+
+// refillLoop - method for moving the spilled events back into the queue
+// returns when the queue is closed and the spill file is empty, or on stop
+func (q *QueueObserver) refillLoop() {
+	defer q.refiller.Done()
+
+	for {
+		q.spillMu.Lock()
+		lines, n, err := q.spill.peek(1)
+		q.spillMu.Unlock()
+
+		if err != nil {
+			q.logger.Error("Failed to read spilled audit events", zap.String("queue", q.name), zap.Error(err))
+			return
+		}
+
+		var event AuditEvent
+		if len(lines) > 0 {
+			if err := json.Unmarshal(lines[0], &event); err != nil {
+				q.logger.Error("Dropping corrupted spilled audit event", zap.String("queue", q.name), zap.Error(err))
+				q.spillMu.Lock()
+				q.spill.advance(1, n)
+				q.spillMu.Unlock()
+				q.drop()
+				continue
+			}
+		}
+
+		if len(lines) == 0 {
+			select {
+			case <-q.refill:
+				continue
+			case <-q.closing:
+				return
+			case <-q.stop:
+				return
+			}
+		}
+
+		select {
+		case q.queue <- queuedEvent{ctx: context.Background(), event: event}:
+			q.spillMu.Lock()
+			if err := q.spill.advance(1, n); err != nil {
+				q.logger.Error("Failed to truncate spill file", zap.String("queue", q.name), zap.Error(err))
+			}
+			q.spillMu.Unlock()
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// work - method for delivering the queued events to the wrapped observer
+func (q *QueueObserver) work() {
+	defer q.workers.Done()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case item, ok := <-q.queue:
+			if !ok {
+				return
+			}
+			q.next.Notify(item.ctx, item.event)
+		}
+	}
+}
+
+// saveQueued - method for saving the events left in the queue after an aborted drain
+// the events are spilled if the queue has a spill file with room, dropped otherwise
+// the spill file is synced when it is closed
+// returns the number of events left
+func (q *QueueObserver) saveQueued() int {
+	left := 0
+	for {
+		select {
+		case item, ok := <-q.queue:
+			if !ok {
+				return left
+			}
+			left++
+			if q.spill == nil {
+				q.drop()
+				continue
+			}
+			q.spillMu.Lock()
+			full := q.spill.fileSize() >= q.spillMax
+			var err error
+			if !full {
+				err = q.spill.push(item.event)
+			}
+			q.spillMu.Unlock()
+			if full || err != nil {
+				q.drop()
+			}
+		default:
+			return left
+		}
+	}
+}
+
+// drop - method for counting a dropped event
+// a warning is logged when the count reaches a power of two
+func (q *QueueObserver) drop() {
+	n := q.dropped.Add(1)
+	if n&(n-1) == 0 {
+		q.logger.Warn("Audit queue overflow, events dropped",
+			zap.String("queue", q.name),
+			zap.Uint64("dropped", n),
+		)
+	}
+}
@@ -0,0 +1,176 @@
+package observer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordObserver - observer that records the events, optionally waiting on gate
+type recordObserver struct {
+	gate   chan struct{}
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recordObserver) Notify(ctx context.Context, event AuditEvent) {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordObserver) timestamps() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ts := make([]int, 0, len(r.events))
+	for _, e := range r.events {
+		ts = append(ts, e.TimeStamp)
+	}
+	return ts
+}
+
+func event(ts int) AuditEvent {
+	return AuditEvent{TimeStamp: ts, Metrics: []string{fmt.Sprintf("m%d", ts)}, IPAddress: "10.0.0.1"}
+}
+
+func TestQueueObserver_DoesNotBlockCaller(t *testing.T) {
+	next := &recordObserver{gate: make(chan struct{})}
+	q, err := NewQueueObserver("test", next, QueueConfig{Size: 4, Workers: 1, Overflow: OverflowDropOldest}, zap.NewNop())
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		q.Notify(context.Background(), event(1))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Notify blocked on a slow observer")
+	}
+
+	close(next.gate)
+	require.NoError(t, q.Close(context.Background()))
+	assert.Equal(t, []int{1}, next.timestamps())
+}
+
+func TestQueueObserver_DropOldest(t *testing.T) {
+	next := &recordObserver{gate: make(chan struct{})}
+	q, err := NewQueueObserver("test", next, QueueConfig{Size: 2, Workers: 1, Overflow: OverflowDropOldest}, zap.NewNop())
+	require.NoError(t, err)
+
+	// the worker takes the first event and waits on the gate
+	q.Notify(context.Background(), event(1))
+	require.Eventually(t, func() bool { return len(q.queue) == 0 }, time.Second, time.Millisecond)
+
+	for ts := 2; ts <= 5; ts++ {
+		q.Notify(context.Background(), event(ts))
+	}
+	assert.Equal(t, uint64(2), q.Dropped())
+
+	close(next.gate)
+	require.NoError(t, q.Close(context.Background()))
+	assert.Equal(t, []int{1, 4, 5}, next.timestamps())
+}
+
+func TestQueueObserver_Block(t *testing.T) {
+	next := &recordObserver{gate: make(chan struct{})}
+	q, err := NewQueueObserver("test", next, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowBlock}, zap.NewNop())
+	require.NoError(t, err)
+
+	q.Notify(context.Background(), event(1))
+	require.Eventually(t, func() bool { return len(q.queue) == 0 }, time.Second, time.Millisecond)
+	q.Notify(context.Background(), event(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	q.Notify(ctx, event(3))
+	assert.Equal(t, uint64(1), q.Dropped(), "a blocked caller gives up when its context is done")
+
+	close(next.gate)
+	require.NoError(t, q.Close(context.Background()))
+	assert.Equal(t, []int{1, 2}, next.timestamps())
+}
+
+func TestQueueObserver_Spill(t *testing.T) {
+	dir := t.TempDir()
+	next := &recordObserver{gate: make(chan struct{})}
+	q, err := NewQueueObserver("test", next, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowSpill, SpillDir: dir, SpillMaxBytes: 1 << 20}, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 6; ts++ {
+		q.Notify(context.Background(), event(ts))
+	}
+	assert.Equal(t, uint64(0), q.Dropped())
+	assert.Positive(t, q.Spilled())
+
+	close(next.gate)
+	require.NoError(t, q.Close(context.Background()))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, next.timestamps())
+
+	_, err = os.Stat(filepath.Join(dir, "test.spill"))
+	assert.True(t, os.IsNotExist(err), "drained spill file is removed")
+}
+
+func TestQueueObserver_SpillSurvivesAbortedDrain(t *testing.T) {
+	dir := t.TempDir()
+	blocked := &recordObserver{gate: make(chan struct{})}
+	defer close(blocked.gate)
+
+	q, err := NewQueueObserver("test", blocked, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowSpill, SpillDir: dir, SpillMaxBytes: 1 << 20}, zap.NewNop())
+	require.NoError(t, err)
+
+	for ts := 1; ts <= 4; ts++ {
+		q.Notify(context.Background(), event(ts))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.Error(t, q.Close(ctx))
+
+	next := &recordObserver{}
+	q, err = NewQueueObserver("test", next, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowSpill, SpillDir: dir, SpillMaxBytes: 1 << 20}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, q.Close(context.Background()))
+
+	// the event held by the blocked worker is lost, the rest is delivered after the restart
+	assert.ElementsMatch(t, []int{2, 3, 4}, next.timestamps())
+}
+
+func TestQueueConfig_Validate(t *testing.T) {
+	assert.NoError(t, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowBlock}.Validate())
+	assert.Error(t, QueueConfig{Size: 0, Workers: 1, Overflow: OverflowBlock}.Validate())
+	assert.Error(t, QueueConfig{Size: 1, Workers: 0, Overflow: OverflowBlock}.Validate())
+	assert.Error(t, QueueConfig{Size: 1, Workers: 1, Overflow: "never"}.Validate())
+	assert.Error(t, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowSpill}.Validate())
+	assert.Error(t, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowSpill, SpillDir: "spill"}.Validate())
+}
+
+func TestQueueObserver_SpillLimit(t *testing.T) {
+	dir := t.TempDir()
+	next := &recordObserver{gate: make(chan struct{})}
+	q, err := NewQueueObserver("test", next, QueueConfig{Size: 1, Workers: 1, Overflow: OverflowSpill, SpillDir: dir, SpillMaxBytes: 1}, zap.NewNop())
+	require.NoError(t, err)
+
+	// one event fills the spill file, the next ones are dropped
+	for ts := 1; ts <= 5; ts++ {
+		q.Notify(context.Background(), event(ts))
+	}
+	assert.Positive(t, q.Dropped())
+
+	close(next.gate)
+	require.NoError(t, q.Close(context.Background()))
+	assert.Len(t, next.timestamps(), 5-int(q.Dropped()))
+}
@@ -0,0 +1,188 @@
+package observer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spillFile - struct for an on-disk fifo of json lines
+// used by the audit queue overflow and the HTTP dead-letter spool
+// lines are read back in the order they were written
+// the file is truncated once every line has been read back
+type spillFile struct {
+	path     string
+	file     *os.File
+	readOff  int64
+	writeOff int64
+	pending  int
+}
+
+// openSpill - method for opening the spill file
+// lines left by the previous run are counted as pending
+// if error, return error
+func openSpill(path string) (*spillFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open spill file: %w", err)
+	}
+
+	s := &spillFile{path: path, file: file}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			s.writeOff += int64(len(line))
+			s.pending++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("couldn't read spill file: %w", err)
+		}
+	}
+
+	// a torn last line is dropped
+	if err := file.Truncate(s.writeOff); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("couldn't truncate spill file: %w", err)
+	}
+
+	return s, nil
+}
+
+// push - method for appending a value to the spill file as a json line
+// if error, return error
+func (s *spillFile) push(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if _, err := s.file.WriteAt(data, s.writeOff); err != nil {
+		return fmt.Errorf("couldn't write spill file: %w", err)
+	}
+	s.writeOff += int64(len(data))
+	s.pending++
+
+	return nil
+}
+
+// sync - method for flushing the pushed lines to the disk
+// called once the lines of a spill are pushed, so they survive a crash
+// if error, return error
+func (s *spillFile) sync() error {
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("couldn't sync spill file: %w", err)
+	}
+	return nil
+}
+
+// peek - method for reading the oldest lines without removing them
+// max - number of lines to read at most
+// returns the total size of the lines to pass to advance
+// returns no lines if the file is empty
+// if error, return error
+func (s *spillFile) peek(max int) ([][]byte, int64, error) {
+	if s.pending == 0 {
+		return nil, 0, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.readOff, s.writeOff-s.readOff))
+
+	var lines [][]byte
+	var size int64
+	for len(lines) < max && len(lines) < s.pending {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, 0, fmt.Errorf("couldn't read spill file: %w", err)
+		}
+		lines = append(lines, bytes.TrimSpace(line))
+		size += int64(len(line))
+	}
+
+	return lines, size, nil
+}
+
+// size - method for getting the number of bytes not read back yet
+func (s *spillFile) size() int64 {
+	return s.writeOff - s.readOff
+}
+
+// fileSize - method for getting the size of the file on the disk
+// the lines read back still take the disk until the file is emptied
+func (s *spillFile) fileSize() int64 {
+	return s.writeOff
+}
+
+// advance - method for removing the oldest lines
+// count, size - number and total size of the lines returned by peek
+// the file is truncated once it is empty
+func (s *spillFile) advance(count int, size int64) error {
+	s.readOff += size
+	s.pending -= count
+
+	if s.pending > 0 {
+		return nil
+	}
+
+	s.readOff, s.writeOff = 0, 0
+	return s.file.Truncate(0)
+}
+
+// close - method for closing the spill file
+// the lines already read back are cut off, an empty file is removed
+// the lines left are synced to the disk
+// if error, return error
+func (s *spillFile) close() error {
+	if s.pending == 0 {
+		s.file.Close()
+		return os.Remove(s.path)
+	}
+
+	if s.readOff > 0 {
+		rest := make([]byte, s.writeOff-s.readOff)
+		if _, err := s.file.ReadAt(rest, s.readOff); err != nil {
+			s.file.Close()
+			return fmt.Errorf("couldn't read spill file: %w", err)
+		}
+		s.file.Close()
+
+		tmp := s.path + ".tmp"
+		if err := writeSynced(tmp, rest); err != nil {
+			return fmt.Errorf("couldn't compact spill file: %w", err)
+		}
+		return os.Rename(tmp, s.path)
+	}
+
+	if err := s.sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// writeSynced - method for writing a file and syncing it to the disk
+// if error, return error
+func writeSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
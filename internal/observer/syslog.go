@@ -0,0 +1,314 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// syslogSeverity - severity of the audit messages, informational
+const syslogSeverity = 6
+
+// syslogMaxDatagram - max size of a message sent over udp or a unix datagram socket
+// RFC 5426 only requires the receivers to take 2048 bytes
+const syslogMaxDatagram = 2048
+
+// syslogSDID - id of the structured data element with the event fields
+// 32473 is the enterprise number reserved for documentation by RFC 5612
+const syslogSDID = "audit@32473"
+
+// syslogFacilities - facility codes by their names from RFC 5424
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseFacility - method for getting the syslog facility code by its name
+// if error, return error
+func ParseFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %q", name)
+	}
+	return facility, nil
+}
+
+// SyslogConfig - struct for the syslog observer settings
+// Address - udp://host:port, tcp://host:port or unix:///path/to/socket
+// Facility - facility code of the messages, see ParseFacility
+// AppName - APP-NAME of the messages
+// Timeout - timeout of the dial and of every write
+type SyslogConfig struct {
+	Address  string
+	Facility int
+	AppName  string
+	Timeout  time.Duration
+}
+
+// Validate - method for validating the syslog observer settings
+// if error, return error
+func (c SyslogConfig) Validate() error {
+	if _, _, err := parseSyslogAddress(c.Address); err != nil {
+		return err
+	}
+	switch {
+	case c.Facility < 0 || c.Facility > 23:
+		return fmt.Errorf("invalid syslog facility: %d", c.Facility)
+	case c.AppName == "" || len(c.AppName) > 48 || strings.ContainsAny(c.AppName, " \t\n"):
+		return fmt.Errorf("invalid syslog app name: %q", c.AppName)
+	case c.Timeout <= 0:
+		return fmt.Errorf("invalid syslog timeout: %s", c.Timeout)
+	}
+	return nil
+}
+
+// parseSyslogAddress - method for splitting the address into the network and the address to dial
+// if error, return error
+func parseSyslogAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address: %w", err)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid syslog address: %q has no host", address)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog address: %q has no path", address)
+		}
+		return u.Scheme, u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid syslog address: %q, must be udp://, tcp:// or unix://", address)
+}
+
+// SyslogObserver - struct for the syslog observer
+// events are sent as RFC 5424 messages, the event fields are in the structured data
+// and the whole event is the json message
+// messages over tcp and unix stream sockets are framed by octet counting from RFC 6587
+// messages over datagram sockets are cut down to syslogMaxDatagram, see format
+// the connection is dialed on the first event and redialed once when a write fails
+type SyslogObserver struct {
+	cfg      SyslogConfig
+	network  string
+	address  string
+	hostname string
+	procID   string
+	logger   *zap.Logger
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool
+	closed bool
+}
+
+// NewSyslogObserver - constructor for SyslogObserver
+// if error, return error
+func NewSyslogObserver(cfg SyslogConfig, logger *zap.Logger) (*SyslogObserver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	network, address, _ := parseSyslogAddress(cfg.Address)
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &SyslogObserver{
+		cfg:      cfg,
+		network:  network,
+		address:  address,
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+		logger:   logger,
+	}, nil
+}
+
+// Notify - method for sending the event to syslog
+func (s *SyslogObserver) Notify(ctx context.Context, event AuditEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Error("Failed to marshal audit event", zap.Error(err))
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.logger.Error("Audit event sent after the syslog observer was closed")
+		return
+	}
+
+	if err := s.write(event, body, now); err != nil {
+		s.closeConn()
+		// the server could have closed an idle connection
+		if err = s.write(event, body, now); err != nil {
+			s.closeConn()
+			s.logger.Error("Failed to send audit event to syslog", zap.Error(err))
+		}
+	}
+}
+
+// format - method for building the RFC 5424 message of the event
+// body - json of the event
+// limit - max size of the message, 0 for no limit
+// a message over the limit drops the changes from the body first, then the metrics,
+// only their number is left in the metric_count param, and at last it is cut at the limit
+// the truncated param lists what was left out
+func (s *SyslogObserver) format(event AuditEvent, body []byte, now time.Time, limit int) []byte {
+	msg := s.message(event, body, now, "", 0)
+	if limit == 0 || len(msg) <= limit {
+		return msg
+	}
+
+	// the full event was marshaled, the summaries can't fail
+	summary := event
+	summary.Changes = nil
+	body, _ = json.Marshal(summary)
+	if msg = s.message(summary, body, now, "changes", 0); len(msg) <= limit {
+		return msg
+	}
+
+	summary.Metrics = nil
+	body, _ = json.Marshal(summary)
+	if msg = s.message(summary, body, now, "changes,metrics", len(event.Metrics)); len(msg) <= limit {
+		return msg
+	}
+
+	return s.message(summary, body, now, "changes,metrics,message", len(event.Metrics))[:limit]
+}
+
+// message - method for writing the header, the structured data and the body of a message
+// every metric is a param of its own, a series key can have any character
+// truncated - what was left out of the event, empty if nothing
+// count - number of the metrics left out
+func (s *SyslogObserver) message(event AuditEvent, body []byte, now time.Time, truncated string, count int) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s audit [%s",
+		s.cfg.Facility*8+syslogSeverity,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, s.cfg.AppName, s.procID, syslogSDID)
+
+	param := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, ` %s="%s"`, name, escapeSDValue(value))
+		}
+	}
+	param("ts", strconv.Itoa(event.TimeStamp))
+	// the summary params come first, so a cut message still has them
+	param("truncated", truncated)
+	if count > 0 {
+		param("metric_count", strconv.Itoa(count))
+	}
+	for _, metric := range event.Metrics {
+		param("metric", metric)
+	}
+	param("ip_address", event.IPAddress)
+	param("endpoint", event.Endpoint)
+	param("method", event.Method)
+	param("request_id", event.RequestID)
+	param("user_agent", event.UserAgent)
+	param("signed", strconv.FormatBool(event.Signed))
+	param("encrypted", strconv.FormatBool(event.Encrypted))
+	param("identity", event.Identity)
+
+	b.WriteString("] ")
+	b.Write(body)
+
+	return []byte(b.String())
+}
+
+// escapeSDValue - method for escaping a structured data value as RFC 5424 requires
+func escapeSDValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+// write - method for sending the message of an event, dialing first if there is no connection
+// the message is built once the transport is known, a datagram one limits its size
+// must be called with the lock held
+// if error, return error
+func (s *SyslogObserver) write(event AuditEvent, body []byte, now time.Time) error {
+	if s.conn == nil {
+		conn, stream, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn, s.stream = conn, stream
+	}
+
+	var msg []byte
+	if s.stream {
+		msg = s.format(event, body, now, 0)
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	} else {
+		msg = s.format(event, body, now, syslogMaxDatagram)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout))
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// dial - method for connecting to syslog
+// a unix socket is tried as a datagram socket first, like /dev/log, then as a stream socket
+// returns true if the connection is a stream
+// if error, return error
+func (s *SyslogObserver) dial() (net.Conn, bool, error) {
+	if s.network != "unix" {
+		conn, err := net.DialTimeout(s.network, s.address, s.cfg.Timeout)
+		return conn, s.network == "tcp", err
+	}
+
+	conn, err := net.DialTimeout("unixgram", s.address, s.cfg.Timeout)
+	if err == nil {
+		return conn, false, nil
+	}
+	conn, serr := net.DialTimeout("unix", s.address, s.cfg.Timeout)
+	if serr != nil {
+		return nil, false, errors.Join(err, serr)
+	}
+	return conn, true, nil
+}
+
+// closeConn - method for dropping the connection, the next write dials again
+// must be called with the lock held
+func (s *SyslogObserver) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Close - method for closing the syslog connection
+// if error, return error
+func (s *SyslogObserver) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,198 @@
+package observer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func syslogConfig(address string) SyslogConfig {
+	return SyslogConfig{Address: address, Facility: 16, AppName: "metrics-server", Timeout: time.Second}
+}
+
+// checkSyslogMessage - checks the header, the structured data and the json body of a message
+func checkSyslogMessage(t *testing.T, msg string, ts int) {
+	fields := strings.SplitN(msg, " ", 7)
+	require.Len(t, fields, 7, msg)
+	assert.Equal(t, "<134>1", fields[0])
+	_, err := time.Parse(time.RFC3339Nano, fields[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "metrics-server", fields[3])
+	assert.Equal(t, "audit", fields[5])
+
+	sd, body, ok := strings.Cut(fields[6], "] ")
+	require.True(t, ok, msg)
+	assert.Contains(t, sd, `[audit@32473 ts="`+strconv.Itoa(ts)+`" metric="m`+strconv.Itoa(ts)+`" ip_address="10.0.0.1"`)
+
+	var event AuditEvent
+	require.NoError(t, json.Unmarshal([]byte(body), &event))
+	assert.Equal(t, ts, event.TimeStamp)
+}
+
+func TestSyslogObserver_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewSyslogObserver(syslogConfig("udp://"+conn.LocalAddr().String()), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	s.Notify(context.Background(), event(1))
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	checkSyslogMessage(t, string(buf[:n]), 1)
+}
+
+func TestSyslogObserver_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			// octet counting: the length, a space and the message
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+
+	s, err := NewSyslogObserver(syslogConfig("tcp://"+ln.Addr().String()), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	s.Notify(context.Background(), event(1))
+	s.Notify(context.Background(), event(2))
+
+	for ts := 1; ts <= 2; ts++ {
+		select {
+		case msg := <-received:
+			checkSyslogMessage(t, msg, ts)
+		case <-time.After(time.Second):
+			t.Fatal("message not received")
+		}
+	}
+}
+
+func TestSyslogObserver_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewSyslogObserver(syslogConfig("unix://"+path), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	s.Notify(context.Background(), event(1))
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	checkSyslogMessage(t, string(buf[:n]), 1)
+}
+
+func TestSyslogObserver_Format(t *testing.T) {
+	s, err := NewSyslogObserver(syslogConfig("udp://localhost:514"), zap.NewNop())
+	require.NoError(t, err)
+	now := time.Now()
+
+	format := func(event AuditEvent, limit int) string {
+		body, err := json.Marshal(event)
+		require.NoError(t, err)
+		return string(s.format(event, body, now, limit))
+	}
+
+	// every metric is a param of its own
+	small := event(1)
+	small.Metrics = []string{`requests{path="/a,b"}`, "load"}
+	msg := format(small, syslogMaxDatagram)
+	assert.Contains(t, msg, `metric="requests{path=\"/a,b\"}" metric="load"`)
+	assert.NotContains(t, msg, "truncated=")
+
+	value := 1.5
+	big := event(2)
+	for i := 0; i < 20; i++ {
+		big.Changes = append(big.Changes, MetricChange{ID: "m" + strconv.Itoa(i), MType: "gauge", NewValue: &value})
+	}
+
+	// a stream takes the whole event
+	msg = format(big, 0)
+	assert.Contains(t, msg, `"changes":`)
+
+	// the changes are dropped first
+	msg = format(big, 600)
+	assert.LessOrEqual(t, len(msg), 600)
+	assert.Contains(t, msg, `metric="m2" ip_address="10.0.0.1"`)
+	assert.Contains(t, msg, `truncated="changes"`)
+	assert.NotContains(t, msg, `"changes":`)
+
+	// then the metrics, only their number is kept
+	for i := 0; i < 100; i++ {
+		big.Metrics = append(big.Metrics, strings.Repeat("x", 20)+strconv.Itoa(i))
+	}
+	msg = format(big, 600)
+	assert.LessOrEqual(t, len(msg), 600)
+	assert.Contains(t, msg, `metric_count="101"`)
+	assert.Contains(t, msg, `truncated="changes,metrics"`)
+	_, body, _ := strings.Cut(msg, "] ")
+	var summary AuditEvent
+	require.NoError(t, json.Unmarshal([]byte(body), &summary))
+	assert.Equal(t, 2, summary.TimeStamp)
+	assert.Empty(t, summary.Metrics)
+
+	// and at last the message is cut
+	big.UserAgent = strings.Repeat("a", 1000)
+	msg = format(big, 600)
+	assert.Len(t, msg, 600)
+	assert.Contains(t, msg, `truncated="changes,metrics,message"`)
+}
+
+func TestSyslogConfig_Validate(t *testing.T) {
+	assert.NoError(t, syslogConfig("udp://localhost:514").Validate())
+	assert.Error(t, syslogConfig("localhost:514").Validate())
+	assert.Error(t, syslogConfig("unix://").Validate())
+
+	cfg := syslogConfig("tcp://localhost:601")
+	cfg.AppName = "metrics server"
+	assert.Error(t, cfg.Validate())
+
+	facility, err := ParseFacility("LOCAL3")
+	require.NoError(t, err)
+	assert.Equal(t, 19, facility)
+	_, err = ParseFacility("local8")
+	assert.Error(t, err)
+
+	assert.Equal(t, `a\"b\\c\]`, escapeSDValue(`a"b\c]`))
+}
@@ -0,0 +1,333 @@
+// Package otlp converts OTLP metric exports into the metrics of this server.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// maxErrors - rejection reasons kept for the partial success message
+const maxErrors = 10
+
+// resourceLabels - resource attributes copied into the labels of every data point
+var resourceLabels = []string{"service.name", "service.namespace", "service.instance.id"}
+
+// Store - interface for reading the stored values
+// used to reject the histograms that don't fit the stored buckets
+type Store interface {
+	GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool)
+}
+
+// Result - struct for the result of a conversion
+// Metrics - metrics to store
+// Rejected - number of rejected data points
+// Errors - first rejection reasons
+type Result struct {
+	Metrics  []models.Metrics
+	Rejected int64
+	Errors   []string
+
+	undo *cumulativeUndo
+}
+
+// Rollback - method for restoring the cumulative streams moved by the conversion
+// called if the metrics were not stored, so a retry of the export gets the same deltas
+func (r Result) Rollback() {
+	r.undo.rollback()
+}
+
+// PartialSuccess - returns the OTLP partial success of the result, nil if nothing was rejected
+func (r Result) PartialSuccess() *colmetricspb.ExportMetricsPartialSuccess {
+	if r.Rejected == 0 {
+		return nil
+	}
+
+	return &colmetricspb.ExportMetricsPartialSuccess{
+		RejectedDataPoints: r.Rejected,
+		ErrorMessage:       strings.Join(r.Errors, "; "),
+	}
+}
+
+// converter - state of one conversion
+type converter struct {
+	ctx        context.Context
+	store      Store
+	cumulative *Cumulative
+	result     Result
+}
+
+// Convert - converts an export request into metrics
+// gauges and non-monotonic cumulative sums become gauges
+// non-monotonic delta sums move the stored gauge, the storage adds them in the write
+// monotonic sums become counters, cumulative ones are turned into deltas to the previous point
+// of their stream kept in cumulative, the result is rolled back if the metrics are not stored
+// explicit bucket histograms become histograms, cumulative ones are turned into deltas the same way
+// data point attributes become labels with names sanitized
+// exponential histograms and summaries are rejected
+func Convert(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest, store Store, cumulative *Cumulative) Result {
+	c := converter{
+		ctx:        ctx,
+		store:      store,
+		cumulative: cumulative,
+		result:     Result{undo: &cumulativeUndo{state: cumulative}},
+	}
+
+	for _, rm := range req.GetResourceMetrics() {
+		resource := make(map[string]string)
+		for _, kv := range rm.GetResource().GetAttributes() {
+			for _, name := range resourceLabels {
+				if kv.GetKey() == name {
+					resource[SanitizeLabel(name)] = attributeValue(kv.GetValue())
+				}
+			}
+		}
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				c.convertMetric(m, resource)
+			}
+		}
+	}
+
+	return c.result
+}
+
+// convertMetric - converts all data points of one metric
+func (c *converter) convertMetric(m *metricspb.Metric, resource map[string]string) {
+	name := m.GetName()
+
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			c.addNumber(name, resource, dp, false, false, false)
+		}
+	case *metricspb.Metric_Sum:
+		sum := data.Sum
+		cumulative := sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range sum.GetDataPoints() {
+			c.addNumber(name, resource, dp, true, sum.GetIsMonotonic(), cumulative)
+		}
+	case *metricspb.Metric_Histogram:
+		cumulative := data.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range data.Histogram.GetDataPoints() {
+			c.addHistogram(name, resource, dp, cumulative)
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		c.reject(len(data.ExponentialHistogram.GetDataPoints()), "%s: exponential histograms are not supported", name)
+	case *metricspb.Metric_Summary:
+		c.reject(len(data.Summary.GetDataPoints()), "%s: summaries are not supported", name)
+	default:
+		c.reject(1, "%s: metric has no data", name)
+	}
+}
+
+// addNumber - converts a gauge or sum data point
+func (c *converter) addNumber(name string, resource map[string]string, dp *metricspb.NumberDataPoint, sum, monotonic, cumulative bool) {
+	if noRecordedValue(dp.GetFlags()) {
+		return
+	}
+
+	metric, ok := c.newMetric(name, resource, dp.GetAttributes())
+	if !ok {
+		return
+	}
+	key := metric.SeriesKey()
+
+	var value float64
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		value = v.AsDouble
+	case *metricspb.NumberDataPoint_AsInt:
+		value = float64(v.AsInt)
+	default:
+		c.reject(1, "%s: data point has no value", name)
+		return
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		c.reject(1, "%s: value is not finite", name)
+		return
+	}
+
+	switch {
+	case !sum || (!monotonic && cumulative):
+		metric.MType = models.Gauge
+		metric.Value = &value
+	case !monotonic:
+		metric.MType = models.Gauge
+		metric.GaugeDelta = &value
+	default:
+		if value < 0 || value >= math.MaxInt64 {
+			c.reject(1, "%s: monotonic sum is out of range", name)
+			return
+		}
+		delta := int64(math.Round(value))
+		if cumulative {
+			delta = c.cumulative.counterDelta(key, dp.GetStartTimeUnixNano(), delta, c.result.undo)
+		}
+		metric.MType = models.Counter
+		metric.Delta = &delta
+	}
+
+	c.result.Metrics = append(c.result.Metrics, metric)
+}
+
+// addHistogram - converts an explicit bucket histogram data point
+func (c *converter) addHistogram(name string, resource map[string]string, dp *metricspb.HistogramDataPoint, cumulative bool) {
+	if noRecordedValue(dp.GetFlags()) {
+		return
+	}
+
+	metric, ok := c.newMetric(name, resource, dp.GetAttributes())
+	if !ok {
+		return
+	}
+
+	h := models.HistogramValue{
+		Bounds: dp.GetExplicitBounds(),
+		Counts: dp.GetBucketCounts(),
+		Sum:    dp.GetSum(),
+		Count:  dp.GetCount(),
+	}
+	if len(h.Counts) == 0 {
+		// a point without buckets has everything in the +Inf bucket
+		h.Bounds = nil
+		h.Counts = []uint64{h.Count}
+	}
+	if err := h.Validate(); err != nil {
+		c.reject(1, "%s: %v", name, err)
+		return
+	}
+
+	key := metric.SeriesKey()
+	if stored, ok := c.store.GetHistogram(c.ctx, key); ok && !equalBounds(stored.Bounds, h.Bounds) {
+		c.reject(1, "%s: %v", name, models.ErrBoundsMismatch)
+		return
+	}
+
+	if cumulative {
+		var ok bool
+		if h, ok = c.cumulative.histogramDelta(key, dp.GetStartTimeUnixNano(), h, c.result.undo); !ok {
+			return
+		}
+	}
+
+	metric.MType = models.Histogram
+	metric.Histogram = &h
+	c.result.Metrics = append(c.result.Metrics, metric)
+}
+
+// newMetric - builds a metric with the resource and data point labels
+// if the series is invalid, the data point is rejected
+func (c *converter) newMetric(name string, resource map[string]string, attrs []*commonpb.KeyValue) (models.Metrics, bool) {
+	var labels map[string]string
+	if len(resource)+len(attrs) > 0 {
+		labels = make(map[string]string, len(resource)+len(attrs))
+	}
+	for k, v := range resource {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		labels[SanitizeLabel(kv.GetKey())] = attributeValue(kv.GetValue())
+	}
+
+	if err := models.ValidateSeries(name, labels); err != nil {
+		c.reject(1, "%s: %v", name, err)
+		return models.Metrics{}, false
+	}
+
+	return models.Metrics{ID: name, Labels: labels}, true
+}
+
+// equalBounds - checks that two histograms have the same buckets
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reject - counts rejected data points and keeps the first reasons
+func (c *converter) reject(points int, format string, args ...any) {
+	if points == 0 {
+		return
+	}
+
+	c.result.Rejected += int64(points)
+	if len(c.result.Errors) < maxErrors {
+		c.result.Errors = append(c.result.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// noRecordedValue - checks the no recorded value flag of a data point
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+// attributeValue - renders an attribute value as a label value
+func attributeValue(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_BytesValue:
+		return fmt.Sprintf("%x", val.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		parts := make([]string, 0, len(val.ArrayValue.GetValues()))
+		for _, item := range val.ArrayValue.GetValues() {
+			parts = append(parts, attributeValue(item))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case *commonpb.AnyValue_KvlistValue:
+		parts := make([]string, 0, len(val.KvlistValue.GetValues()))
+		for _, kv := range val.KvlistValue.GetValues() {
+			parts = append(parts, kv.GetKey()+"="+attributeValue(kv.GetValue()))
+		}
+		sort.Strings(parts)
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+	return ""
+}
+
+// SanitizeLabel - turns an attribute key into a valid label name
+// invalid characters become "_", a leading digit gets a "_" prefix
+func SanitizeLabel(key string) string {
+	var b strings.Builder
+
+	for i, r := range key {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
@@ -0,0 +1,220 @@
+package otlp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringAttr(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+func request(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringAttr("service.name", "api"),
+				stringAttr("host.arch", "amd64"),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func sum(name string, temporality metricspb.AggregationTemporality, monotonic bool, v int64) *metricspb.Metric {
+	return sumFrom(name, temporality, monotonic, v, 0)
+}
+
+// sumFrom - sum with the start time of its stream in unix nanoseconds
+func sumFrom(name string, temporality metricspb.AggregationTemporality, monotonic bool, v int64, start uint64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            monotonic,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes:        []*commonpb.KeyValue{stringAttr("http.method", "GET")},
+				StartTimeUnixNano: start,
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: v},
+			}},
+		}},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	labels := map[string]string{"service_name": "api", "http_method": "GET"}
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	started := uint64(time.Now().Add(-time.Hour).UnixNano())
+	restarted := uint64(time.Now().Add(time.Hour).UnixNano())
+
+	tests := []struct {
+		name         string
+		previous     *colmetricspb.ExportMetricsServiceRequest
+		req          *colmetricspb.ExportMetricsServiceRequest
+		want         []models.Metrics
+		wantRejected int64
+	}{
+		{
+			name: "Delta monotonic sum",
+			req:  request(sum("requests", delta, true, 3)),
+			want: []models.Metrics{{ID: "requests", MType: models.Counter, Labels: labels, Delta: ptr(int64(3))}},
+		},
+		{
+			name:     "Cumulative monotonic sum",
+			previous: request(sumFrom("requests", cumulative, true, 10, started)),
+			req:      request(sumFrom("requests", cumulative, true, 15, started)),
+			want:     []models.Metrics{{ID: "requests", MType: models.Counter, Labels: labels, Delta: ptr(int64(5))}},
+		},
+		{
+			name:     "Cumulative sum after restart",
+			previous: request(sumFrom("requests", cumulative, true, 10, started)),
+			req:      request(sumFrom("requests", cumulative, true, 4, restarted)),
+			want:     []models.Metrics{{ID: "requests", MType: models.Counter, Labels: labels, Delta: ptr(int64(4))}},
+		},
+		{
+			name:     "Cumulative sum drop with the same start",
+			previous: request(sumFrom("requests", cumulative, true, 10, started)),
+			req:      request(sumFrom("requests", cumulative, true, 4, started)),
+			want:     []models.Metrics{{ID: "requests", MType: models.Counter, Labels: labels, Delta: ptr(int64(4))}},
+		},
+		{
+			name: "First point of a stream older than the server",
+			req:  request(sumFrom("requests", cumulative, true, 10, started)),
+			want: []models.Metrics{{ID: "requests", MType: models.Counter, Labels: labels, Delta: ptr(int64(0))}},
+		},
+		{
+			name: "First point of a stream started later",
+			req:  request(sumFrom("requests", cumulative, true, 10, restarted)),
+			want: []models.Metrics{{ID: "requests", MType: models.Counter, Labels: labels, Delta: ptr(int64(10))}},
+		},
+		{
+			name: "Delta up-down counter",
+			req:  request(sum("queue", delta, false, -2)),
+			want: []models.Metrics{{ID: "queue", MType: models.Gauge, Labels: labels, GaugeDelta: ptr(float64(-2))}},
+		},
+		{
+			name: "Cumulative up-down counter",
+			req:  request(sum("queue", cumulative, false, -2)),
+			want: []models.Metrics{{ID: "queue", MType: models.Gauge, Labels: labels, Value: ptr(float64(-2))}},
+		},
+		{
+			name: "Gauge and summary",
+			req: request(
+				&metricspb.Metric{Name: "temp", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+					DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5}}},
+				}}},
+				&metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+					DataPoints: []*metricspb.SummaryDataPoint{{}, {}},
+				}}},
+			),
+			want:         []models.Metrics{{ID: "temp", MType: models.Gauge, Labels: map[string]string{"service_name": "api"}, Value: ptr(21.5)}},
+			wantRejected: 2,
+		},
+		{
+			name: "Histogram",
+			req: request(&metricspb.Metric{Name: "duration", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: delta,
+				DataPoints: []*metricspb.HistogramDataPoint{
+					{ExplicitBounds: []float64{1, 5}, BucketCounts: []uint64{1, 2, 0}, Count: 3, Sum: ptr(7.5)},
+					{ExplicitBounds: []float64{1, 5}, BucketCounts: []uint64{1, 2, 0}, Count: 4},
+				},
+			}}}),
+			want: []models.Metrics{{ID: "duration", MType: models.Histogram, Labels: map[string]string{"service_name": "api"}, Histogram: &models.HistogramValue{
+				Bounds: []float64{1, 5}, Counts: []uint64{1, 2, 0}, Sum: 7.5, Count: 3,
+			}}},
+			wantRejected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewStorage()
+			state := NewCumulative()
+			if tt.previous != nil {
+				require.Empty(t, Convert(context.Background(), tt.previous, storage, state).Errors)
+			}
+
+			result := Convert(context.Background(), tt.req, storage, state)
+			assert.Equal(t, tt.want, result.Metrics)
+			assert.Equal(t, tt.wantRejected, result.Rejected)
+			if tt.wantRejected == 0 {
+				assert.Nil(t, result.PartialSuccess())
+			} else {
+				assert.NotEmpty(t, result.PartialSuccess().GetErrorMessage())
+			}
+		})
+	}
+}
+
+func TestConvert_Rollback(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	started := uint64(time.Now().Add(-time.Hour).UnixNano())
+	storage := repository.NewStorage()
+	state := NewCumulative()
+
+	require.Empty(t, Convert(context.Background(), request(sumFrom("requests", cumulative, true, 10, started)), storage, state).Errors)
+
+	// the write of the first retry failed, the second one gets the same delta
+	failed := Convert(context.Background(), request(sumFrom("requests", cumulative, true, 15, started)), storage, state)
+	require.Len(t, failed.Metrics, 1)
+	assert.Equal(t, int64(5), *failed.Metrics[0].Delta)
+	failed.Rollback()
+
+	result := Convert(context.Background(), request(sumFrom("requests", cumulative, true, 15, started)), storage, state)
+	require.Len(t, result.Metrics, 1)
+	assert.Equal(t, int64(5), *result.Metrics[0].Delta)
+
+	// a stream moved by a later export is not rolled back
+	late := Convert(context.Background(), request(sumFrom("requests", cumulative, true, 20, started)), storage, state)
+	Convert(context.Background(), request(sumFrom("requests", cumulative, true, 25, started)), storage, state)
+	late.Rollback()
+
+	result = Convert(context.Background(), request(sumFrom("requests", cumulative, true, 30, started)), storage, state)
+	require.Len(t, result.Metrics, 1)
+	assert.Equal(t, int64(5), *result.Metrics[0].Delta)
+}
+
+func TestCumulative_Sweep(t *testing.T) {
+	state := NewCumulative()
+	state.ttl = time.Minute
+	now := time.Now()
+	state.counters["old"] = cumulativeCounter{total: 1, seen: now.Add(-2 * time.Minute)}
+	state.counters["new"] = cumulativeCounter{total: 1, seen: now.Add(30 * time.Second)}
+	state.histograms["old"] = cumulativeHistogram{seen: now.Add(-2 * time.Minute)}
+
+	// the streams are walked once per ttl
+	state.sweep(now)
+	assert.Len(t, state.counters, 2)
+
+	state.sweep(now.Add(time.Minute))
+	assert.Equal(t, []string{"new"}, keys(state.counters))
+	assert.Empty(t, state.histograms)
+}
+
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestSanitizeLabel(t *testing.T) {
+	assert.Equal(t, "service_name", SanitizeLabel("service.name"))
+	assert.Equal(t, "_2xx", SanitizeLabel("2xx"))
+	assert.Equal(t, "_", SanitizeLabel(""))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,226 @@
+package otlp
+
+import (
+	"sync"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+)
+
+// streamTTL - time a stream without points is kept in the state
+// a stream seen again after it was evicted is taken as a first point
+const streamTTL = time.Hour
+
+// Cumulative - struct for the last points of the cumulative streams
+// kept by the server across the exports, so a cumulative point is turned into
+// the delta to the previous point of the same stream, not to the stored aggregate
+// a stream is a series with its start_time_unix_nano, a new start time or a drop
+// of the values means the producer restarted and the point is taken whole
+// the first point of a stream started before the state was created adds nothing,
+// its earlier points may be stored already, a later stream is taken whole
+// the streams without points for ttl are evicted
+type Cumulative struct {
+	mu         sync.Mutex
+	created    uint64
+	ttl        time.Duration
+	swept      time.Time
+	counters   map[string]cumulativeCounter
+	histograms map[string]cumulativeHistogram
+}
+
+// cumulativeCounter - last point of a cumulative sum stream
+type cumulativeCounter struct {
+	start uint64
+	total int64
+	seen  time.Time
+}
+
+// cumulativeHistogram - last point of a cumulative histogram stream
+type cumulativeHistogram struct {
+	start uint64
+	value models.HistogramValue
+	seen  time.Time
+}
+
+// cumulativeUndo - struct for the points a conversion replaced in the state
+// prev - point before the conversion, ok is false if the stream was new
+// set - point left by the conversion
+type cumulativeUndo struct {
+	state      *Cumulative
+	counters   map[string]counterUndo
+	histograms map[string]histogramUndo
+}
+
+// counterUndo - replaced point of a cumulative sum stream
+type counterUndo struct {
+	prev cumulativeCounter
+	ok   bool
+	set  cumulativeCounter
+}
+
+// histogramUndo - replaced point of a cumulative histogram stream
+type histogramUndo struct {
+	prev cumulativeHistogram
+	ok   bool
+	set  cumulativeHistogram
+}
+
+// NewCumulative - method for creating the state of the cumulative streams
+func NewCumulative() *Cumulative {
+	now := time.Now()
+	return &Cumulative{
+		created:    uint64(now.UnixNano()),
+		ttl:        streamTTL,
+		swept:      now,
+		counters:   make(map[string]cumulativeCounter),
+		histograms: make(map[string]cumulativeHistogram),
+	}
+}
+
+// counterDelta - method for turning a cumulative total into the delta to the previous point
+// key - series key, start - start time of the stream in unix nanoseconds
+// the replaced point is kept in undo
+func (c *Cumulative) counterDelta(key string, start uint64, total int64, undo *cumulativeUndo) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+
+	prev, ok := c.counters[key]
+	set := cumulativeCounter{start: start, total: total, seen: now}
+	c.counters[key] = set
+	undo.counter(key, prev, ok, set)
+
+	switch {
+	case !ok && !c.startedLater(start):
+		return 0
+	case !ok || prev.start != start || total < prev.total:
+		return total
+	}
+	return total - prev.total
+}
+
+// histogramDelta - method for turning a cumulative histogram into the delta to the previous point
+// a histogram with other bounds or lower counts means the producer restarted
+// the replaced point is kept in undo
+// returns false if the point adds nothing
+func (c *Cumulative) histogramDelta(key string, start uint64, h models.HistogramValue, undo *cumulativeUndo) (models.HistogramValue, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+
+	prev, ok := c.histograms[key]
+	set := cumulativeHistogram{start: start, value: h.Clone(), seen: now}
+	c.histograms[key] = set
+	undo.histogram(key, prev, ok, set)
+
+	if !ok {
+		return h, c.startedLater(start)
+	}
+	if prev.start != start || !equalBounds(prev.value.Bounds, h.Bounds) || prev.value.Count > h.Count {
+		return h, true
+	}
+
+	delta := h.Clone()
+	for i := range delta.Counts {
+		if prev.value.Counts[i] > delta.Counts[i] {
+			return h, true
+		}
+		delta.Counts[i] -= prev.value.Counts[i]
+	}
+	delta.Sum -= prev.value.Sum
+	delta.Count -= prev.value.Count
+
+	return delta, true
+}
+
+// startedLater - method for checking if a stream started after the state was created
+// a stream without a start time is taken as an older one
+func (c *Cumulative) startedLater(start uint64) bool {
+	return start != 0 && start >= c.created
+}
+
+// sweep - method for evicting the streams without points for ttl
+// the streams are walked once per ttl
+func (c *Cumulative) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.ttl {
+		return
+	}
+	c.swept = now
+
+	for key, p := range c.counters {
+		if now.Sub(p.seen) >= c.ttl {
+			delete(c.counters, key)
+		}
+	}
+	for key, p := range c.histograms {
+		if now.Sub(p.seen) >= c.ttl {
+			delete(c.histograms, key)
+		}
+	}
+}
+
+// counter - method for keeping the replaced point of a sum stream
+// only the first replaced point of a stream is kept, it is the one to restore
+func (u *cumulativeUndo) counter(key string, prev cumulativeCounter, ok bool, set cumulativeCounter) {
+	if u.counters == nil {
+		u.counters = make(map[string]counterUndo)
+	}
+	if p, seen := u.counters[key]; seen {
+		prev, ok = p.prev, p.ok
+	}
+	u.counters[key] = counterUndo{prev: prev, ok: ok, set: set}
+}
+
+// histogram - method for keeping the replaced point of a histogram stream
+// only the first replaced point of a stream is kept, it is the one to restore
+func (u *cumulativeUndo) histogram(key string, prev cumulativeHistogram, ok bool, set cumulativeHistogram) {
+	if u.histograms == nil {
+		u.histograms = make(map[string]histogramUndo)
+	}
+	if p, seen := u.histograms[key]; seen {
+		prev, ok = p.prev, p.ok
+	}
+	u.histograms[key] = histogramUndo{prev: prev, ok: ok, set: set}
+}
+
+// rollback - method for restoring the points replaced by the conversion
+// a stream moved by a later conversion is left as it is
+func (u *cumulativeUndo) rollback() {
+	if u == nil || u.state == nil {
+		return
+	}
+
+	c := u.state
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, p := range u.counters {
+		if cur, ok := c.counters[key]; !ok || cur != p.set {
+			continue
+		}
+		if p.ok {
+			c.counters[key] = p.prev
+		} else {
+			delete(c.counters, key)
+		}
+	}
+	for key, p := range u.histograms {
+		if cur, ok := c.histograms[key]; !ok || !sameHistogramPoint(cur, p.set) {
+			continue
+		}
+		if p.ok {
+			c.histograms[key] = p.prev
+		} else {
+			delete(c.histograms, key)
+		}
+	}
+}
+
+// sameHistogramPoint - checks if two points of a histogram stream were set by the same data point
+func sameHistogramPoint(a, b cumulativeHistogram) bool {
+	return a.start == b.start && a.seen.Equal(b.seen) && a.value.Count == b.value.Count && a.value.Sum == b.value.Sum
+}
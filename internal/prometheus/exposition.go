@@ -0,0 +1,185 @@
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+)
+
+// ContentType - content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// series - one stored series split into the name and labels
+type series struct {
+	labels    map[string]string
+	value     float64
+	histogram *models.HistogramValue
+}
+
+// family - all series of one metric name and type
+type family struct {
+	name   string
+	mType  string
+	series []series
+}
+
+// WriteText - writes the metrics in the Prometheus text exposition format
+// every metric name becomes a family with a TYPE line
+// names and label names are sanitized, series keys with bad labels are skipped
+// when a gauge and a counter share a name, the later one gets the type as suffix
+func WriteText(w io.Writer, gauges map[string]float64, counters map[string]int64, histograms map[string]models.HistogramValue) error {
+	families := make(map[string]*family)
+	var order []string
+
+	add := func(key string, mType string, s series) {
+		name, labels, err := models.ParseSeriesKey(key)
+		if err != nil {
+			return
+		}
+		s.labels = labels
+
+		name = SanitizeName(name)
+		f, ok := families[name]
+		if ok && f.mType != mType {
+			name = name + "_" + mType
+			f, ok = families[name]
+		}
+		if !ok {
+			f = &family{name: name, mType: mType}
+			families[name] = f
+			order = append(order, name)
+		}
+		f.series = append(f.series, s)
+	}
+
+	for _, key := range sortedKeys(gauges) {
+		add(key, models.Gauge, series{value: gauges[key]})
+	}
+	for _, key := range sortedKeys(counters) {
+		add(key, models.Counter, series{value: float64(counters[key])})
+	}
+	for _, key := range sortedKeys(histograms) {
+		h := histograms[key]
+		add(key, models.Histogram, series{histogram: &h})
+	}
+
+	sort.Strings(order)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range order {
+		writeFamily(bw, families[name])
+	}
+
+	return bw.Flush()
+}
+
+// writeFamily - writes the TYPE line and all samples of the family
+func writeFamily(w *bufio.Writer, f *family) {
+	w.WriteString("# TYPE ")
+	w.WriteString(f.name)
+	w.WriteByte(' ')
+	w.WriteString(f.mType)
+	w.WriteByte('\n')
+
+	for _, s := range f.series {
+		if s.histogram == nil {
+			writeSample(w, f.name, s.labels, "", "", s.value)
+			continue
+		}
+
+		var cumulative uint64
+		for i, c := range s.histogram.Counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(s.histogram.Bounds) {
+				le = formatValue(s.histogram.Bounds[i])
+			}
+			writeSample(w, f.name+"_bucket", s.labels, "le", le, float64(cumulative))
+		}
+		writeSample(w, f.name+"_sum", s.labels, "", "", s.histogram.Sum)
+		writeSample(w, f.name+"_count", s.labels, "", "", float64(s.histogram.Count))
+	}
+}
+
+// writeSample - writes one sample line
+// extraName and extraValue add a label after the series labels, e.g. le for buckets
+func writeSample(w *bufio.Writer, name string, labels map[string]string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		first := true
+		for _, k := range sortedKeys(labels) {
+			if !first {
+				w.WriteByte(',')
+			}
+			first = false
+			writeLabel(w, SanitizeName(k), labels[k])
+		}
+		if extraName != "" {
+			if !first {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+// writeLabel - writes name="value" with the value escaped
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelValueReplacer.Replace(value))
+	w.WriteByte('"')
+}
+
+// labelValueReplacer - escapes backslash, double quote and line feed in label values
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue - formats the sample value, +Inf, -Inf and NaN included
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// SanitizeName - makes the name a valid Prometheus metric or label name
+// every character outside [a-zA-Z0-9_:] is replaced by an underscore
+// a leading digit is prefixed with an underscore
+func SanitizeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9':
+		default:
+			b[i] = '_'
+		}
+	}
+
+	if b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// sortedKeys - returns the map keys in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,79 @@
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name       string
+		gauges     map[string]float64
+		counters   map[string]int64
+		histograms map[string]models.HistogramValue
+		want       string
+	}{
+		{
+			name: "Gauges and counters",
+			gauges: map[string]float64{
+				`CPUutilization1{host="web03"}`: 12.5,
+				`CPUutilization1{host="web04"}`: 7,
+				"Alloc":                         1024,
+			},
+			counters: map[string]int64{
+				"PollCount": 5,
+			},
+			want: "# TYPE Alloc gauge\n" +
+				"Alloc 1024\n" +
+				"# TYPE CPUutilization1 gauge\n" +
+				"CPUutilization1{host=\"web03\"} 12.5\n" +
+				"CPUutilization1{host=\"web04\"} 7\n" +
+				"# TYPE PollCount counter\n" +
+				"PollCount 5\n",
+		},
+		{
+			name: "Sanitized names and escaped values",
+			gauges: map[string]float64{
+				`http.latency-ms{path="/a\"b"}`: math.Inf(1),
+				"1st":                           math.NaN(),
+			},
+			want: "# TYPE _1st gauge\n" +
+				"_1st NaN\n" +
+				"# TYPE http_latency_ms gauge\n" +
+				"http_latency_ms{path=\"/a\\\"b\"} +Inf\n",
+		},
+		{
+			name:     "Gauge and counter with the same name",
+			gauges:   map[string]float64{"requests": 1},
+			counters: map[string]int64{"requests": 2},
+			want: "# TYPE requests gauge\n" +
+				"requests 1\n" +
+				"# TYPE requests_counter counter\n" +
+				"requests_counter 2\n",
+		},
+		{
+			name: "Histogram",
+			histograms: map[string]models.HistogramValue{
+				`latency{host="web03"}`: {Bounds: []float64{0.1, 1}, Counts: []uint64{2, 1, 1}, Sum: 3.5, Count: 4},
+			},
+			want: "# TYPE latency histogram\n" +
+				"latency_bucket{host=\"web03\",le=\"0.1\"} 2\n" +
+				"latency_bucket{host=\"web03\",le=\"1\"} 3\n" +
+				"latency_bucket{host=\"web03\",le=\"+Inf\"} 4\n" +
+				"latency_sum{host=\"web03\"} 3.5\n" +
+				"latency_count{host=\"web03\"} 4\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, WriteText(&buf, tt.gauges, tt.counters, tt.histograms))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Constants for the family types of the exposition format
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeUntyped   = "untyped"
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+)
+
+// Sample - struct for one parsed sample line
+// Line - number of the line in the payload, starting from 1
+// Name - metric name of the sample
+// Labels - labels of the sample
+// Type - type of the family from its TYPE line, untyped if there is none
+// Value - sample value
+type Sample struct {
+	Line   int
+	Name   string
+	Labels map[string]string
+	Type   string
+	Value  float64
+}
+
+// LineError - struct for an error of one payload line
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Parse - parses a payload in the Prometheus text exposition format
+// HELP lines and other comments are skipped, TYPE lines set the family type
+// lines that can't be parsed are reported as line errors and skipped
+// returns error only if the payload can't be read
+func Parse(r io.Reader) ([]Sample, []LineError, error) {
+	types := make(map[string]string)
+	samples := make([]Sample, 0)
+	lineErrs := make([]LineError, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			lineErrs = append(lineErrs, LineError{Line: n, Error: err.Error()})
+			continue
+		}
+		s.Line = n
+		s.Type = familyType(types, s.Name)
+
+		samples = append(samples, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return samples, lineErrs, nil
+}
+
+// familyType - finds the type of the family the sample belongs to
+// histogram and summary samples have suffixes, counters may end with _total
+func familyType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+
+	for _, suffix := range []string{"_total", "_bucket", "_sum", "_count"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if t, ok := types[base]; ok {
+				return t
+			}
+		}
+	}
+
+	return TypeUntyped
+}
+
+// parseSample - parses a line like name{k="v"} value [timestamp]
+func parseSample(line string) (Sample, error) {
+	var s Sample
+
+	end := strings.IndexAny(line, "{ \t")
+	if end < 0 {
+		return s, fmt.Errorf("missing value")
+	}
+	s.Name = line[:end]
+	if !isMetricName(s.Name) {
+		return s, fmt.Errorf("invalid metric name %q", s.Name)
+	}
+
+	rest := line[end:]
+	if rest[0] == '{' {
+		labels, tail, err := parseLabels(rest[1:])
+		if err != nil {
+			return s, err
+		}
+		s.Labels = labels
+		rest = tail
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value")
+	}
+	if len(fields) > 2 {
+		return s, fmt.Errorf("unexpected text after timestamp")
+	}
+
+	v, err := parseValue(fields[0])
+	if err != nil {
+		return s, fmt.Errorf("invalid value %q", fields[0])
+	}
+	s.Value = v
+
+	if len(fields) == 2 {
+		if _, err := strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return s, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+
+	return s, nil
+}
+
+// parseLabels - parses the label set after the opening brace
+// returns the labels and the rest of the line after the closing brace
+func parseLabels(in string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+
+	for {
+		in = strings.TrimLeft(in, " \t")
+		if strings.HasPrefix(in, "}") {
+			return labels, in[1:], nil
+		}
+
+		eq := strings.IndexByte(in, '=')
+		if eq < 0 {
+			return nil, "", fmt.Errorf("label without value")
+		}
+		name := strings.TrimSpace(in[:eq])
+		if !isLabelName(name) {
+			return nil, "", fmt.Errorf("invalid label name %q", name)
+		}
+
+		in = strings.TrimLeft(in[eq+1:], " \t")
+		if !strings.HasPrefix(in, `"`) {
+			return nil, "", fmt.Errorf("label %q value must be quoted", name)
+		}
+
+		value, n, err := readQuoted(in[1:])
+		if err != nil {
+			return nil, "", fmt.Errorf("label %q: %w", name, err)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, "", fmt.Errorf("duplicate label %q", name)
+		}
+		labels[name] = value
+
+		in = strings.TrimLeft(in[1+n:], " \t")
+		if strings.HasPrefix(in, ",") {
+			in = in[1:]
+			continue
+		}
+		if !strings.HasPrefix(in, "}") {
+			return nil, "", fmt.Errorf("expected comma or closing brace after label %q", name)
+		}
+	}
+}
+
+// readQuoted - reads an escaped label value up to the closing quote
+// returns the value and the number of bytes consumed, closing quote included
+func readQuoted(in string) (string, int, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(in) {
+				return "", 0, fmt.Errorf("unterminated escape")
+			}
+			i++
+			switch in[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case '\\', '"':
+				b.WriteByte(in[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", in[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated value")
+}
+
+// parseValue - parses the sample value, +Inf, -Inf and NaN included
+func parseValue(v string) (float64, error) {
+	switch v {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// isMetricName - checks the name against [a-zA-Z_:][a-zA-Z0-9_:]*
+func isMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isLabelName - checks the name against [a-zA-Z_][a-zA-Z0-9_]*
+func isLabelName(name string) bool {
+	return name != "" && !strings.Contains(name, ":") && isMetricName(name)
+}
@@ -0,0 +1,53 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	payload := `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3
+# TYPE temperature gauge
+temperature{room="kitchen, \"north\"\n"} 21.5
+free_memory 1024
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.2
+bad line here
+broken{method="post" 1
+1metric 5
+`
+
+	samples, lineErrs, err := Parse(strings.NewReader(payload))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Sample{
+		{Line: 3, Name: "http_requests_total", Labels: map[string]string{"method": "post", "code": "200"}, Type: TypeCounter, Value: 1027},
+		{Line: 4, Name: "http_requests_total", Labels: map[string]string{"method": "post", "code": "400"}, Type: TypeCounter, Value: 3},
+		{Line: 6, Name: "temperature", Labels: map[string]string{"room": "kitchen, \"north\"\n"}, Type: TypeGauge, Value: 21.5},
+		{Line: 7, Name: "free_memory", Type: TypeUntyped, Value: 1024},
+		{Line: 9, Name: "rpc_duration_seconds", Labels: map[string]string{"quantile": "0.5"}, Type: TypeSummary, Value: 0.2},
+	}, samples)
+
+	lines := make([]int, 0, len(lineErrs))
+	for _, e := range lineErrs {
+		lines = append(lines, e.Line)
+	}
+	assert.Equal(t, []int{10, 11, 12}, lines)
+}
+
+func TestParse_CounterTotalSuffix(t *testing.T) {
+	payload := "# TYPE jobs counter\njobs_total 7\n"
+
+	samples, lineErrs, err := Parse(strings.NewReader(payload))
+	require.NoError(t, err)
+	assert.Empty(t, lineErrs)
+	require.Len(t, samples, 1)
+	assert.Equal(t, TypeCounter, samples[0].Type)
+	assert.Equal(t, "jobs_total", samples[0].Name)
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+)
+
+// seriesState - struct for the stored value of a series while a batch is resolved
+// the field of the series type is set, all of them are nil if the series doesn't exist
+type seriesState struct {
+	gauge     *float64
+	counter   *int64
+	histogram *models.HistogramValue
+}
+
+// batchKeys - method for getting the distinct series of the batch
+// returns the metrics by the historyKey of their type and series key, in the batch order
+func batchKeys(metrics []models.Metrics) ([]string, map[string]models.Metrics) {
+	keys := make([]string, 0, len(metrics))
+	series := make(map[string]models.Metrics, len(metrics))
+	for _, m := range metrics {
+		key := historyKey(m.MType, m.SeriesKey())
+		if _, ok := series[key]; ok {
+			continue
+		}
+		keys = append(keys, key)
+		series[key] = m
+	}
+	return keys, series
+}
+
+// storageStates - method for reading the stored series of the batch through the repository reads
+// used by the storages that keep their series in another repository and lock the writes themselves
+func storageStates(ctx context.Context, storage Repository, metrics []models.Metrics) map[string]seriesState {
+	keys, _ := batchKeys(metrics)
+	states := make(map[string]seriesState, len(keys))
+	for _, key := range keys {
+		mType, name := splitHistoryKey(key)
+		switch mType {
+		case models.Gauge:
+			if v, ok := storage.GetGauge(ctx, name); ok {
+				states[key] = seriesState{gauge: &v}
+			}
+		case models.Counter:
+			if v, ok := storage.GetCounter(ctx, name); ok {
+				states[key] = seriesState{counter: &v}
+			}
+		case models.Histogram:
+			if v, ok := storage.GetHistogram(ctx, name); ok {
+				states[key] = seriesState{histogram: &v}
+			}
+		}
+	}
+	return states
+}
+
+// resolveBatch - method for turning a batch into the updates the storage writes
+// must be called in the transaction of the write, with the stored values read in it
+// a gauge delta becomes the value of the stored gauge moved by it
+// a counter total becomes the delta to the stored counter, a total below it is a reset
+// of the producer and is added whole
+// a single histogram observation is put into the bounds of the stored histogram,
+// or into the default bounds for a new one
+// states - stored values by historyKey, they are moved along as the batch is applied,
+// so a series repeated in the batch sees its earlier updates
+// the metrics of the caller aren't changed
+// returns the updates and the change every metric makes to its series, in the batch order
+// if a metric has no value or can't be merged, return error
+func resolveBatch(metrics []models.Metrics, states map[string]seriesState) ([]models.Metrics, []models.MetricChange, error) {
+	resolved := make([]models.Metrics, 0, len(metrics))
+	changes := make([]models.MetricChange, 0, len(metrics))
+
+	for _, m := range metrics {
+		key := historyKey(m.MType, m.SeriesKey())
+		old := states[key]
+		state := old
+
+		switch m.MType {
+		case models.Gauge:
+			switch {
+			case m.GaugeDelta != nil:
+				m.Value = ptr(valueOr(state.gauge) + *m.GaugeDelta)
+				m.GaugeDelta = nil
+			case m.Value == nil:
+				return nil, nil, models.Invalid(fmt.Errorf("gauge %s has no value", m.ID))
+			}
+			state.gauge = ptr(*m.Value)
+		case models.Counter:
+			switch {
+			case m.Total != nil:
+				m.Delta = ptr(counterDelta(valueOr(state.counter), *m.Total))
+				m.Total = nil
+			case m.Delta == nil:
+				return nil, nil, models.Invalid(fmt.Errorf("counter %s has no delta", m.ID))
+			}
+			state.counter = ptr(valueOr(state.counter) + *m.Delta)
+		case models.Histogram:
+			h, err := histogramUpdate(m, state.histogram)
+			if err != nil {
+				return nil, nil, err
+			}
+			merged := h.Clone()
+			if state.histogram != nil {
+				merged = state.histogram.Clone()
+				if err := merged.Merge(h); err != nil {
+					return nil, nil, fmt.Errorf("histogram %s: %w", m.ID, err)
+				}
+			}
+			state.histogram = &merged
+			m.Histogram = &h
+			m.Value = nil
+		}
+
+		states[key] = state
+		resolved = append(resolved, m)
+		changes = append(changes, stateChange(m, old, state))
+	}
+
+	return resolved, changes, nil
+}
+
+// stateChange - method for getting the change of a series from its states before and after a metric
+func stateChange(m models.Metrics, old, state seriesState) models.MetricChange {
+	change := models.MetricChange{ID: m.SeriesKey(), MType: m.MType}
+	switch m.MType {
+	case models.Gauge:
+		change.OldValue, change.NewValue = old.gauge, state.gauge
+	case models.Counter:
+		change.OldTotal, change.NewTotal = old.counter, state.counter
+	case models.Histogram:
+		if old.histogram != nil {
+			change.OldValue = ptr(old.histogram.Sum)
+			change.OldTotal = ptr(int64(old.histogram.Count))
+		}
+		change.NewValue = ptr(state.histogram.Sum)
+		change.NewTotal = ptr(int64(state.histogram.Count))
+	}
+	return change
+}
+
+// counterDelta - method for getting the delta that moves the stored counter to a pushed total
+// a total below the stored counter means the producer was restarted, the whole total is new
+func counterDelta(stored, total int64) int64 {
+	if total < stored {
+		return total
+	}
+	return total - stored
+}
+
+// histogramUpdate - method for getting the histogram a metric adds to the stored one
+// a metric with buckets is used as is, a single value becomes one observation
+// stored - stored histogram, nil if there is none
+// if the metric has neither or the histogram is invalid, return error
+func histogramUpdate(m models.Metrics, stored *models.HistogramValue) (models.HistogramValue, error) {
+	if m.Histogram != nil {
+		if err := m.Histogram.Validate(); err != nil {
+			return models.HistogramValue{}, fmt.Errorf("histogram %s: %w", m.ID, err)
+		}
+		return m.Histogram.Clone(), nil
+	}
+	if m.Value == nil {
+		return models.HistogramValue{}, models.Invalid(fmt.Errorf("histogram %s has no buckets", m.ID))
+	}
+
+	bounds := models.DefaultHistogramBounds
+	if stored != nil {
+		bounds = stored.Bounds
+	}
+	h := models.NewHistogram(bounds)
+	if err := h.Observe(*m.Value); err != nil {
+		return models.HistogramValue{}, fmt.Errorf("histogram %s: %w", m.ID, err)
+	}
+	return *h, nil
+}
+
+// ptr - method for getting a pointer to a copy of the value
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// valueOr - method for getting the value of a pointer, zero if it is nil
+func valueOr[T int64 | float64](v *T) T {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
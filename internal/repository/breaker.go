@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+)
+
+// BreakerStorage - struct for the repository guarded by a circuit breaker
+// while the breaker is open the calls fail fast with *breaker.OpenError
+// Ping always reaches the storage, so a health check can close the breaker
+type BreakerStorage struct {
+	storage Repository
+	breaker *breaker.Breaker
+}
+
+// Breaker - method for getting the circuit breaker of the storage
+func (s *BreakerStorage) Breaker() *breaker.Breaker {
+	return s.breaker
+}
+
+// SetGauge - method for setting a gauge
+// if the breaker is open, return error
+func (s *BreakerStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	return s.call(ctx, func() error {
+		return s.storage.SetGauge(ctx, name, value)
+	})
+}
+
+// SetCounter - method for setting a counter
+// if the breaker is open, return error
+func (s *BreakerStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	return s.call(ctx, func() error {
+		return s.storage.SetCounter(ctx, name, value)
+	})
+}
+
+// GetGauge - method for getting a gauge
+// if the breaker is open, return false
+func (s *BreakerStorage) GetGauge(ctx context.Context, name string) (float64, bool) {
+	if s.breaker.State() == breaker.Open {
+		return 0, false
+	}
+	return s.storage.GetGauge(ctx, name)
+}
+
+// GetCounter - method for getting a counter
+// if the breaker is open, return false
+func (s *BreakerStorage) GetCounter(ctx context.Context, name string) (int64, bool) {
+	if s.breaker.State() == breaker.Open {
+		return 0, false
+	}
+	return s.storage.GetCounter(ctx, name)
+}
+
+// GetAllGauges - method for getting all gauges
+// if the breaker is open, return error
+func (s *BreakerStorage) GetAllGauges(ctx context.Context) (map[string]float64, error) {
+	var result map[string]float64
+	err := s.call(ctx, func() error {
+		var err error
+		result, err = s.storage.GetAllGauges(ctx)
+		return err
+	})
+	return result, err
+}
+
+// GetAllCounters - method for getting all counters
+// if the breaker is open, return error
+func (s *BreakerStorage) GetAllCounters(ctx context.Context) (map[string]int64, error) {
+	var result map[string]int64
+	err := s.call(ctx, func() error {
+		var err error
+		result, err = s.storage.GetAllCounters(ctx)
+		return err
+	})
+	return result, err
+}
+
+// SetHistogram - method for merging a histogram into the stored one
+// if the breaker is open, return error
+func (s *BreakerStorage) SetHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	return s.call(ctx, func() error {
+		return s.storage.SetHistogram(ctx, name, value)
+	})
+}
+
+// GetHistogram - method for getting a histogram
+// if the breaker is open, return false
+func (s *BreakerStorage) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	if s.breaker.State() == breaker.Open {
+		return models.HistogramValue{}, false
+	}
+	return s.storage.GetHistogram(ctx, name)
+}
+
+// GetAllHistograms - method for getting all histograms
+// if the breaker is open, return error
+func (s *BreakerStorage) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	var result map[string]models.HistogramValue
+	err := s.call(ctx, func() error {
+		var err error
+		result, err = s.storage.GetAllHistograms(ctx)
+		return err
+	})
+	return result, err
+}
+
+// SetMetricBatch - method for setting a batch of metrics
+// if the breaker is open, return error
+func (s *BreakerStorage) SetMetricBatch(ctx context.Context, metrics []models.Metrics) error {
+	return s.call(ctx, func() error {
+		return s.storage.SetMetricBatch(ctx, metrics)
+	})
+}
+
+// SetMetricBatchChanges - method for setting a batch of metrics and getting its changes
+// if the storage doesn't return the changes, the batch is set and no changes are returned
+// if the breaker is open, return error
+func (s *BreakerStorage) SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error) {
+	writer, ok := s.storage.(ChangeWriter)
+	if !ok {
+		return nil, s.SetMetricBatch(ctx, metrics)
+	}
+
+	var changes []models.MetricChange
+	err := s.call(ctx, func() error {
+		var err error
+		changes, err = writer.SetMetricBatchChanges(ctx, metrics)
+		return err
+	})
+	return changes, err
+}
+
+// GetTimes - method for getting the write times of a series
+// if the breaker is open or the storage doesn't track the times, return false
+func (s *BreakerStorage) GetTimes(ctx context.Context, mType string, name string) (MetricTimes, bool) {
+	reader, ok := s.storage.(TimesReader)
+	if !ok || s.breaker.State() == breaker.Open {
+		return MetricTimes{}, false
+	}
+	return reader.GetTimes(ctx, mType, name)
+}
+
+// GetHistory - method for getting the samples of a series
+// if the breaker is open, return error
+func (s *BreakerStorage) GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error) {
+	var result []models.Sample
+	err := s.call(ctx, func() error {
+		var err error
+		result, err = s.storage.GetHistory(ctx, mType, name, from, to)
+		return err
+	})
+	return result, err
+}
+
+// ApplyRetention - method for rolling up and deleting old history samples
+// if the breaker is open, return error
+func (s *BreakerStorage) ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error {
+	return s.call(ctx, func() error {
+		return s.storage.ApplyRetention(ctx, rules, now)
+	})
+}
+
+// Ping - method for pinging the database
+// the ping is never rejected, its outcome is reported to the breaker
+func (s *BreakerStorage) Ping(ctx context.Context) error {
+	err := s.storage.Ping(ctx)
+	s.report(ctx, err)
+	return err
+}
+
+// call - method for running fn through the breaker
+// if the breaker is open, return *breaker.OpenError without calling fn
+func (s *BreakerStorage) call(ctx context.Context, fn func() error) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	s.report(ctx, err)
+	return err
+}
+
+// report - method for reporting the outcome of a call to the breaker
+// only errors meaning the database is unreachable count as failures
+// calls aborted by the caller are not counted
+func (s *BreakerStorage) report(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		s.breaker.Success()
+	case ctx.Err() != nil:
+		s.breaker.Release()
+	case IsUnavailable(err):
+		s.breaker.Failure()
+	default:
+		s.breaker.Success()
+	}
+}
+
+// IsUnavailable - checks if an error means the database can't be reached
+// network errors, connection errors, timeouts and shutdowns of the server count
+func IsUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	var connErr *pgconn.ConnectError
+	if errors.As(err, &connErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgerrcode.IsOperatorIntervention(pgErr.Code) ||
+			pgErr.Code == pgerrcode.TooManyConnections
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStorage - memory storage that fails the writes while down is set
+type flakyStorage struct {
+	Repository
+	down  bool
+	calls int
+}
+
+func (f *flakyStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	f.calls++
+	if f.down {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return f.Repository.SetGauge(ctx, name, value)
+}
+
+func (f *flakyStorage) Ping(ctx context.Context) error {
+	if f.down {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return nil
+}
+
+func TestBreakerStorage(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyStorage{Repository: NewStorage(), down: true}
+	storage := NewBreakerStorage(flaky, breaker.New(2, time.Hour))
+
+	for i := 0; i < 2; i++ {
+		assert.Error(t, storage.SetGauge(ctx, "load", 1))
+	}
+	assert.Equal(t, breaker.Open, storage.Breaker().State())
+
+	err := storage.SetGauge(ctx, "load", 1)
+	require.ErrorIs(t, err, breaker.ErrOpen)
+	assert.Equal(t, 2, flaky.calls, "open breaker must not reach the storage")
+
+	_, ok := storage.GetGauge(ctx, "load")
+	assert.False(t, ok)
+
+	flaky.down = false
+	require.NoError(t, storage.Ping(ctx))
+	assert.Equal(t, breaker.Closed, storage.Breaker().State())
+
+	require.NoError(t, storage.SetGauge(ctx, "load", 1))
+	value, ok := storage.GetGauge(ctx, "load")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, value)
+}
+
+func TestBreakerStorage_IgnoresClientErrors(t *testing.T) {
+	ctx := context.Background()
+	storage := NewBreakerStorage(NewStorage(), breaker.New(1, time.Hour))
+
+	err := storage.SetMetricBatch(ctx, []models.Metrics{{ID: "load", MType: "gauge"}})
+	require.Error(t, err)
+	assert.Equal(t, breaker.Closed, storage.Breaker().State())
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	storage.report(canceled, context.Canceled)
+	assert.Equal(t, breaker.Closed, storage.Breaker().State())
+}
+
+func TestIsUnavailable(t *testing.T) {
+	assert.True(t, IsUnavailable(context.DeadlineExceeded))
+	assert.True(t, IsUnavailable(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	assert.False(t, IsUnavailable(errors.New("histogram bounds mismatch")))
+	assert.False(t, IsUnavailable(context.Canceled))
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -20,53 +21,149 @@ type DBStorage struct {
 // Constants for the database storage
 const (
 	insertGaugeQuery = `
-		INSERT INTO metrics (name, metric_type, gauge_value)
-		VALUES ($1, 'gauge', $2)
-		ON CONFLICT (name, metric_type) 
-		DO UPDATE 
-		SET gauge_value = EXCLUDED.gauge_value, counter_value = NULL
+		WITH upsert AS (
+			INSERT INTO metrics (name, labels, metric_type, gauge_value)
+			VALUES ($1, $2, 'gauge', $3)
+			ON CONFLICT (name, metric_type, labels) 
+			DO UPDATE 
+			SET gauge_value = EXCLUDED.gauge_value, counter_value = NULL,
+			    timestamp = CURRENT_TIMESTAMP
+			RETURNING gauge_value
+		)
+		INSERT INTO metrics_history (name, labels, metric_type, value, min_value, max_value, sum_value)
+		SELECT $1, $2, 'gauge', gauge_value, gauge_value, gauge_value, gauge_value FROM upsert
 	`
 
 	getGaugeQuery = `
 		SELECT gauge_value FROM metrics
-		WHERE name = $1 AND metric_type = 'gauge'
+		WHERE name = $1 AND labels = $2 AND metric_type = 'gauge'
 	`
 
 	getAllGaugesQuery = `
-		SELECT name, gauge_value FROM metrics 
+		SELECT name, labels, gauge_value FROM metrics 
 		WHERE metric_type = 'gauge'
 	`
 
 	insertCounterQuery = `
-		INSERT INTO metrics (name, metric_type, counter_value)
-		VALUES ($1, 'counter', $2)
-		ON CONFLICT (name, metric_type) 
-		DO UPDATE 
-		SET counter_value = metrics.counter_value + EXCLUDED.counter_value,
-		    gauge_value = NULL
+		WITH upsert AS (
+			INSERT INTO metrics (name, labels, metric_type, counter_value)
+			VALUES ($1, $2, 'counter', $3)
+			ON CONFLICT (name, metric_type, labels) 
+			DO UPDATE 
+			SET counter_value = metrics.counter_value + EXCLUDED.counter_value,
+			    gauge_value = NULL, timestamp = CURRENT_TIMESTAMP
+			RETURNING counter_value
+		)
+		INSERT INTO metrics_history (name, labels, metric_type, value, min_value, max_value, sum_value)
+		SELECT $1, $2, 'counter', counter_value, $3, $3, $3 FROM upsert
 	`
 
 	getCounterQuery = `
 		SELECT counter_value FROM metrics
-		WHERE name = $1 AND metric_type = 'counter'
+		WHERE name = $1 AND labels = $2 AND metric_type = 'counter'
 	`
 
 	getAllCountersQuery = `
-		SELECT name, counter_value FROM metrics 
+		SELECT name, labels, counter_value FROM metrics 
 		WHERE metric_type = 'counter'
 	`
+
+	insertHistogramQuery = `
+		INSERT INTO metrics (name, labels, metric_type, histogram_value)
+		VALUES ($1, $2, 'histogram', $3)
+		ON CONFLICT (name, metric_type, labels) DO NOTHING
+	`
+
+	lockHistogramQuery = `
+		SELECT histogram_value FROM metrics
+		WHERE name = $1 AND labels = $2 AND metric_type = 'histogram'
+		FOR UPDATE
+	`
+
+	updateHistogramQuery = `
+		UPDATE metrics SET histogram_value = $3, timestamp = CURRENT_TIMESTAMP
+		WHERE name = $1 AND labels = $2 AND metric_type = 'histogram'
+	`
+
+	getHistogramQuery = `
+		SELECT histogram_value FROM metrics
+		WHERE name = $1 AND labels = $2 AND metric_type = 'histogram'
+	`
+
+	getAllHistogramsQuery = `
+		SELECT name, labels, histogram_value FROM metrics
+		WHERE metric_type = 'histogram'
+	`
+
+	insertTotalCountersQuery = `
+		INSERT INTO metrics (name, labels, metric_type, counter_value)
+		SELECT name, labels::jsonb, 'counter', 0
+		FROM unnest($1::varchar[], $2::text[]) AS k(name, labels)
+		ON CONFLICT (name, metric_type, labels) DO NOTHING
+	`
+
+	lockSeriesQuery = `
+		SELECT m.name, m.labels, m.metric_type, m.gauge_value, m.counter_value, m.histogram_value
+		FROM metrics m
+		JOIN unnest($1::varchar[], $2::text[], $3::varchar[]) AS k(name, labels, metric_type)
+			ON m.name = k.name AND m.labels = k.labels::jsonb AND m.metric_type = k.metric_type
+		ORDER BY m.id
+		FOR UPDATE OF m
+	`
+
+	getTimesQuery = `
+		SELECT created_at, timestamp FROM metrics
+		WHERE name = $1 AND labels = $2 AND metric_type = $3
+	`
+
+	getHistoryNamesQuery = `
+		SELECT DISTINCT name FROM metrics_history
+	`
+
+	rollupHistoryQuery = `
+		INSERT INTO metrics_history
+			(name, labels, metric_type, ts, value, min_value, max_value, sum_value, sample_count, resolution_seconds)
+		SELECT name, labels, metric_type,
+			to_timestamp(floor(extract(epoch FROM ts) / $2::bigint) * $2::bigint) AS bucket,
+			(array_agg(value ORDER BY ts DESC))[1],
+			min(min_value), max(max_value), sum(sum_value), sum(sample_count), $2::bigint
+		FROM metrics_history
+		WHERE name = $1 AND resolution_seconds = 0 AND ts < $3
+		GROUP BY name, labels, metric_type, bucket
+	`
+
+	deleteRawHistoryQuery = `
+		DELETE FROM metrics_history
+		WHERE name = $1 AND resolution_seconds = 0 AND ts < $2
+	`
+
+	deleteRollupHistoryQuery = `
+		DELETE FROM metrics_history
+		WHERE name = $1 AND resolution_seconds > 0 AND ts < $2
+	`
+
+	getHistoryQuery = `
+		SELECT ts, value, min_value, max_value, sum_value, sample_count FROM metrics_history
+		WHERE name = $1 AND labels = $2 AND metric_type = $3 AND ts BETWEEN $4 AND $5
+		ORDER BY ts
+	`
 )
 
 // SetGauge - method for setting a gauge
 // set the value of the gauge
 // if error, return error
 // if success, return nil
-func (d *DBStorage) SetGauge(name string, value float64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (d *DBStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	_, err := d.db.ExecContext(ctx, insertGaugeQuery,
-		name, value)
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, insertGaugeQuery,
+		id, labels, value)
 	if err != nil {
 		return fmt.Errorf("failed to set gauge %q: %w", name, err)
 	}
@@ -84,9 +181,10 @@ func (d *DBStorage) GetGauge(ctx context.Context, name string) (float64, bool) {
 
 	var value float64
 
-	gauge := d.db.QueryRowContext(ctx, getGaugeQuery, name)
-
-	err := gauge.Scan(&value)
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, getGaugeQuery, id, labels).Scan(&value)
+	}
 	if err != nil {
 		d.logger.Info("failed to get metric",
 			zap.String("name", name),
@@ -102,8 +200,8 @@ func (d *DBStorage) GetGauge(ctx context.Context, name string) (float64, bool) {
 // get all the gauges
 // if error, return error
 // if success, return the value of the gauges
-func (d *DBStorage) GetAllGauges() (map[string]float64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (d *DBStorage) GetAllGauges(ctx context.Context) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
 	rows, err := d.db.QueryContext(ctx, getAllGaugesQuery)
@@ -116,12 +214,17 @@ func (d *DBStorage) GetAllGauges() (map[string]float64, error) {
 
 	for rows.Next() {
 		var name string
+		var labels []byte
 		var value float64
-		err := rows.Scan(&name, &value)
+		err := rows.Scan(&name, &labels, &value)
 		if err != nil {
 			return nil, err
 		}
-		result[name] = value
+		key, err := joinSeries(name, labels)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
 	}
 
 	if err := rows.Err(); err != nil {
@@ -135,11 +238,16 @@ func (d *DBStorage) GetAllGauges() (map[string]float64, error) {
 // set the value of the counter
 // if error, return error
 // if success, return nil
-func (d *DBStorage) SetCounter(name string, value int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (d *DBStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	_, err := d.db.ExecContext(ctx, insertCounterQuery, name, value)
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, insertCounterQuery, id, labels, value)
 	if err != nil {
 		return fmt.Errorf("failed to set counter %q: %w", name, err)
 	}
@@ -151,15 +259,16 @@ func (d *DBStorage) SetCounter(name string, value int64) error {
 // get the value of the counter
 // if error, return false
 // if success, return the value of the counter and true
-func (d *DBStorage) GetCounter(name string) (int64, bool) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (d *DBStorage) GetCounter(ctx context.Context, name string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
 	var value int64
 
-	counter := d.db.QueryRowContext(ctx, getCounterQuery, name)
-
-	err := counter.Scan(&value)
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, getCounterQuery, id, labels).Scan(&value)
+	}
 	if err != nil {
 		d.logger.Info("failed to get metric",
 			zap.String("name", name),
@@ -175,8 +284,8 @@ func (d *DBStorage) GetCounter(name string) (int64, bool) {
 // get all the counters
 // if error, return error
 // if success, return the value of the counters
-func (d *DBStorage) GetAllCounters() (map[string]int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (d *DBStorage) GetAllCounters(ctx context.Context) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
 	rows, err := d.db.QueryContext(ctx, getAllCountersQuery)
@@ -189,12 +298,17 @@ func (d *DBStorage) GetAllCounters() (map[string]int64, error) {
 
 	for rows.Next() {
 		var name string
+		var labels []byte
 		var value int64
-		err := rows.Scan(&name, &value)
+		err := rows.Scan(&name, &labels, &value)
+		if err != nil {
+			return nil, err
+		}
+		key, err := joinSeries(name, labels)
 		if err != nil {
 			return nil, err
 		}
-		result[name] = value
+		result[key] = value
 	}
 
 	if err := rows.Err(); err != nil {
@@ -204,57 +318,385 @@ func (d *DBStorage) GetAllCounters() (map[string]int64, error) {
 	return result, nil
 }
 
-// SetMetricBatch - method for setting a batch of metrics
-// set the value of the metrics
+// SetHistogram - method for setting a histogram
+// merge the histogram into the stored one inside a transaction
 // if error, return error
 // if success, return nil
-func (d *DBStorage) SetMetricBatch(metrics []models.Metrics) error {
-	tx, err := d.db.Begin()
+func (d *DBStorage) SetHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := mergeHistogram(ctx, tx, name, value); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mergeHistogram - method for merging a histogram within a transaction
+// insert the histogram if it doesn't exist yet
+// otherwise lock the row, merge the buckets and write it back
+func mergeHistogram(ctx context.Context, tx *sql.Tx, name string, value models.HistogramValue) error {
+	if err := value.Validate(); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, insertHistogramQuery, id, labels, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to insert histogram %q: %w", name, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return nil
+	}
+
+	var raw []byte
+	if err := tx.QueryRowContext(ctx, lockHistogramQuery, id, labels).Scan(&raw); err != nil {
+		return fmt.Errorf("failed to lock histogram %q: %w", name, err)
+	}
+
+	var current models.HistogramValue
+	if err := json.Unmarshal(raw, &current); err != nil {
+		return fmt.Errorf("failed to decode histogram %q: %w", name, err)
+	}
+	if err := current.Merge(value); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	data, err = json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, updateHistogramQuery, id, labels, string(data)); err != nil {
+		return fmt.Errorf("failed to update histogram %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// GetHistogram - method for getting a histogram
+// get the histogram
+// if error, return false
+// if success, return the histogram and true
+func (d *DBStorage) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var raw []byte
+	var value models.HistogramValue
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, getHistogramQuery, id, labels).Scan(&raw)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return models.HistogramValue{}, false
+	}
+
+	return value, true
+}
+
+// GetAllHistograms - method for getting all histograms
+// get all the histograms
+// if error, return error
+// if success, return the histograms
+func (d *DBStorage) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
+	rows, err := d.db.QueryContext(ctx, getAllHistogramsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]models.HistogramValue)
+
+	for rows.Next() {
+		var name string
+		var labels, raw []byte
+		if err := rows.Scan(&name, &labels, &raw); err != nil {
+			return nil, err
+		}
+
+		key, err := joinSeries(name, labels)
+		if err != nil {
+			return nil, err
+		}
+
+		var value models.HistogramValue
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("failed to decode histogram %q: %w", key, err)
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetMetricBatch - method for setting a batch of metrics
+// the stored series of the batch are locked and read with one query,
+// the batch is resolved against them and written in the same transaction
+// if error, return error
+// if success, return nil
+func (d *DBStorage) SetMetricBatch(ctx context.Context, metrics []models.Metrics) error {
+	_, err := d.SetMetricBatchChanges(ctx, metrics)
+	return err
+}
+
+// SetMetricBatchChanges - method for setting a batch of metrics
+// returns the changes of the metrics, computed from the rows locked by the write
+// if error, return error
+func (d *DBStorage) SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names, labels, types, err := seriesArrays(metrics)
+	if err != nil {
+		return nil, err
+	}
+	if totalNames, totalLabels, err := totalArrays(metrics); err != nil {
+		return nil, err
+	} else if len(totalNames) > 0 {
+		if _, err := tx.ExecContext(ctx, insertTotalCountersQuery, totalNames, totalLabels); err != nil {
+			return nil, fmt.Errorf("failed to insert counters: %w", err)
+		}
+	}
+	rows, err := tx.QueryContext(ctx, lockSeriesQuery, names, labels, types)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock metrics: %w", err)
+	}
+	states, err := scanStates(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, changes, err := resolveBatch(metrics, states)
+	if err != nil {
+		return nil, err
+	}
+
 	stmtGauge, err := tx.PrepareContext(ctx, insertGaugeQuery)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stmtGauge.Close()
 
 	stmtCounter, err := tx.PrepareContext(ctx, insertCounterQuery)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stmtCounter.Close()
 
 	for _, m := range metrics {
+		labels, err := labelsJSON(m.Labels)
+		if err != nil {
+			return nil, err
+		}
+
 		switch m.MType {
 		case "gauge":
-			if m.Value == nil {
-				return fmt.Errorf("gauge %s has no value", m.ID)
-			}
-			if _, err := stmtGauge.ExecContext(ctx, m.ID, m.Value); err != nil {
-				return fmt.Errorf("failed to insert gauge %s: %w", m.ID, err)
+			if _, err := stmtGauge.ExecContext(ctx, m.ID, labels, m.Value); err != nil {
+				return nil, fmt.Errorf("failed to insert gauge %s: %w", m.ID, err)
 			}
 		case "counter":
-			if m.Delta == nil {
-				return fmt.Errorf("counter %s has no delta", m.ID)
+			if _, err := stmtCounter.ExecContext(ctx, m.ID, labels, m.Delta); err != nil {
+				return nil, fmt.Errorf("failed to insert counter %s: %w", m.ID, err)
 			}
-			if _, err := stmtCounter.ExecContext(ctx, m.ID, m.Delta); err != nil {
-				return fmt.Errorf("failed to insert counter %s: %w", m.ID, err)
+		case "histogram":
+			if err := mergeHistogram(ctx, tx, m.SeriesKey(), *m.Histogram); err != nil {
+				return nil, err
 			}
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetTimes - method for getting the write times of a series
+// if error, return false
+// if success, return the times and true
+func (d *DBStorage) GetTimes(ctx context.Context, mType string, name string) (MetricTimes, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var times MetricTimes
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, getTimesQuery, id, labels, mType).Scan(&times.CreatedAt, &times.LastUpdated)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric times",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return MetricTimes{}, false
+	}
+
+	return times, true
+}
+
+// GetHistory - method for getting the history of a series
+// get the samples written between from and to
+// if error, return error
+// if success, return the samples in time order
+func (d *DBStorage) GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, getHistoryQuery, id, labels, mType, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Sample, 0)
+
+	for rows.Next() {
+		var s models.Sample
+		if err := rows.Scan(&s.Timestamp, &s.Value, &s.Min, &s.Max, &s.Sum, &s.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ApplyRetention - method for enforcing the retention rules
+// raw samples older than the rule allows are rolled up or deleted
+// rollups older than the rule allows are deleted
+// every metric name is compacted in its own transaction
+// if error, return error
+// if success, return nil
+func (d *DBStorage) ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error {
+	names, err := d.historyNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		rule, ok := matchRule(rules, name)
+		if !ok {
+			continue
+		}
+
+		if err := d.compactHistory(ctx, name, rule, now); err != nil {
+			return fmt.Errorf("failed to compact history of %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// historyNames - method for getting the metric names that have history
+func (d *DBStorage) historyNames(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, getHistoryNamesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// compactHistory - method for applying a retention rule to one metric name
+func (d *DBStorage) compactHistory(ctx context.Context, name string, rule RetentionRule, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rawCutoff := rule.rawCutoff(now)
+
+	if rule.RollupStep > 0 {
+		step := int64(time.Duration(rule.RollupStep) / time.Second)
+		if _, err := tx.ExecContext(ctx, rollupHistoryQuery, name, step, rawCutoff); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteRawHistoryQuery, name, rawCutoff); err != nil {
+		return err
+	}
+
+	rollupCutoff := now
+	if rule.RollupStep > 0 {
+		rollupCutoff = rule.rollupCutoff(now)
+	}
+	if _, err := tx.ExecContext(ctx, deleteRollupHistoryQuery, name, rollupCutoff); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
 // Ping - method for pinging the database
-func (d *DBStorage) Ping() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (d *DBStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
 	err := d.db.PingContext(ctx)
@@ -264,3 +706,123 @@ func (d *DBStorage) Ping() error {
 
 	return nil
 }
+
+// seriesArrays - method for getting the names, labels and types of the distinct series of the batch
+// the arrays are the parameters of lockSeriesQuery
+// if error, return error
+func seriesArrays(metrics []models.Metrics) ([]string, []string, []string, error) {
+	keys, series := batchKeys(metrics)
+	names := make([]string, 0, len(keys))
+	labels := make([]string, 0, len(keys))
+	types := make([]string, 0, len(keys))
+	for _, key := range keys {
+		m := series[key]
+		data, err := labelsJSON(m.Labels)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		names = append(names, m.ID)
+		labels = append(labels, data)
+		types = append(types, m.MType)
+	}
+	return names, labels, types, nil
+}
+
+// totalArrays - method for getting the names and labels of the counters pushed as totals
+// the counters are created with zero before the series are locked, so two batches
+// creating the same counter can't both take it as new
+// if error, return error
+func totalArrays(metrics []models.Metrics) ([]string, []string, error) {
+	var names, labels []string
+	for _, m := range metrics {
+		if m.MType != models.Counter || m.Total == nil {
+			continue
+		}
+		data, err := labelsJSON(m.Labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, m.ID)
+		labels = append(labels, data)
+	}
+	return names, labels, nil
+}
+
+// stateRows - interface for the rows of lockSeriesQuery, implemented by sql.Rows and pgx.Rows
+type stateRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// scanStates - method for reading the rows of lockSeriesQuery into the series states by historyKey
+// if error, return error
+func scanStates(rows stateRows) (map[string]seriesState, error) {
+	states := make(map[string]seriesState)
+	for rows.Next() {
+		var name, mType string
+		var labels, raw []byte
+		var state seriesState
+		if err := rows.Scan(&name, &labels, &mType, &state.gauge, &state.counter, &raw); err != nil {
+			return nil, err
+		}
+
+		key, err := joinSeries(name, labels)
+		if err != nil {
+			return nil, err
+		}
+		if raw != nil {
+			state.histogram = &models.HistogramValue{}
+			if err := json.Unmarshal(raw, state.histogram); err != nil {
+				return nil, fmt.Errorf("failed to decode histogram %q: %w", key, err)
+			}
+		}
+		states[historyKey(mType, key)] = state
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// splitSeries - splits the series key into the metric name and labels JSON
+// the labels are stored in the jsonb column and compared as a whole
+func splitSeries(key string) (string, string, error) {
+	name, labels, err := models.ParseSeriesKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := labelsJSON(labels)
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, data, nil
+}
+
+// labelsJSON - encodes the labels for the jsonb column
+// nil labels are stored as an empty object
+func labelsJSON(labels map[string]string) (string, error) {
+	if labels == nil {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode labels: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// joinSeries - builds the series key from the name and labels columns
+func joinSeries(name string, raw []byte) (string, error) {
+	var labels map[string]string
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		return "", fmt.Errorf("failed to decode labels of %q: %w", name, err)
+	}
+
+	return models.SeriesKey(name, labels), nil
+}
@@ -0,0 +1,715 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"go.uber.org/zap"
+)
+
+// PgxConfig - struct for the pgx pool settings
+// MaxConns, MinConns - size limits of the pool, zero keeps the pgx default
+// MaxConnLifetime, MaxConnIdleTime - when a connection is closed, zero keeps the pgx default
+// ConnectTimeout - timeout of establishing a connection
+// QueryTimeout - timeout of a single metric write or read
+// BatchTimeout - timeout of a batch write and of the retention of a metric
+type PgxConfig struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	ConnectTimeout  time.Duration
+	QueryTimeout    time.Duration
+	BatchTimeout    time.Duration
+}
+
+// PgxStorage - struct for the database storage on a native pgx pool
+// uses the schema and the single metric queries of DBStorage,
+// a batch is written with one multi-row upsert per metric type, so a series gets one history sample per batch
+// generate:reset
+type PgxStorage struct {
+	pool   *pgxpool.Pool
+	cfg    PgxConfig
+	logger *zap.Logger
+}
+
+// Constants for the pgx storage
+// the batch rows are passed as arrays and unnested, so a batch of any size is one statement
+const (
+	batchGaugesQuery = `
+		WITH input AS (
+			SELECT name, labels::jsonb AS labels, value
+			FROM unnest($1::varchar[], $2::text[], $3::double precision[]) AS t(name, labels, value)
+		), upsert AS (
+			INSERT INTO metrics (name, labels, metric_type, gauge_value)
+			SELECT name, labels, 'gauge', value FROM input
+			ON CONFLICT (name, metric_type, labels)
+			DO UPDATE
+			SET gauge_value = EXCLUDED.gauge_value, counter_value = NULL,
+			    timestamp = CURRENT_TIMESTAMP
+			RETURNING name, labels, gauge_value
+		)
+		INSERT INTO metrics_history (name, labels, metric_type, value, min_value, max_value, sum_value)
+		SELECT name, labels, 'gauge', gauge_value, gauge_value, gauge_value, gauge_value FROM upsert
+	`
+
+	batchCountersQuery = `
+		WITH input AS (
+			SELECT name, labels::jsonb AS labels, delta
+			FROM unnest($1::varchar[], $2::text[], $3::bigint[]) AS t(name, labels, delta)
+		), upsert AS (
+			INSERT INTO metrics (name, labels, metric_type, counter_value)
+			SELECT name, labels, 'counter', delta FROM input
+			ON CONFLICT (name, metric_type, labels)
+			DO UPDATE
+			SET counter_value = metrics.counter_value + EXCLUDED.counter_value,
+			    gauge_value = NULL, timestamp = CURRENT_TIMESTAMP
+			RETURNING name, labels, counter_value
+		)
+		INSERT INTO metrics_history (name, labels, metric_type, value, min_value, max_value, sum_value)
+		SELECT u.name, u.labels, 'counter', u.counter_value, i.delta, i.delta, i.delta
+		FROM upsert u JOIN input i ON i.name = u.name AND i.labels = u.labels
+	`
+)
+
+// NewPgxPool - method for connecting the pgx pool of the dsn with the settings
+// if error, return error
+func NewPgxPool(ctx context.Context, dsn string, cfg PgxConfig) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database dsn: %w", err)
+	}
+
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolCfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.ConnectTimeout > 0 {
+		poolCfg.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database pool: %w", err)
+	}
+
+	return pool, nil
+}
+
+// pgxBatch - struct for a batch aggregated into the rows of the multi-row upserts
+// the rows are sorted by the series key, so concurrent batches lock the rows in the same order
+type pgxBatch struct {
+	gaugeNames    []string
+	gaugeLabels   []string
+	gaugeValues   []float64
+	counterNames  []string
+	counterLabels []string
+	counterDeltas []int64
+	histograms    []string
+	histogramVals []models.HistogramValue
+}
+
+// aggregateBatch - method for turning a batch into one row per series
+// the last value of a gauge wins, the deltas of a counter are summed and the histograms are merged,
+// as if the metrics were written one by one
+// if error, return error
+func aggregateBatch(metrics []models.Metrics) (pgxBatch, error) {
+	gauges := make(map[string]float64)
+	counters := make(map[string]int64)
+	histograms := make(map[string]models.HistogramValue)
+
+	for _, m := range metrics {
+		key := m.SeriesKey()
+
+		switch m.MType {
+		case models.Gauge:
+			if m.Value == nil {
+				return pgxBatch{}, fmt.Errorf("gauge %s has no value", m.ID)
+			}
+			gauges[key] = *m.Value
+		case models.Counter:
+			if m.Delta == nil {
+				return pgxBatch{}, fmt.Errorf("counter %s has no delta", m.ID)
+			}
+			counters[key] += *m.Delta
+		case models.Histogram:
+			if m.Histogram == nil {
+				return pgxBatch{}, fmt.Errorf("histogram %s has no buckets", m.ID)
+			}
+			if err := m.Histogram.Validate(); err != nil {
+				return pgxBatch{}, fmt.Errorf("histogram %s: %w", m.ID, err)
+			}
+			current, ok := histograms[key]
+			if !ok {
+				histograms[key] = m.Histogram.Clone()
+				continue
+			}
+			if err := current.Merge(*m.Histogram); err != nil {
+				return pgxBatch{}, fmt.Errorf("histogram %s: %w", m.ID, err)
+			}
+			histograms[key] = current
+		}
+	}
+
+	var batch pgxBatch
+	for _, key := range sortedKeys(gauges) {
+		id, labels, err := splitSeries(key)
+		if err != nil {
+			return pgxBatch{}, err
+		}
+		batch.gaugeNames = append(batch.gaugeNames, id)
+		batch.gaugeLabels = append(batch.gaugeLabels, labels)
+		batch.gaugeValues = append(batch.gaugeValues, gauges[key])
+	}
+	for _, key := range sortedKeys(counters) {
+		id, labels, err := splitSeries(key)
+		if err != nil {
+			return pgxBatch{}, err
+		}
+		batch.counterNames = append(batch.counterNames, id)
+		batch.counterLabels = append(batch.counterLabels, labels)
+		batch.counterDeltas = append(batch.counterDeltas, counters[key])
+	}
+	for _, key := range sortedKeys(histograms) {
+		batch.histograms = append(batch.histograms, key)
+		batch.histogramVals = append(batch.histogramVals, histograms[key])
+	}
+
+	return batch, nil
+}
+
+// sortedKeys - method for getting the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetGauge - method for setting a gauge
+// if error, return error
+// if success, return nil
+func (d *PgxStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.pool.Exec(ctx, insertGaugeQuery, id, labels, value); err != nil {
+		return fmt.Errorf("failed to set gauge %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// GetGauge - method for getting a gauge
+// if error, return false
+// if success, return the value of the gauge and true
+func (d *PgxStorage) GetGauge(ctx context.Context, name string) (float64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	var value float64
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.pool.QueryRow(ctx, getGaugeQuery, id, labels).Scan(&value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+
+	return value, true
+}
+
+// GetAllGauges - method for getting all gauges
+// if error, return error
+// if success, return the value of the gauges
+func (d *PgxStorage) GetAllGauges(ctx context.Context) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx, getAllGaugesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]float64)
+
+	for rows.Next() {
+		var name string
+		var labels []byte
+		var value float64
+		if err := rows.Scan(&name, &labels, &value); err != nil {
+			return nil, err
+		}
+		key, err := joinSeries(name, labels)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetCounter - method for setting a counter
+// if error, return error
+// if success, return nil
+func (d *PgxStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.pool.Exec(ctx, insertCounterQuery, id, labels, value); err != nil {
+		return fmt.Errorf("failed to set counter %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// GetCounter - method for getting a counter
+// if error, return false
+// if success, return the value of the counter and true
+func (d *PgxStorage) GetCounter(ctx context.Context, name string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	var value int64
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.pool.QueryRow(ctx, getCounterQuery, id, labels).Scan(&value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+
+	return value, true
+}
+
+// GetAllCounters - method for getting all counters
+// if error, return error
+// if success, return the value of the counters
+func (d *PgxStorage) GetAllCounters(ctx context.Context) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx, getAllCountersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+
+	for rows.Next() {
+		var name string
+		var labels []byte
+		var value int64
+		if err := rows.Scan(&name, &labels, &value); err != nil {
+			return nil, err
+		}
+		key, err := joinSeries(name, labels)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetHistogram - method for setting a histogram
+// merge the histogram into the stored one inside a transaction
+// if error, return error
+// if success, return nil
+func (d *PgxStorage) SetHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, d.pool, func(tx pgx.Tx) error {
+		return mergePgxHistogram(ctx, tx, name, value)
+	})
+}
+
+// mergePgxHistogram - method for merging a histogram within a pgx transaction
+// works like mergeHistogram
+func mergePgxHistogram(ctx context.Context, tx pgx.Tx, name string, value models.HistogramValue) error {
+	if err := value.Validate(); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, insertHistogramQuery, id, labels, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to insert histogram %q: %w", name, err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil
+	}
+
+	var raw []byte
+	if err := tx.QueryRow(ctx, lockHistogramQuery, id, labels).Scan(&raw); err != nil {
+		return fmt.Errorf("failed to lock histogram %q: %w", name, err)
+	}
+
+	var current models.HistogramValue
+	if err := json.Unmarshal(raw, &current); err != nil {
+		return fmt.Errorf("failed to decode histogram %q: %w", name, err)
+	}
+	if err := current.Merge(value); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	data, err = json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, updateHistogramQuery, id, labels, string(data)); err != nil {
+		return fmt.Errorf("failed to update histogram %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// GetHistogram - method for getting a histogram
+// if error, return false
+// if success, return the histogram and true
+func (d *PgxStorage) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	var raw []byte
+	var value models.HistogramValue
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.pool.QueryRow(ctx, getHistogramQuery, id, labels).Scan(&raw)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return models.HistogramValue{}, false
+	}
+
+	return value, true
+}
+
+// GetAllHistograms - method for getting all histograms
+// if error, return error
+// if success, return the histograms
+func (d *PgxStorage) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx, getAllHistogramsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]models.HistogramValue)
+
+	for rows.Next() {
+		var name string
+		var labels, raw []byte
+		if err := rows.Scan(&name, &labels, &raw); err != nil {
+			return nil, err
+		}
+
+		key, err := joinSeries(name, labels)
+		if err != nil {
+			return nil, err
+		}
+
+		var value models.HistogramValue
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("failed to decode histogram %q: %w", key, err)
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetMetricBatch - method for setting a batch of metrics
+// the stored series of the batch are locked and read with one query and the batch is resolved against them,
+// then it is aggregated to one row per series and written in the same transaction:
+// one upsert for the gauges, one for the counters and a merge per histogram
+// if error, return error
+// if success, return nil
+func (d *PgxStorage) SetMetricBatch(ctx context.Context, metrics []models.Metrics) error {
+	_, err := d.SetMetricBatchChanges(ctx, metrics)
+	return err
+}
+
+// SetMetricBatchChanges - method for setting a batch of metrics
+// returns the changes of the metrics, computed from the rows locked by the write
+// if error, return error
+func (d *PgxStorage) SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error) {
+	names, labels, types, err := seriesArrays(metrics)
+	if err != nil {
+		return nil, err
+	}
+	totalNames, totalLabels, err := totalArrays(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.BatchTimeout)
+	defer cancel()
+
+	var changes []models.MetricChange
+	err = pgx.BeginFunc(ctx, d.pool, func(tx pgx.Tx) error {
+		if len(totalNames) > 0 {
+			if _, err := tx.Exec(ctx, insertTotalCountersQuery, totalNames, totalLabels); err != nil {
+				return fmt.Errorf("failed to insert counters: %w", err)
+			}
+		}
+		rows, err := tx.Query(ctx, lockSeriesQuery, names, labels, types)
+		if err != nil {
+			return fmt.Errorf("failed to lock metrics: %w", err)
+		}
+		states, err := scanStates(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		resolved, batchChanges, err := resolveBatch(metrics, states)
+		if err != nil {
+			return err
+		}
+		changes = batchChanges
+		batch, err := aggregateBatch(resolved)
+		if err != nil {
+			return err
+		}
+
+		if len(batch.gaugeNames) > 0 {
+			if _, err := tx.Exec(ctx, batchGaugesQuery,
+				batch.gaugeNames, batch.gaugeLabels, batch.gaugeValues); err != nil {
+				return fmt.Errorf("failed to insert gauges: %w", err)
+			}
+		}
+		if len(batch.counterNames) > 0 {
+			if _, err := tx.Exec(ctx, batchCountersQuery,
+				batch.counterNames, batch.counterLabels, batch.counterDeltas); err != nil {
+				return fmt.Errorf("failed to insert counters: %w", err)
+			}
+		}
+		for i, name := range batch.histograms {
+			if err := mergePgxHistogram(ctx, tx, name, batch.histogramVals[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetTimes - method for getting the write times of a series
+// if error, return false
+// if success, return the times and true
+func (d *PgxStorage) GetTimes(ctx context.Context, mType string, name string) (MetricTimes, bool) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	var times MetricTimes
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.pool.QueryRow(ctx, getTimesQuery, id, labels, mType).Scan(&times.CreatedAt, &times.LastUpdated)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric times",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return MetricTimes{}, false
+	}
+
+	return times, true
+}
+
+// GetHistory - method for getting the history of a series
+// get the samples written between from and to
+// if error, return error
+// if success, return the samples in time order
+func (d *PgxStorage) GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.pool.Query(ctx, getHistoryQuery, id, labels, mType, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Sample, 0)
+
+	for rows.Next() {
+		var s models.Sample
+		if err := rows.Scan(&s.Timestamp, &s.Value, &s.Min, &s.Max, &s.Sum, &s.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ApplyRetention - method for enforcing the retention rules
+// works like DBStorage.ApplyRetention, every metric name is compacted in its own transaction
+// if error, return error
+// if success, return nil
+func (d *PgxStorage) ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error {
+	names, err := d.historyNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		rule, ok := matchRule(rules, name)
+		if !ok {
+			continue
+		}
+
+		if err := d.compactHistory(ctx, name, rule, now); err != nil {
+			return fmt.Errorf("failed to compact history of %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// historyNames - method for getting the metric names that have history
+func (d *PgxStorage) historyNames(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.BatchTimeout)
+	defer cancel()
+
+	rows, err := d.pool.Query(ctx, getHistoryNamesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// compactHistory - method for applying a retention rule to one metric name
+func (d *PgxStorage) compactHistory(ctx context.Context, name string, rule RetentionRule, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.BatchTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, d.pool, func(tx pgx.Tx) error {
+		rawCutoff := rule.rawCutoff(now)
+
+		if rule.RollupStep > 0 {
+			step := int64(time.Duration(rule.RollupStep) / time.Second)
+			if _, err := tx.Exec(ctx, rollupHistoryQuery, name, step, rawCutoff); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(ctx, deleteRawHistoryQuery, name, rawCutoff); err != nil {
+			return err
+		}
+
+		rollupCutoff := now
+		if rule.RollupStep > 0 {
+			rollupCutoff = rule.rollupCutoff(now)
+		}
+		_, err := tx.Exec(ctx, deleteRollupHistoryQuery, name, rollupCutoff)
+		return err
+	})
+}
+
+// Ping - method for pinging the database
+func (d *PgxStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.QueryTimeout)
+	defer cancel()
+
+	return d.pool.Ping(ctx)
+}
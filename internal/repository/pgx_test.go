@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"testing"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateBatch(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	h := models.NewHistogram([]float64{1})
+	h.Observe(0.5)
+
+	tests := []struct {
+		name    string
+		metrics []models.Metrics
+		want    pgxBatch
+		wantErr bool
+	}{
+		{
+			name: "duplicates are aggregated",
+			metrics: []models.Metrics{
+				{ID: "requests", MType: models.Counter, Delta: delta(2)},
+				{ID: "load", MType: models.Gauge, Value: value(1)},
+				{ID: "requests", MType: models.Counter, Delta: delta(3)},
+				{ID: "load", MType: models.Gauge, Value: value(2)},
+				{ID: "errors", MType: models.Counter, Delta: delta(1), Labels: map[string]string{"code": "500"}},
+				{ID: "latency", MType: models.Histogram, Histogram: h},
+				{ID: "latency", MType: models.Histogram, Histogram: h},
+			},
+			want: pgxBatch{
+				gaugeNames:    []string{"load"},
+				gaugeLabels:   []string{"{}"},
+				gaugeValues:   []float64{2},
+				counterNames:  []string{"errors", "requests"},
+				counterLabels: []string{`{"code":"500"}`, "{}"},
+				counterDeltas: []int64{1, 5},
+				histograms:    []string{"latency"},
+				histogramVals: []models.HistogramValue{{Bounds: []float64{1}, Counts: []uint64{2, 0}, Sum: 1, Count: 2}},
+			},
+		},
+		{
+			name:    "counter without delta",
+			metrics: []models.Metrics{{ID: "requests", MType: models.Counter}},
+			wantErr: true,
+		},
+		{
+			name: "histograms with different bounds",
+			metrics: []models.Metrics{
+				{ID: "latency", MType: models.Histogram, Histogram: h},
+				{ID: "latency", MType: models.Histogram, Histogram: models.NewHistogram([]float64{2})},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aggregateBatch(tt.metrics)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// the batch doesn't change the histograms it was given
+	assert.Equal(t, uint64(1), h.Count)
+}
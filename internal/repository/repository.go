@@ -3,7 +3,10 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/makimaki04/go-metrics-agent.git/internal/breaker"
 	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
 	"go.uber.org/zap"
 )
@@ -15,26 +18,71 @@ import (
 // GetCounter - method for getting a counter
 // GetAllGauges - method for getting all gauges
 // GetAllCounters - method for getting all counters
+// SetHistogram - method for merging a histogram into the stored one
+// GetHistogram - method for getting a histogram
+// GetAllHistograms - method for getting all histograms
 // SetMetricBatch - method for setting a batch of metrics
+// GetHistory - method for getting the samples of a gauge or counter series
+// ApplyRetention - method for rolling up and deleting old history samples
 // Ping - method for pinging the database
+// every method takes the context of the caller, database calls are aborted when it is done
 type Repository interface {
-	SetGauge(name string, value float64) error
-	SetCounter(name string, value int64) error
-	GetGauge(ctx context.Context,name string) (float64, bool)
-	GetCounter(name string) (int64, bool)
-	GetAllGauges() (map[string]float64, error)
-	GetAllCounters() (map[string]int64, error)
-	SetMetricBatch(metrics []models.Metrics) error
-	Ping() error
+	SetGauge(ctx context.Context, name string, value float64) error
+	SetCounter(ctx context.Context, name string, value int64) error
+	GetGauge(ctx context.Context, name string) (float64, bool)
+	GetCounter(ctx context.Context, name string) (int64, bool)
+	GetAllGauges(ctx context.Context) (map[string]float64, error)
+	GetAllCounters(ctx context.Context) (map[string]int64, error)
+	SetHistogram(ctx context.Context, name string, value models.HistogramValue) error
+	GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool)
+	GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error)
+	SetMetricBatch(ctx context.Context, metrics []models.Metrics) error
+	GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error)
+	ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error
+	Ping(ctx context.Context) error
+}
+
+// MetricTimes - struct for the times a series was written at
+// CreatedAt - time of the first write of the series
+// LastUpdated - time of the last write of the series
+type MetricTimes struct {
+	CreatedAt   time.Time
+	LastUpdated time.Time
+}
+
+// TimesReader - interface for the storages that track the write times of the series
+// GetTimes - method for getting the write times of a series
+// implemented by the database storages, the in-memory one doesn't keep them
+type TimesReader interface {
+	GetTimes(ctx context.Context, mType string, name string) (MetricTimes, bool)
+}
+
+// ChangeWriter - interface for the storages returning the changes a batch makes
+// SetMetricBatchChanges - method for setting a batch of metrics like SetMetricBatch,
+// returns the values every metric had before and after its update, read in the write
+// implemented by all the storages of the package, the audit uses it to avoid extra reads
+type ChangeWriter interface {
+	SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error)
 }
 
 // NewStorage - creates a new in-memory storage implementation
 // returns a Repository interface implementation using MemStorage
 // the storage is thread-safe and stores metrics in memory
 func NewStorage() Repository {
+	return NewStorageWithHistory(defaultHistorySize)
+}
+
+// NewStorageWithHistory - creates a new in-memory storage implementation
+// historySize - number of samples kept per series
+// returns a Repository interface implementation using MemStorage
+func NewStorageWithHistory(historySize int) Repository {
 	return &MemStorage{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
+		gauges:      make(map[string]float64),
+		counters:    make(map[string]int64),
+		histograms:  make(map[string]models.HistogramValue),
+		history:     make(map[string]*sampleRing),
+		rollups:     make(map[string][]models.Sample),
+		historySize: historySize,
 	}
 }
 
@@ -49,3 +97,66 @@ func NewDBStorage(db *sql.DB, logger *zap.Logger) Repository {
 		logger: logger,
 	}
 }
+
+// NewPgxStorage - creates a new database storage implementation on a pgx pool
+// pool - connection pool created by NewPgxPool
+// cfg - pool settings, the zero timeouts get the DBStorage ones
+// logger - logger instance for logging operations
+// returns a Repository interface implementation using PgxStorage
+func NewPgxStorage(pool *pgxpool.Pool, cfg PgxConfig, logger *zap.Logger) Repository {
+	if cfg.QueryTimeout <= 0 {
+		cfg.QueryTimeout = 2 * time.Second
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 5 * time.Second
+	}
+
+	return &PgxStorage{
+		pool:   pool,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// NewSQLiteStorage - creates a new embedded SQLite storage implementation
+// db - database opened by OpenSQLite
+// logger - logger instance for logging operations
+// returns a Repository interface implementation using SQLiteStorage
+// the storage persists metrics in a single SQLite file
+func NewSQLiteStorage(db *sql.DB, logger *zap.Logger) Repository {
+	return &SQLiteStorage{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// NewBreakerStorage - creates a repository guarded by a circuit breaker
+// storage - repository to guard
+// b - circuit breaker tracking the health of the storage
+// returns a Repository that fails fast while the breaker is open
+func NewBreakerStorage(storage Repository, b *breaker.Breaker) *BreakerStorage {
+	return &BreakerStorage{
+		storage: storage,
+		breaker: b,
+	}
+}
+
+// NewWALStorage - creates a repository persisting every update to a write-ahead log
+// storage - repository the updates are applied to, expected to be empty
+// file - storage file the checkpoints are written to
+// cfg - write-ahead log settings
+// logger - logger instance for logging operations
+// the storage is rebuilt from the storage file and the log before it is returned
+// if error, return error
+func NewWALStorage(ctx context.Context, storage Repository, file *SnapshotFile, cfg WALConfig, logger *zap.Logger) (*WALStorage, error) {
+	s := &WALStorage{
+		storage: storage,
+		file:    file,
+		cfg:     cfg,
+		logger:  logger,
+	}
+	if err := s.open(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -13,6 +13,31 @@ func (s *DBStorage) Reset() {
 
 }
 
+func (s *PgxStorage) Reset() {
+	if s == nil {
+		return
+	}
+
+	s.pool = nil
+
+	var z_cfg PgxConfig
+	s.cfg = z_cfg
+
+	s.logger = nil
+
+}
+
+func (s *SQLiteStorage) Reset() {
+	if s == nil {
+		return
+	}
+
+	s.db = nil
+
+	s.logger = nil
+
+}
+
 func (s *MemStorage) Reset() {
 	if s == nil {
 		return
@@ -22,4 +47,12 @@ func (s *MemStorage) Reset() {
 
 	clear(s.counters)
 
+	clear(s.histograms)
+
+	clear(s.history)
+
+	clear(s.rollups)
+
+	s.historySize = 0
+
 }
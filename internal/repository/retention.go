@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Duration - time.Duration that is read from json as a string like "24h"
+type Duration time.Duration
+
+// UnmarshalJSON - method for decoding the duration from a string or seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var sec int64
+		if err := json.Unmarshal(data, &sec); err != nil {
+			return fmt.Errorf("duration must be a string or seconds: %s", data)
+		}
+		*d = Duration(time.Duration(sec) * time.Second)
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// MarshalJSON - method for encoding the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// RetentionRule - struct for the history retention rule
+// Pattern - glob matched against the metric name, e.g. "CPU*"
+// Raw - how long raw samples are kept
+// RollupStep - size of the rollup buckets, zero disables rollups
+// Rollup - how long rollups are kept, counted from now
+type RetentionRule struct {
+	Pattern    string   `json:"pattern"`
+	Raw        Duration `json:"raw"`
+	RollupStep Duration `json:"rollup_step"`
+	Rollup     Duration `json:"rollup"`
+}
+
+// Validate - method for checking the retention rule
+func (r RetentionRule) Validate() error {
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return fmt.Errorf("retention rule %q: %w", r.Pattern, err)
+	}
+	if r.Raw <= 0 {
+		return fmt.Errorf("retention rule %q: raw must be positive", r.Pattern)
+	}
+	if r.RollupStep < 0 {
+		return fmt.Errorf("retention rule %q: rollup_step must not be negative", r.Pattern)
+	}
+	if r.RollupStep > 0 && r.Rollup <= r.Raw {
+		return fmt.Errorf("retention rule %q: rollup must be longer than raw", r.Pattern)
+	}
+
+	return nil
+}
+
+// rawCutoff - method for getting the time before which raw samples are compacted
+// with rollups the cutoff is aligned to the step so only complete buckets are rolled up
+func (r RetentionRule) rawCutoff(now time.Time) time.Time {
+	cutoff := now.Add(-time.Duration(r.Raw))
+	if r.RollupStep > 0 {
+		cutoff = cutoff.Truncate(time.Duration(r.RollupStep))
+	}
+	return cutoff
+}
+
+// rollupCutoff - method for getting the time before which rollups are deleted
+func (r RetentionRule) rollupCutoff(now time.Time) time.Time {
+	return now.Add(-time.Duration(r.Rollup))
+}
+
+// matchRule - finds the first rule whose pattern matches the metric name
+// returns false if no rule matches, such series are kept forever
+func matchRule(rules []RetentionRule, name string) (RetentionRule, bool) {
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			return r, true
+		}
+	}
+	return RetentionRule{}, false
+}
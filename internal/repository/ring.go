@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+)
+
+// defaultHistorySize - number of samples kept per series by the in-memory storage
+const defaultHistorySize = 1000
+
+// sampleRing - fixed size ring buffer of time ordered samples
+// the buffer grows with the samples up to the capacity, when full the oldest sample is overwritten
+type sampleRing struct {
+	buf      []models.Sample
+	start    int
+	size     int
+	capacity int
+}
+
+// newSampleRing - creates a ring for the given number of samples
+// nothing is allocated until the first sample
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{capacity: capacity}
+}
+
+// push - method for appending a sample
+func (r *sampleRing) push(s models.Sample) {
+	if r.capacity <= 0 {
+		return
+	}
+
+	// until the first wrap the samples are buf[start:], so they are appended
+	if len(r.buf) < r.capacity {
+		r.buf = append(r.buf, s)
+		r.size++
+		return
+	}
+
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = s
+		r.size++
+		return
+	}
+
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// between - method for getting the samples in [from, to] in time order
+func (r *sampleRing) between(from, to time.Time) []models.Sample {
+	result := make([]models.Sample, 0)
+	for i := 0; i < r.size; i++ {
+		s := r.buf[(r.start+i)%len(r.buf)]
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// dropBefore - method for removing the samples older than t
+// returns the removed samples in time order
+func (r *sampleRing) dropBefore(t time.Time) []models.Sample {
+	removed := make([]models.Sample, 0)
+	for r.size > 0 && r.buf[r.start].Timestamp.Before(t) {
+		removed = append(removed, r.buf[r.start])
+		r.buf[r.start] = models.Sample{}
+		r.start = (r.start + 1) % len(r.buf)
+		r.size--
+	}
+	// an empty ring starts over, the samples are appended to buf[start:] until it is full
+	if r.size == 0 && r.buf != nil {
+		r.buf = r.buf[:0]
+		r.start = 0
+	}
+	return removed
+}
+
+// samplesBetween - filters time ordered samples to [from, to]
+func samplesBetween(samples []models.Sample, from, to time.Time) []models.Sample {
+	result := make([]models.Sample, 0)
+	for _, s := range samples {
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// historyKey - builds the key of the series history
+// gauges and counters with the same name are different series
+func historyKey(mType string, name string) string {
+	return mType + ":" + name
+}
+
+// splitHistoryKey - splits the history key into the metric type and series key
+func splitHistoryKey(key string) (string, string) {
+	mType, name, _ := strings.Cut(key, ":")
+	return mType, name
+}
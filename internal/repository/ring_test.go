@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleRing(t *testing.T) {
+	start := time.Now()
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	ring := newSampleRing(3)
+	assert.Nil(t, ring.buf)
+
+	for i := 0; i < 2; i++ {
+		ring.push(models.NewGaugeSample(at(i), float64(i)))
+	}
+	assert.Len(t, ring.buf, 2)
+
+	// the dropped samples are reused once the ring is full
+	assert.Len(t, ring.dropBefore(at(1)), 1)
+	for i := 2; i < 6; i++ {
+		ring.push(models.NewGaugeSample(at(i), float64(i)))
+	}
+	assert.Len(t, ring.buf, 3)
+
+	var values []float64
+	for _, s := range ring.between(at(0), at(10)) {
+		values = append(values, s.Value)
+	}
+	assert.Equal(t, []float64{3, 4, 5}, values)
+
+	empty := newSampleRing(-1)
+	empty.push(models.NewGaugeSample(at(0), 1))
+	assert.Empty(t, empty.between(at(0), at(1)))
+}
+
+func TestSampleRing_DropAll(t *testing.T) {
+	start := time.Now()
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	values := func(ring *sampleRing) []float64 {
+		var values []float64
+		for _, s := range ring.between(at(0), at(100)) {
+			values = append(values, s.Value)
+		}
+		return values
+	}
+
+	// a ring that was never full, then a full one
+	for _, pushed := range []int{3, 5} {
+		ring := newSampleRing(5)
+		for i := 0; i < pushed; i++ {
+			ring.push(models.NewGaugeSample(at(i), float64(i)))
+		}
+		assert.Len(t, ring.dropBefore(at(10)), pushed)
+		assert.Empty(t, values(ring))
+
+		for i := 10; i < 13; i++ {
+			ring.push(models.NewGaugeSample(at(i), float64(i)))
+		}
+		assert.Equal(t, []float64{10, 11, 12}, values(ring))
+
+		for i := 13; i < 20; i++ {
+			ring.push(models.NewGaugeSample(at(i), float64(i)))
+		}
+		assert.Equal(t, []float64{15, 16, 17, 18, 19}, values(ring))
+	}
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+)
+
+// SnapshotVersion - format version of the snapshots written by SnapshotFile
+const SnapshotVersion = 1
+
+// ErrChecksum - the snapshot doesn't match its checksum
+var ErrChecksum = errors.New("snapshot checksum mismatch")
+
+// Snapshot - struct for the metrics saved to the storage file
+// LSN - last write-ahead log record in the snapshot, zero if the snapshot isn't a WAL checkpoint
+type Snapshot struct {
+	Counters   map[string]int64                 `json:"counters"`
+	Gauges     map[string]float64               `json:"gauges"`
+	Histograms map[string]models.HistogramValue `json:"histograms"`
+	LSN        uint64                           `json:"lsn,omitempty"`
+}
+
+// snapshotEnvelope - struct for the storage file
+// Checksum - hex sha256 of the compact Metrics json, the file itself is indented
+type snapshotEnvelope struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Metrics  json.RawMessage `json:"metrics"`
+}
+
+// SnapshotSource - interface for the reads a snapshot is taken with
+// implemented by the storages and the metrics service
+type SnapshotSource interface {
+	GetAllGauges(ctx context.Context) (map[string]float64, error)
+	GetAllCounters(ctx context.Context) (map[string]int64, error)
+	GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error)
+}
+
+// TakeSnapshot - method for reading all the metrics of the source
+// if error, return error
+func TakeSnapshot(ctx context.Context, src SnapshotSource) (Snapshot, error) {
+	gauges, err := src.GetAllGauges(ctx)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("couldn't get gauges: %w", err)
+	}
+	counters, err := src.GetAllCounters(ctx)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("couldn't get counters: %w", err)
+	}
+	histograms, err := src.GetAllHistograms(ctx)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("couldn't get histograms: %w", err)
+	}
+
+	return Snapshot{Counters: counters, Gauges: gauges, Histograms: histograms}, nil
+}
+
+// Restore - method for writing the snapshot into a storage
+// counters are added to the stored values, so the storage is expected to be empty
+// if error, return error
+func (s Snapshot) Restore(ctx context.Context, storage Repository) error {
+	for key, value := range s.Gauges {
+		if err := storage.SetGauge(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	for key, value := range s.Counters {
+		if err := storage.SetCounter(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	for key, value := range s.Histograms {
+		if err := storage.SetHistogram(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SnapshotFile - struct for the storage file the snapshots are written to
+// a snapshot is written to a temp file, fsynced and renamed over the storage file,
+// so a crash leaves either the old or the new snapshot
+// saves are serialized, so a slower save never replaces a newer snapshot
+type SnapshotFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewSnapshotFile - constructor for SnapshotFile
+func NewSnapshotFile(path string) *SnapshotFile {
+	return &SnapshotFile{path: path}
+}
+
+// Save - method for taking a snapshot of the source and writing it to the file
+// if error, return error
+func (f *SnapshotFile) Save(ctx context.Context, src SnapshotSource) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshot, err := TakeSnapshot(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	return f.write(snapshot)
+}
+
+// Write - method for writing a snapshot taken by the caller to the file
+// if error, return error
+func (f *SnapshotFile) Write(snapshot Snapshot) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.write(snapshot)
+}
+
+// write - method for writing the snapshot with its checksum
+// must be called with the lock held
+// if error, return error
+func (f *SnapshotFile) write(snapshot Snapshot) error {
+	metrics, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal snapshot: %w", err)
+	}
+	sum := sha256.Sum256(metrics)
+
+	data, err := json.MarshalIndent(snapshotEnvelope{
+		Version:  SnapshotVersion,
+		Checksum: hex.EncodeToString(sum[:]),
+		Metrics:  metrics,
+	}, "", "	")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal snapshot: %w", err)
+	}
+
+	return writeAtomic(f.path, data)
+}
+
+// Load - method for reading the snapshot from the file
+// files written before the format had a version are read without the checksum check
+// if the file doesn't exist, return os.ErrNotExist
+// if error, return error
+func (f *SnapshotFile) Load() (Snapshot, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return Snapshot{}, nil
+	}
+
+	var envelope snapshotEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return Snapshot{}, fmt.Errorf("couldn't parse snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	switch {
+	case envelope.Version == 0 && envelope.Metrics == nil:
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return Snapshot{}, fmt.Errorf("couldn't parse snapshot: %w", err)
+		}
+		return snapshot, nil
+	case envelope.Version > SnapshotVersion:
+		return Snapshot{}, fmt.Errorf("unsupported snapshot version %d, expected at most %d", envelope.Version, SnapshotVersion)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, envelope.Metrics); err != nil {
+		return Snapshot{}, fmt.Errorf("couldn't parse snapshot: %w", err)
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	if hex.EncodeToString(sum[:]) != envelope.Checksum {
+		return Snapshot{}, ErrChecksum
+	}
+
+	if err := json.Unmarshal(envelope.Metrics, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("couldn't parse snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// writeAtomic - method for replacing the file with the data
+// the data is written to a temp file in the same directory, fsynced and renamed over the file,
+// then the directory is fsynced so the rename survives a crash
+// if error, return error
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("couldn't create temp file: %w", err)
+	}
+
+	_, err = tmp.Write(data)
+	if serr := tmp.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("couldn't write temp file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("couldn't replace storage file: %w", err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("couldn't sync storage directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("couldn't sync storage directory: %w", err)
+	}
+
+	return nil
+}
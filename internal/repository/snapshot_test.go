@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFile_SaveLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "save.json")
+
+	storage := NewStorage()
+	require.NoError(t, storage.SetGauge(ctx, "load", 1.5))
+	require.NoError(t, storage.SetCounter(ctx, `requests{path="/"}`, 7))
+	h := models.NewHistogram([]float64{1})
+	h.Observe(0.5)
+	require.NoError(t, storage.SetHistogram(ctx, "latency", *h))
+
+	file := NewSnapshotFile(path)
+	require.NoError(t, file.Save(ctx, storage))
+
+	matches, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "the temp file is renamed")
+
+	snapshot, err := file.Load()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"load": 1.5}, snapshot.Gauges)
+	assert.Equal(t, map[string]int64{`requests{path="/"}`: 7}, snapshot.Counters)
+	assert.Equal(t, uint64(1), snapshot.Histograms["latency"].Count)
+
+	restored := NewStorage()
+	require.NoError(t, snapshot.Restore(ctx, restored))
+	counter, _ := restored.GetCounter(ctx, `requests{path="/"}`)
+	assert.Equal(t, int64(7), counter)
+}
+
+func TestSnapshotFile_Load(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		edit    func(data []byte) []byte
+		wantErr error
+		gauges  map[string]float64
+	}{
+		{
+			name:    "legacy format",
+			content: `{"counters": {}, "gauges": {"load": 2}, "histograms": {}}`,
+			gauges:  map[string]float64{"load": 2},
+		},
+		{
+			name:    "empty file",
+			content: "",
+		},
+		{
+			name: "corrupted value",
+			edit: func(data []byte) []byte {
+				return bytes.Replace(data, []byte("1.5"), []byte("9.5"), 1)
+			},
+			wantErr: ErrChecksum,
+		},
+		{
+			name: "newer version",
+			edit: func(data []byte) []byte {
+				return bytes.Replace(data, []byte(`"version": 1`), []byte(`"version": 2`), 1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "save.json")
+			file := NewSnapshotFile(path)
+
+			if tt.edit != nil {
+				storage := NewStorage()
+				require.NoError(t, storage.SetGauge(context.Background(), "load", 1.5))
+				require.NoError(t, file.Save(context.Background(), storage))
+
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				tt.content = string(tt.edit(data))
+			}
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+
+			snapshot, err := file.Load()
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.edit != nil:
+				assert.Error(t, err)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.gauges, snapshot.Gauges)
+			}
+		})
+	}
+}
@@ -0,0 +1,761 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteScheme - prefix of the dsns served by SQLiteStorage
+const sqliteScheme = "sqlite://"
+
+// sqlitePragmas - default connection settings: wait for the lock instead of failing with SQLITE_BUSY,
+// keep readers unblocked by the writer and fsync the journal on every commit,
+// so an acknowledged write survives a power loss
+// a pragma set in the dsn, like _pragma=synchronous(NORMAL), replaces the default one
+var sqlitePragmas = []string{"busy_timeout(5000)", "journal_mode(WAL)", "synchronous(FULL)"}
+
+// SQLiteStorage - struct for the embedded SQLite storage
+// the database is a single file, so it has one connection and the writes are serialized
+// generate:reset
+type SQLiteStorage struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+// Constants for the SQLite storage
+// labels are the json of the sorted labels, so equal label sets are equal strings
+// timestamps are unix nanoseconds
+const (
+	sqliteInsertGaugeQuery = `
+		INSERT INTO metrics (name, labels, metric_type, gauge_value, timestamp, created_at)
+		VALUES (?1, ?2, 'gauge', ?3, ?4, ?4)
+		ON CONFLICT (name, metric_type, labels)
+		DO UPDATE
+		SET gauge_value = excluded.gauge_value, timestamp = excluded.timestamp
+		RETURNING gauge_value
+	`
+
+	sqliteInsertCounterQuery = `
+		INSERT INTO metrics (name, labels, metric_type, counter_value, timestamp, created_at)
+		VALUES (?1, ?2, 'counter', ?3, ?4, ?4)
+		ON CONFLICT (name, metric_type, labels)
+		DO UPDATE
+		SET counter_value = metrics.counter_value + excluded.counter_value, timestamp = excluded.timestamp
+		RETURNING counter_value
+	`
+
+	sqliteInsertHistoryQuery = `
+		INSERT INTO metrics_history (name, labels, metric_type, ts, value, min_value, max_value, sum_value)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	sqliteGetGaugeQuery = `
+		SELECT gauge_value FROM metrics
+		WHERE name = ? AND labels = ? AND metric_type = 'gauge'
+	`
+
+	sqliteGetAllGaugesQuery = `
+		SELECT name, labels, gauge_value FROM metrics
+		WHERE metric_type = 'gauge'
+	`
+
+	sqliteGetCounterQuery = `
+		SELECT counter_value FROM metrics
+		WHERE name = ? AND labels = ? AND metric_type = 'counter'
+	`
+
+	sqliteGetSeriesQuery = `
+		SELECT gauge_value, counter_value, histogram_value FROM metrics
+		WHERE name = ? AND labels = ? AND metric_type = ?
+	`
+
+	sqliteGetTimesQuery = `
+		SELECT created_at, timestamp FROM metrics
+		WHERE name = ? AND labels = ? AND metric_type = ?
+	`
+
+	sqliteGetAllCountersQuery = `
+		SELECT name, labels, counter_value FROM metrics
+		WHERE metric_type = 'counter'
+	`
+
+	sqliteInsertHistogramQuery = `
+		INSERT INTO metrics (name, labels, metric_type, histogram_value, timestamp, created_at)
+		VALUES (?1, ?2, 'histogram', ?3, ?4, ?4)
+		ON CONFLICT (name, metric_type, labels) DO NOTHING
+	`
+
+	sqliteUpdateHistogramQuery = `
+		UPDATE metrics SET histogram_value = ?, timestamp = ?
+		WHERE name = ? AND labels = ? AND metric_type = 'histogram'
+	`
+
+	sqliteGetHistogramQuery = `
+		SELECT histogram_value FROM metrics
+		WHERE name = ? AND labels = ? AND metric_type = 'histogram'
+	`
+
+	sqliteGetAllHistogramsQuery = `
+		SELECT name, labels, histogram_value FROM metrics
+		WHERE metric_type = 'histogram'
+	`
+
+	sqliteGetHistoryNamesQuery = `
+		SELECT DISTINCT name FROM metrics_history
+	`
+
+	// the value of a bucket is the value of its last sample
+	sqliteRollupHistoryQuery = `
+		INSERT INTO metrics_history
+			(name, labels, metric_type, ts, value, min_value, max_value, sum_value, sample_count, resolution_seconds)
+		SELECT g.name, g.labels, g.metric_type, g.bucket,
+			(SELECT h.value FROM metrics_history h
+			 WHERE h.name = g.name AND h.labels = g.labels AND h.metric_type = g.metric_type
+			   AND h.resolution_seconds = 0 AND h.ts = g.last_ts
+			 ORDER BY h.id DESC LIMIT 1),
+			g.min_value, g.max_value, g.sum_value, g.sample_count, ?2
+		FROM (
+			SELECT name, labels, metric_type, (ts / ?3) * ?3 AS bucket, max(ts) AS last_ts,
+				min(min_value) AS min_value, max(max_value) AS max_value,
+				sum(sum_value) AS sum_value, sum(sample_count) AS sample_count
+			FROM metrics_history
+			WHERE name = ?1 AND resolution_seconds = 0 AND ts < ?4
+			GROUP BY name, labels, metric_type, bucket
+		) g
+	`
+
+	sqliteDeleteRawHistoryQuery = `
+		DELETE FROM metrics_history
+		WHERE name = ? AND resolution_seconds = 0 AND ts < ?
+	`
+
+	sqliteDeleteRollupHistoryQuery = `
+		DELETE FROM metrics_history
+		WHERE name = ? AND resolution_seconds > 0 AND ts < ?
+	`
+
+	sqliteGetHistoryQuery = `
+		SELECT ts, value, min_value, max_value, sum_value, sample_count FROM metrics_history
+		WHERE name = ? AND labels = ? AND metric_type = ? AND ts BETWEEN ? AND ?
+		ORDER BY ts
+	`
+)
+
+// IsSQLiteDSN - method for checking if the dsn is served by SQLiteStorage
+func IsSQLiteDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, sqliteScheme)
+}
+
+// OpenSQLite - method for opening the database of a sqlite://path dsn
+// the connection pool is limited to one connection, the database is locked by a writer anyway
+// the pragmas of the dsn are kept, the defaults are added for the rest, see sqlitePragmas
+// if error, return error
+func OpenSQLite(dsn string) (*sql.DB, error) {
+	if !IsSQLiteDSN(dsn) {
+		return nil, fmt.Errorf("invalid sqlite dsn %q, must start with %s", dsn, sqliteScheme)
+	}
+
+	path := strings.TrimPrefix(dsn, sqliteScheme)
+	if path == "" {
+		return nil, fmt.Errorf("invalid sqlite dsn %q, has no path", dsn)
+	}
+	for _, pragma := range sqlitePragmas {
+		name, _, _ := strings.Cut(pragma, "(")
+		if strings.Contains(path, "_pragma="+name+"(") {
+			continue
+		}
+		if strings.Contains(path, "?") {
+			path += "&_pragma=" + pragma
+		} else {
+			path += "?_pragma=" + pragma
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// SetGauge - method for setting a gauge
+// set the value of the gauge and record a history sample
+// if error, return error
+// if success, return nil
+func (d *SQLiteStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		return setSQLiteGauge(ctx, tx, name, value, time.Now())
+	})
+}
+
+// GetGauge - method for getting a gauge
+// get the value of the gauge
+// if error, return false
+// if success, return the value of the gauge and true
+func (d *SQLiteStorage) GetGauge(ctx context.Context, name string) (float64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var value float64
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, sqliteGetGaugeQuery, id, labels).Scan(&value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+
+	return value, true
+}
+
+// GetAllGauges - method for getting all gauges
+// if error, return error
+// if success, return the value of the gauges
+func (d *SQLiteStorage) GetAllGauges(ctx context.Context) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sqliteGetAllGaugesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]float64)
+
+	for rows.Next() {
+		var name, labels string
+		var value float64
+		if err := rows.Scan(&name, &labels, &value); err != nil {
+			return nil, err
+		}
+		key, err := joinSeries(name, []byte(labels))
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetCounter - method for setting a counter
+// add the value to the counter and record a history sample
+// if error, return error
+// if success, return nil
+func (d *SQLiteStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		return setSQLiteCounter(ctx, tx, name, value, time.Now())
+	})
+}
+
+// GetCounter - method for getting a counter
+// get the value of the counter
+// if error, return false
+// if success, return the value of the counter and true
+func (d *SQLiteStorage) GetCounter(ctx context.Context, name string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var value int64
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, sqliteGetCounterQuery, id, labels).Scan(&value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+
+	return value, true
+}
+
+// GetAllCounters - method for getting all counters
+// if error, return error
+// if success, return the value of the counters
+func (d *SQLiteStorage) GetAllCounters(ctx context.Context) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sqliteGetAllCountersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+
+	for rows.Next() {
+		var name, labels string
+		var value int64
+		if err := rows.Scan(&name, &labels, &value); err != nil {
+			return nil, err
+		}
+		key, err := joinSeries(name, []byte(labels))
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetHistogram - method for setting a histogram
+// merge the histogram into the stored one inside a transaction
+// if error, return error
+// if success, return nil
+func (d *SQLiteStorage) SetHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		return mergeSQLiteHistogram(ctx, tx, name, value, time.Now())
+	})
+}
+
+// GetHistogram - method for getting a histogram
+// if error, return false
+// if success, return the histogram and true
+func (d *SQLiteStorage) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var raw string
+	var value models.HistogramValue
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, sqliteGetHistogramQuery, id, labels).Scan(&raw)
+	}
+	if err == nil {
+		err = json.Unmarshal([]byte(raw), &value)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return models.HistogramValue{}, false
+	}
+
+	return value, true
+}
+
+// GetAllHistograms - method for getting all histograms
+// if error, return error
+// if success, return the histograms
+func (d *SQLiteStorage) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sqliteGetAllHistogramsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]models.HistogramValue)
+
+	for rows.Next() {
+		var name, labels, raw string
+		if err := rows.Scan(&name, &labels, &raw); err != nil {
+			return nil, err
+		}
+
+		key, err := joinSeries(name, []byte(labels))
+		if err != nil {
+			return nil, err
+		}
+
+		var value models.HistogramValue
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, fmt.Errorf("failed to decode histogram %q: %w", key, err)
+		}
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetMetricBatch - method for setting a batch of metrics
+// the batch is resolved against the stored series and written in one transaction,
+// a failed metric rolls back the whole batch
+// if error, return error
+// if success, return nil
+func (d *SQLiteStorage) SetMetricBatch(ctx context.Context, metrics []models.Metrics) error {
+	_, err := d.SetMetricBatchChanges(ctx, metrics)
+	return err
+}
+
+// SetMetricBatchChanges - method for setting a batch of metrics
+// returns the changes of the metrics, computed from the rows read in the write
+// if error, return error
+func (d *SQLiteStorage) SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	var changes []models.MetricChange
+	err := d.inTx(ctx, func(tx *sql.Tx) error {
+		states, err := sqliteStates(ctx, tx, metrics)
+		if err != nil {
+			return err
+		}
+		resolved, batchChanges, err := resolveBatch(metrics, states)
+		if err != nil {
+			return err
+		}
+		changes = batchChanges
+
+		for _, m := range resolved {
+			switch m.MType {
+			case "gauge":
+				if err := setSQLiteGauge(ctx, tx, m.SeriesKey(), *m.Value, now); err != nil {
+					return err
+				}
+			case "counter":
+				if err := setSQLiteCounter(ctx, tx, m.SeriesKey(), *m.Delta, now); err != nil {
+					return err
+				}
+			case "histogram":
+				if err := mergeSQLiteHistogram(ctx, tx, m.SeriesKey(), *m.Histogram, now); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetTimes - method for getting the write times of a series
+// the times are stored as unix nanoseconds
+// if error, return false
+// if success, return the times and true
+func (d *SQLiteStorage) GetTimes(ctx context.Context, mType string, name string) (MetricTimes, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var createdAt, lastUpdated int64
+
+	id, labels, err := splitSeries(name)
+	if err == nil {
+		err = d.db.QueryRowContext(ctx, sqliteGetTimesQuery, id, labels, mType).Scan(&createdAt, &lastUpdated)
+	}
+	if err != nil {
+		d.logger.Info("failed to get metric times",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return MetricTimes{}, false
+	}
+
+	return MetricTimes{CreatedAt: time.Unix(0, createdAt), LastUpdated: time.Unix(0, lastUpdated)}, true
+}
+
+// GetHistory - method for getting the history of a series
+// get the samples written between from and to
+// if error, return error
+// if success, return the samples in time order
+func (d *SQLiteStorage) GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, sqliteGetHistoryQuery, id, labels, mType, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Sample, 0)
+
+	for rows.Next() {
+		var s models.Sample
+		var ts int64
+		if err := rows.Scan(&ts, &s.Value, &s.Min, &s.Max, &s.Sum, &s.Count); err != nil {
+			return nil, err
+		}
+		s.Timestamp = time.Unix(0, ts)
+		result = append(result, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ApplyRetention - method for enforcing the retention rules
+// works like DBStorage.ApplyRetention, every metric name is compacted in its own transaction
+// if error, return error
+// if success, return nil
+func (d *SQLiteStorage) ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error {
+	names, err := d.historyNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		rule, ok := matchRule(rules, name)
+		if !ok {
+			continue
+		}
+
+		if err := d.compactHistory(ctx, name, rule, now); err != nil {
+			return fmt.Errorf("failed to compact history of %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// historyNames - method for getting the metric names that have history
+func (d *SQLiteStorage) historyNames(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sqliteGetHistoryNamesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// compactHistory - method for applying a retention rule to one metric name
+func (d *SQLiteStorage) compactHistory(ctx context.Context, name string, rule RetentionRule, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		rawCutoff := rule.rawCutoff(now).UnixNano()
+
+		if rule.RollupStep > 0 {
+			step := time.Duration(rule.RollupStep)
+			if _, err := tx.ExecContext(ctx, sqliteRollupHistoryQuery,
+				name, int64(step/time.Second), step.Nanoseconds(), rawCutoff); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, sqliteDeleteRawHistoryQuery, name, rawCutoff); err != nil {
+			return err
+		}
+
+		rollupCutoff := now
+		if rule.RollupStep > 0 {
+			rollupCutoff = rule.rollupCutoff(now)
+		}
+		_, err := tx.ExecContext(ctx, sqliteDeleteRollupHistoryQuery, name, rollupCutoff.UnixNano())
+		return err
+	})
+}
+
+// Ping - method for pinging the database
+func (d *SQLiteStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return d.db.PingContext(ctx)
+}
+
+// inTx - method for running fn in a transaction
+// the transaction is committed if fn succeeds and rolled back otherwise
+// fn must use the transaction only, the only connection is taken by it
+// if error, return error
+func (d *SQLiteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqliteStates - method for reading the stored series of the batch within a transaction
+// returns the states by historyKey, a missing series has none
+// if error, return error
+func sqliteStates(ctx context.Context, tx *sql.Tx, metrics []models.Metrics) (map[string]seriesState, error) {
+	keys, series := batchKeys(metrics)
+	states := make(map[string]seriesState, len(keys))
+
+	for _, key := range keys {
+		m := series[key]
+		labels, err := labelsJSON(m.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		var state seriesState
+		var raw *string
+		err = tx.QueryRowContext(ctx, sqliteGetSeriesQuery, m.ID, labels, m.MType).Scan(&state.gauge, &state.counter, &raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metric %q: %w", m.ID, err)
+		}
+		if raw != nil {
+			state.histogram = &models.HistogramValue{}
+			if err := json.Unmarshal([]byte(*raw), state.histogram); err != nil {
+				return nil, fmt.Errorf("failed to decode histogram %q: %w", m.ID, err)
+			}
+		}
+		states[key] = state
+	}
+
+	return states, nil
+}
+
+// setSQLiteGauge - method for upserting a gauge and recording its sample within a transaction
+func setSQLiteGauge(ctx context.Context, tx *sql.Tx, name string, value float64, now time.Time) error {
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.QueryRowContext(ctx, sqliteInsertGaugeQuery, id, labels, value, now.UnixNano()).Scan(&value); err != nil {
+		return fmt.Errorf("failed to set gauge %q: %w", name, err)
+	}
+	if _, err := tx.ExecContext(ctx, sqliteInsertHistoryQuery,
+		id, labels, models.Gauge, now.UnixNano(), value, value, value, value); err != nil {
+		return fmt.Errorf("failed to record gauge %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// setSQLiteCounter - method for upserting a counter and recording its sample within a transaction
+// the sample value is the new total, its min, max and sum are the delta
+func setSQLiteCounter(ctx context.Context, tx *sql.Tx, name string, delta int64, now time.Time) error {
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	if err := tx.QueryRowContext(ctx, sqliteInsertCounterQuery, id, labels, delta, now.UnixNano()).Scan(&total); err != nil {
+		return fmt.Errorf("failed to set counter %q: %w", name, err)
+	}
+	if _, err := tx.ExecContext(ctx, sqliteInsertHistoryQuery,
+		id, labels, models.Counter, now.UnixNano(), total, delta, delta, delta); err != nil {
+		return fmt.Errorf("failed to record counter %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// mergeSQLiteHistogram - method for merging a histogram within a transaction
+// insert the histogram if it doesn't exist yet, otherwise merge the buckets and write it back
+// the transaction holds the database lock, so the row doesn't need one
+func mergeSQLiteHistogram(ctx context.Context, tx *sql.Tx, name string, value models.HistogramValue, now time.Time) error {
+	if err := value.Validate(); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	id, labels, err := splitSeries(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, sqliteInsertHistogramQuery, id, labels, string(data), now.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to insert histogram %q: %w", name, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return nil
+	}
+
+	var raw string
+	if err := tx.QueryRowContext(ctx, sqliteGetHistogramQuery, id, labels).Scan(&raw); err != nil {
+		return fmt.Errorf("failed to read histogram %q: %w", name, err)
+	}
+
+	var current models.HistogramValue
+	if err := json.Unmarshal([]byte(raw), &current); err != nil {
+		return fmt.Errorf("failed to decode histogram %q: %w", name, err)
+	}
+	if err := current.Merge(value); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	data, err = json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteUpdateHistogramQuery, string(data), now.UnixNano(), id, labels); err != nil {
+		return fmt.Errorf("failed to update histogram %q: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makimaki04/go-metrics-agent.git/internal/migrations"
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newSQLiteStorage(t *testing.T) Repository {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "metrics.db")
+	require.NoError(t, migrations.RunMigration(dsn))
+
+	db, err := OpenSQLite(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return NewSQLiteStorage(db, zap.NewNop())
+}
+
+func TestSQLiteStorage_SetGet(t *testing.T) {
+	ctx := context.Background()
+	storage := newSQLiteStorage(t)
+
+	require.NoError(t, storage.SetGauge(ctx, "load", 1.5))
+	require.NoError(t, storage.SetGauge(ctx, "load", 2.5))
+	require.NoError(t, storage.SetCounter(ctx, `requests{path="/"}`, 2))
+	require.NoError(t, storage.SetCounter(ctx, `requests{path="/"}`, 3))
+	require.NoError(t, storage.SetCounter(ctx, `requests{path="/value"}`, 1))
+
+	gauge, ok := storage.GetGauge(ctx, "load")
+	require.True(t, ok)
+	assert.Equal(t, 2.5, gauge)
+
+	counter, ok := storage.GetCounter(ctx, `requests{path="/"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(5), counter)
+
+	_, ok = storage.GetCounter(ctx, "missing")
+	assert.False(t, ok)
+
+	counters, err := storage.GetAllCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{`requests{path="/"}`: 5, `requests{path="/value"}`: 1}, counters)
+
+	h := models.NewHistogram([]float64{1})
+	h.Observe(0.5)
+	require.NoError(t, storage.SetHistogram(ctx, "latency", *h))
+	require.NoError(t, storage.SetHistogram(ctx, "latency", *h))
+	histogram, ok := storage.GetHistogram(ctx, "latency")
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), histogram.Count)
+
+	require.NoError(t, storage.Ping(ctx))
+}
+
+func TestSQLiteStorage_GetTimes(t *testing.T) {
+	ctx := context.Background()
+	storage := newSQLiteStorage(t)
+	reader, ok := storage.(TimesReader)
+	require.True(t, ok)
+
+	require.NoError(t, storage.SetCounter(ctx, "requests", 1))
+	created, ok := reader.GetTimes(ctx, models.Counter, "requests")
+	require.True(t, ok)
+	assert.Equal(t, created.CreatedAt, created.LastUpdated)
+
+	// an update moves the last update time only
+	time.Sleep(time.Millisecond)
+	require.NoError(t, storage.SetCounter(ctx, "requests", 1))
+	updated, ok := reader.GetTimes(ctx, models.Counter, "requests")
+	require.True(t, ok)
+	assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+	assert.True(t, updated.LastUpdated.After(created.LastUpdated))
+
+	_, ok = reader.GetTimes(ctx, models.Gauge, "requests")
+	assert.False(t, ok)
+}
+
+func TestSQLiteStorage_SetMetricBatch(t *testing.T) {
+	ctx := context.Background()
+	storage := newSQLiteStorage(t)
+
+	delta := int64(3)
+	value := 1.5
+	require.NoError(t, storage.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "load", MType: models.Gauge, Value: &value},
+	}))
+	counter, _ := storage.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(6), counter)
+
+	// a broken metric rolls back the whole batch
+	assert.Error(t, storage.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "load", MType: models.Gauge},
+	}))
+	counter, _ = storage.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(6), counter)
+
+	// a total below the stored counter is a reset and is added whole
+	total := int64(2)
+	require.NoError(t, storage.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: models.Counter, Total: &total},
+	}))
+	counter, _ = storage.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(8), counter)
+}
+
+func TestSQLiteStorage_History(t *testing.T) {
+	ctx := context.Background()
+	storage := newSQLiteStorage(t)
+
+	require.NoError(t, storage.SetCounter(ctx, "requests", 2))
+	require.NoError(t, storage.SetCounter(ctx, "requests", 3))
+
+	from := time.Now().Add(-time.Minute)
+	samples, err := storage.GetHistory(ctx, models.Counter, "requests", from, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 5.0, samples[1].Value)
+	assert.Equal(t, 3.0, samples[1].Sum)
+
+	// a day later the samples are rolled up into one daily bucket
+	now := time.Now().Add(time.Hour)
+	rules := []RetentionRule{{
+		Pattern:    "*",
+		Raw:        Duration(time.Minute),
+		RollupStep: Duration(24 * time.Hour),
+		Rollup:     Duration(72 * time.Hour),
+	}}
+	require.NoError(t, storage.ApplyRetention(ctx, rules, now.Add(24*time.Hour)))
+
+	samples, err = storage.GetHistory(ctx, models.Counter, "requests", from.Add(-48*time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 5.0, samples[0].Value)
+	assert.Equal(t, 5.0, samples[0].Sum)
+	assert.Equal(t, int64(2), samples[0].Count)
+}
+
+func TestOpenSQLite_Pragmas(t *testing.T) {
+	pragma := func(dsn string) int {
+		db, err := OpenSQLite(dsn)
+		require.NoError(t, err)
+		defer db.Close()
+
+		var synchronous int
+		require.NoError(t, db.QueryRow("PRAGMA synchronous").Scan(&synchronous))
+		return synchronous
+	}
+
+	// FULL by default, the dsn can lower it to NORMAL
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	assert.Equal(t, 2, pragma("sqlite://"+path))
+	assert.Equal(t, 1, pragma("sqlite://"+path+"?_pragma=synchronous(NORMAL)"))
+}
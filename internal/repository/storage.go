@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
 )
@@ -11,23 +12,34 @@ import (
 //MemStorage - struct for the memory storage
 // generate:reset
 type MemStorage struct {
-	gauges   map[string]float64
-	counters map[string]int64
-	mu       sync.RWMutex
+	gauges      map[string]float64
+	counters    map[string]int64
+	histograms  map[string]models.HistogramValue
+	history     map[string]*sampleRing
+	rollups     map[string][]models.Sample
+	historySize int
+	mu          sync.RWMutex
 }
 
 //SetGauge - method for setting a gauge
 //set the value of the gauge
 //if error, return error
 //if success, return nil
-func (m *MemStorage) SetGauge(name string, value float64) error {
+func (m *MemStorage) SetGauge(ctx context.Context, name string, value float64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.gauges[name] = value
+	m.setGauge(name, value)
 	return nil
 }
 
+//setGauge - method for setting a gauge
+//must be called with the write lock held
+func (m *MemStorage) setGauge(name string, value float64) {
+	m.gauges[name] = value
+	m.record(models.Gauge, name, models.NewGaugeSample(time.Now(), value))
+}
+
 //GetGauge - method for getting a gauge
 //get the value of the gauge
 //if error, return error
@@ -44,7 +56,7 @@ func (m *MemStorage) GetGauge(ctx context.Context, name string) (float64, bool)
 //get all the gauges
 //if error, return error
 //if success, return the value of the gauges
-func (m *MemStorage) GetAllGauges() (map[string]float64, error) {
+func (m *MemStorage) GetAllGauges(ctx context.Context) (map[string]float64, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -59,19 +71,26 @@ func (m *MemStorage) GetAllGauges() (map[string]float64, error) {
 //set the value of the counter
 //if error, return error
 //if success, return nil
-func (m *MemStorage) SetCounter(name string, value int64) error {
+func (m *MemStorage) SetCounter(ctx context.Context, name string, value int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.counters[name] += value
+	m.setCounter(name, value)
 	return nil
 }
 
+//setCounter - method for adding to a counter
+//must be called with the write lock held
+func (m *MemStorage) setCounter(name string, value int64) {
+	m.counters[name] += value
+	m.record(models.Counter, name, models.NewCounterSample(time.Now(), m.counters[name], value))
+}
+
 //GetCounter - method for getting a counter
 //get the value of the counter
 //if error, return error
 //if success, return the value of the counter
-func (m *MemStorage) GetCounter(name string) (int64, bool) {
+func (m *MemStorage) GetCounter(ctx context.Context, name string) (int64, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -83,7 +102,7 @@ func (m *MemStorage) GetCounter(name string) (int64, bool) {
 //get all the counters
 //if error, return error
 //if success, return the value of the counters
-func (m *MemStorage) GetAllCounters() (map[string]int64, error) {
+func (m *MemStorage) GetAllCounters(ctx context.Context) (map[string]int64, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -94,36 +113,202 @@ func (m *MemStorage) GetAllCounters() (map[string]int64, error) {
 	return copy, nil
 }
 
+//SetHistogram - method for setting a histogram
+//merge the buckets, sum and count into the stored histogram
+//if bounds differ from the stored ones, return error
+//if success, return nil
+func (m *MemStorage) SetHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	if err := value.Validate(); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setHistogram(name, value)
+}
+
+//setHistogram - method for merging a histogram into the stored one
+//must be called with the write lock held
+//if bounds differ from the stored ones, return error
+func (m *MemStorage) setHistogram(name string, value models.HistogramValue) error {
+	current, ok := m.histograms[name]
+	if !ok {
+		m.histograms[name] = value.Clone()
+		return nil
+	}
+
+	merged := current.Clone()
+	if err := merged.Merge(value); err != nil {
+		return fmt.Errorf("histogram %s: %w", name, err)
+	}
+	m.histograms[name] = merged
+
+	return nil
+}
+
+//GetHistogram - method for getting a histogram
+//get the copy of the histogram
+//if not found, return false
+//if success, return the histogram and true
+func (m *MemStorage) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.histograms[name]
+	if !ok {
+		return models.HistogramValue{}, false
+	}
+	return value.Clone(), true
+}
+
+//GetAllHistograms - method for getting all histograms
+//get all the histograms
+//if error, return error
+//if success, return the copies of the histograms
+func (m *MemStorage) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	copy := make(map[string]models.HistogramValue)
+	for k, v := range m.histograms {
+		copy[k] = v.Clone()
+	}
+	return copy, nil
+}
+
 //SetMetricBatch - method for setting a batch of metrics
-//set the value of the metrics
+//the batch is resolved and applied under one lock, a broken metric leaves the storage unchanged
 //if error, return error
 //if success, return nil
-func (m *MemStorage) SetMetricBatch(metrics []models.Metrics) error {
-	for _, metric := range metrics {
+func (m *MemStorage) SetMetricBatch(ctx context.Context, metrics []models.Metrics) error {
+	_, err := m.SetMetricBatchChanges(ctx, metrics)
+	return err
+}
+
+//SetMetricBatchChanges - method for setting a batch of metrics
+//returns the changes of the metrics, read under the lock of the write
+//if error, return error
+func (m *MemStorage) SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys, _ := batchKeys(metrics)
+	states := make(map[string]seriesState, len(keys))
+	for _, key := range keys {
+		states[key] = m.state(key)
+	}
+
+	resolved, changes, err := resolveBatch(metrics, states)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, metric := range resolved {
 		switch metric.MType {
-		case "gauge":
-			if metric.Value == nil {
-				return fmt.Errorf("gauge %s has no value", metric.ID)
-			}
-			err := m.SetGauge(metric.ID, *metric.Value)
-			if err != nil {
-				return err
-			}
-		case "counter":
-			if metric.Delta == nil {
-				return fmt.Errorf("counter %s has no delta", metric.ID)
-			}
-			err := m.SetCounter(metric.ID, *metric.Delta)
-			if err != nil {
-				return err
+		case models.Gauge:
+			m.setGauge(metric.SeriesKey(), *metric.Value)
+		case models.Counter:
+			m.setCounter(metric.SeriesKey(), *metric.Delta)
+		case models.Histogram:
+			if err := m.setHistogram(metric.SeriesKey(), *metric.Histogram); err != nil {
+				return nil, err
 			}
 		}
 	}
 
+	return changes, nil
+}
+
+//state - method for getting the stored value of a series by its historyKey
+//must be called with the lock held
+func (m *MemStorage) state(key string) seriesState {
+	mType, name := splitHistoryKey(key)
+	switch mType {
+	case models.Gauge:
+		if v, ok := m.gauges[name]; ok {
+			return seriesState{gauge: &v}
+		}
+	case models.Counter:
+		if v, ok := m.counters[name]; ok {
+			return seriesState{counter: &v}
+		}
+	case models.Histogram:
+		if v, ok := m.histograms[name]; ok {
+			h := v.Clone()
+			return seriesState{histogram: &h}
+		}
+	}
+	return seriesState{}
+}
+
+//record - method for recording a sample of the series history
+//must be called with the write lock held
+func (m *MemStorage) record(mType string, name string, sample models.Sample) {
+	key := historyKey(mType, name)
+
+	ring, ok := m.history[key]
+	if !ok {
+		ring = newSampleRing(m.historySize)
+		m.history[key] = ring
+	}
+	ring.push(sample)
+}
+
+//GetHistory - method for getting the history of a series
+//get the samples written between from and to
+//if success, return the samples in time order
+func (m *MemStorage) GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := historyKey(mType, name)
+
+	result := samplesBetween(m.rollups[key], from, to)
+	if ring, ok := m.history[key]; ok {
+		result = append(result, ring.between(from, to)...)
+	}
+	return result, nil
+}
+
+//ApplyRetention - method for enforcing the retention rules
+//raw samples older than the rule allows are rolled up or deleted
+//rollups older than the rule allows are deleted
+//series without a matching rule are kept as is
+func (m *MemStorage) ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, ring := range m.history {
+		_, series := splitHistoryKey(key)
+		name, _, err := models.ParseSeriesKey(series)
+		if err != nil {
+			continue
+		}
+
+		rule, ok := matchRule(rules, name)
+		if !ok {
+			continue
+		}
+
+		expired := ring.dropBefore(rule.rawCutoff(now))
+		if rule.RollupStep <= 0 {
+			delete(m.rollups, key)
+			continue
+		}
+
+		rollups := append(m.rollups[key], models.Downsample(expired, time.Duration(rule.RollupStep))...)
+		cutoff := rule.rollupCutoff(now)
+		for len(rollups) > 0 && rollups[0].Timestamp.Before(cutoff) {
+			rollups = rollups[1:]
+		}
+		m.rollups[key] = rollups
+	}
+
 	return nil
 }
 
 //Ping - method for pinging the database
-func (m *MemStorage) Ping() error {
+func (m *MemStorage) Ping(ctx context.Context) error {
 	return nil
 }
@@ -2,9 +2,13 @@ package repository
 
 import (
 	"context"
+	"math"
 	"testing"
+	"time"
 
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMemStorage_SetGauge(t *testing.T) {
@@ -35,7 +39,7 @@ func TestMemStorage_SetGauge(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			storage.SetGauge(tt.input.name, tt.input.value)
+			storage.SetGauge(context.Background(), tt.input.name, tt.input.value)
 			value, ok := storage.GetGauge(context.Background(),tt.input.name)
 			assert.True(t, ok, "Gauge value should exist")
 			assert.Equal(t, tt.input.value, value)
@@ -92,11 +96,11 @@ func TestMemStorage_SetCounter(t *testing.T) {
 
 			var counterName string
 			for _, c := range tt.input {
-				storage.SetCounter(c.name, c.value)
+				storage.SetCounter(context.Background(), c.name, c.value)
 				counterName = c.name
 			}
 
-			value, ok := storage.GetCounter(counterName)
+			value, ok := storage.GetCounter(context.Background(), counterName)
 			assert.True(t, ok, "counter should exist")
 			assert.Equal(t, tt.want, value)
 		})
@@ -134,7 +138,7 @@ func TestMemStorage_GetGauge(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := NewStorage()
-			storage.SetGauge(tt.input.name, tt.input.value)
+			storage.SetGauge(context.Background(), tt.input.name, tt.input.value)
 			value, ok := storage.GetGauge(context.Background(), tt.key)
 			assert.Equal(t, tt.wantOk, ok)
 			assert.Equal(t, tt.wantValue, value)
@@ -173,8 +177,8 @@ func TestMemStorage_GetCounter(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := NewStorage()
-			storage.SetCounter(tt.input.name, tt.input.value)
-			value, ok := storage.GetCounter(tt.key)
+			storage.SetCounter(context.Background(), tt.input.name, tt.input.value)
+			value, ok := storage.GetCounter(context.Background(), tt.key)
 			assert.Equal(t, tt.wantOk, ok)
 			assert.Equal(t, tt.wantValue, value)
 		})
@@ -205,8 +209,8 @@ func TestMemStorage_GetAllGauges(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := NewStorage()
-			storage.SetGauge(tt.mock.name, tt.mock.value)
-			gauges, err := storage.GetAllGauges()
+			storage.SetGauge(context.Background(), tt.mock.name, tt.mock.value)
+			gauges, err := storage.GetAllGauges(context.Background())
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want, gauges)
 		})
@@ -237,10 +241,308 @@ func TestMemStorage_GetAllCounters(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := NewStorage()
-			storage.SetCounter(tt.mock.name, tt.mock.value)
-			gauges, err := storage.GetAllCounters()
+			storage.SetCounter(context.Background(), tt.mock.name, tt.mock.value)
+			gauges, err := storage.GetAllCounters(context.Background())
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want, gauges)
 		})
 	}
 }
+
+func TestMemStorage_SetHistogram(t *testing.T) {
+	bounds := []float64{1, 5}
+
+	tests := []struct {
+		name    string
+		input   []models.HistogramValue
+		want    models.HistogramValue
+		wantErr bool
+	}{
+		{
+			name: "Single histogram",
+			input: []models.HistogramValue{
+				{Bounds: bounds, Counts: []uint64{1, 2, 0}, Sum: 7, Count: 3},
+			},
+			want: models.HistogramValue{Bounds: bounds, Counts: []uint64{1, 2, 0}, Sum: 7, Count: 3},
+		},
+		{
+			name: "Merge histograms",
+			input: []models.HistogramValue{
+				{Bounds: bounds, Counts: []uint64{1, 2, 0}, Sum: 7, Count: 3},
+				{Bounds: bounds, Counts: []uint64{0, 1, 1}, Sum: 13, Count: 2},
+			},
+			want: models.HistogramValue{Bounds: bounds, Counts: []uint64{1, 3, 1}, Sum: 20, Count: 5},
+		},
+		{
+			name: "Bounds mismatch",
+			input: []models.HistogramValue{
+				{Bounds: bounds, Counts: []uint64{1, 0, 0}, Sum: 1, Count: 1},
+				{Bounds: []float64{2}, Counts: []uint64{1, 0}, Sum: 1, Count: 1},
+			},
+			want:    models.HistogramValue{Bounds: bounds, Counts: []uint64{1, 0, 0}, Sum: 1, Count: 1},
+			wantErr: true,
+		},
+		{
+			name: "Count doesn't match buckets",
+			input: []models.HistogramValue{
+				{Bounds: bounds, Counts: []uint64{1, 0, 0}, Sum: 1, Count: 2},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewStorage()
+
+			var err error
+			for _, h := range tt.input {
+				if e := storage.SetHistogram(context.Background(), "latency", h); e != nil {
+					err = e
+				}
+			}
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			value, ok := storage.GetHistogram(context.Background(), "latency")
+			if tt.want.Counts == nil {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok, "histogram should exist")
+			assert.Equal(t, tt.want, value)
+		})
+	}
+}
+
+func TestMemStorage_GetHistory(t *testing.T) {
+	tests := []struct {
+		name        string
+		historySize int
+		gauges      []float64
+		want        []float64
+	}{
+		{
+			name:        "All samples kept",
+			historySize: 5,
+			gauges:      []float64{1, 2, 3},
+			want:        []float64{1, 2, 3},
+		},
+		{
+			name:        "Oldest samples dropped",
+			historySize: 2,
+			gauges:      []float64{1, 2, 3, 4},
+			want:        []float64{3, 4},
+		},
+		{
+			name:        "History disabled",
+			historySize: 0,
+			gauges:      []float64{1, 2},
+			want:        []float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewStorageWithHistory(tt.historySize)
+			from := time.Now().Add(-time.Minute)
+
+			for _, v := range tt.gauges {
+				storage.SetGauge(context.Background(), "CPU", v)
+			}
+
+			samples, err := storage.GetHistory(context.Background(), models.Gauge, "CPU", from, time.Now())
+			assert.NoError(t, err)
+
+			values := make([]float64, 0, len(samples))
+			for _, s := range samples {
+				values = append(values, s.Value)
+			}
+			assert.Equal(t, tt.want, values)
+		})
+	}
+}
+
+func TestMemStorage_ApplyRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      RetentionRule
+		after     time.Duration
+		wantCount []int64
+	}{
+		{
+			name:      "Raw samples kept",
+			rule:      RetentionRule{Pattern: "CPU*", Raw: Duration(time.Hour)},
+			after:     time.Minute,
+			wantCount: []int64{1, 1, 1},
+		},
+		{
+			name:      "Raw samples deleted",
+			rule:      RetentionRule{Pattern: "CPU*", Raw: Duration(time.Hour)},
+			after:     2 * time.Hour,
+			wantCount: []int64{},
+		},
+		{
+			name: "Raw samples rolled up",
+			rule: RetentionRule{
+				Pattern:    "CPU*",
+				Raw:        Duration(time.Hour),
+				RollupStep: Duration(24 * time.Hour),
+				Rollup:     Duration(30 * 24 * time.Hour),
+			},
+			after:     50 * time.Hour,
+			wantCount: []int64{3},
+		},
+		{
+			name: "Rollups deleted",
+			rule: RetentionRule{
+				Pattern:    "CPU*",
+				Raw:        Duration(time.Hour),
+				RollupStep: Duration(time.Hour),
+				Rollup:     Duration(2 * time.Hour),
+			},
+			after:     5 * time.Hour,
+			wantCount: []int64{},
+		},
+		{
+			name:      "No matching rule",
+			rule:      RetentionRule{Pattern: "Mem*", Raw: Duration(time.Hour)},
+			after:     2 * time.Hour,
+			wantCount: []int64{1, 1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.rule.Validate())
+
+			storage := NewStorage()
+			for _, v := range []float64{1, 2, 3} {
+				storage.SetGauge(context.Background(), `CPU{host="web03"}`, v)
+			}
+
+			now := time.Now().Add(tt.after)
+			err := storage.ApplyRetention(context.Background(), []RetentionRule{tt.rule}, now)
+			require.NoError(t, err)
+
+			samples, err := storage.GetHistory(context.Background(), models.Gauge, `CPU{host="web03"}`, time.Time{}, now)
+			require.NoError(t, err)
+
+			counts := make([]int64, 0, len(samples))
+			for _, s := range samples {
+				counts = append(counts, s.Count)
+			}
+			assert.Equal(t, tt.wantCount, counts)
+		})
+	}
+}
+
+func TestRetentionRule_Validate(t *testing.T) {
+	assert.Error(t, RetentionRule{Pattern: "[", Raw: Duration(time.Hour)}.Validate())
+	assert.Error(t, RetentionRule{Pattern: "*"}.Validate())
+	assert.Error(t, RetentionRule{Pattern: "*", Raw: Duration(time.Hour), RollupStep: Duration(time.Minute)}.Validate())
+}
+
+func TestMemStorage_SetMetricBatch(t *testing.T) {
+	ctx := context.Background()
+	storage := NewStorage()
+
+	require.NoError(t, storage.SetHistogram(ctx, "latency", *models.NewHistogram([]float64{1, 2})))
+
+	value := 1.5
+	nan := math.NaN()
+	metrics := []models.Metrics{
+		{ID: "latency", MType: models.Histogram, Value: &value},
+		{ID: "latency", MType: models.Histogram, Value: &value},
+	}
+	require.NoError(t, storage.SetMetricBatch(ctx, metrics))
+
+	// single values go into the stored bounds
+	h, ok := storage.GetHistogram(ctx, "latency")
+	require.True(t, ok)
+	assert.Equal(t, []float64{1, 2}, h.Bounds)
+	assert.Equal(t, []uint64{0, 2, 0}, h.Counts)
+
+	// the batch of the caller isn't changed
+	assert.Nil(t, metrics[0].Histogram)
+	assert.Equal(t, &value, metrics[0].Value)
+
+	// a broken metric leaves the whole batch unapplied
+	delta := int64(1)
+	assert.ErrorIs(t, storage.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "latency", MType: models.Histogram, Value: &nan},
+	}), models.ErrNotFinite)
+	_, ok = storage.GetCounter(ctx, "requests")
+	assert.False(t, ok)
+
+	// totals move the counter, a total below it is a reset and is added whole
+	require.NoError(t, storage.SetCounter(ctx, "jobs", 10))
+	total, reset := int64(12), int64(3)
+	require.NoError(t, storage.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "jobs", MType: models.Counter, Total: &total},
+		{ID: "jobs", MType: models.Counter, Total: &reset},
+	}))
+	counter, _ := storage.GetCounter(ctx, "jobs")
+	assert.Equal(t, int64(15), counter)
+
+	// gauge deltas move the stored gauge
+	require.NoError(t, storage.SetGauge(ctx, "queue", 4))
+	down := -1.5
+	require.NoError(t, storage.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "queue", MType: models.Gauge, GaugeDelta: &down},
+		{ID: "queue", MType: models.Gauge, GaugeDelta: &down},
+	}))
+	gauge, _ := storage.GetGauge(ctx, "queue")
+	assert.Equal(t, 1.0, gauge)
+}
+
+func TestSetMetricBatchChanges(t *testing.T) {
+	storages := map[string]func(t *testing.T) Repository{
+		"memory": func(t *testing.T) Repository { return NewStorage() },
+		"sqlite": newSQLiteStorage,
+		"wal":    func(t *testing.T) Repository { return openWAL(t, t.TempDir(), WALConfig{}) },
+	}
+
+	for name, open := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			storage := open(t)
+			writer, ok := storage.(ChangeWriter)
+			require.True(t, ok)
+
+			require.NoError(t, storage.SetGauge(ctx, "load", 1.5))
+			require.NoError(t, storage.SetCounter(ctx, "requests", 10))
+
+			gauge, delta, total := 2.5, int64(3), int64(4)
+			changes, err := writer.SetMetricBatchChanges(ctx, []models.Metrics{
+				{ID: "load", MType: models.Gauge, Value: &gauge},
+				{ID: "requests", MType: models.Counter, Delta: &delta},
+				{ID: "requests", MType: models.Counter, Delta: &delta},
+				{ID: "errors", MType: models.Counter, Delta: &delta},
+				// a total below the counter is a reset and is added whole
+				{ID: "requests", MType: models.Counter, Total: &total},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []models.MetricChange{
+				{ID: "load", MType: models.Gauge, OldValue: ptr(1.5), NewValue: ptr(2.5)},
+				{ID: "requests", MType: models.Counter, OldTotal: ptr(int64(10)), NewTotal: ptr(int64(13))},
+				{ID: "requests", MType: models.Counter, OldTotal: ptr(int64(13)), NewTotal: ptr(int64(16))},
+				{ID: "errors", MType: models.Counter, NewTotal: ptr(int64(3))},
+				{ID: "requests", MType: models.Counter, OldTotal: ptr(int64(16)), NewTotal: ptr(int64(20))},
+			}, changes)
+
+			counter, _ := storage.GetCounter(ctx, "requests")
+			assert.Equal(t, int64(20), counter)
+
+			// a broken batch returns no changes
+			changes, err = writer.SetMetricBatchChanges(ctx, []models.Metrics{{ID: "load", MType: models.Gauge}})
+			assert.Error(t, err)
+			assert.Nil(t, changes)
+		})
+	}
+}
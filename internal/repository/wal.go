@@ -0,0 +1,541 @@
+package repository
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"go.uber.org/zap"
+)
+
+// walHeaderSize - size of the record header: the payload length and its crc32
+const walHeaderSize = 8
+
+// walOp - operations of the write-ahead log records
+const (
+	walGauge     = "gauge"
+	walCounter   = "counter"
+	walHistogram = "histogram"
+	walBatch     = "batch"
+)
+
+// ErrWALCorrupted - a record of the write-ahead log can't be read and isn't the torn last record
+var ErrWALCorrupted = errors.New("write-ahead log is corrupted")
+
+// walTable - crc32 table of the record checksums
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WALConfig - struct for the write-ahead log settings
+// Dir - directory of the log segments
+// SegmentSize - size in bytes a segment is rotated at, a full segment triggers a checkpoint
+// Fsync - fsync every record before the update is acknowledged
+// Restore - rebuild the metrics from the snapshot and the log, otherwise both are discarded
+type WALConfig struct {
+	Dir         string
+	SegmentSize int64
+	Fsync       bool
+	Restore     bool
+}
+
+// walRecord - struct for a record of the write-ahead log
+// LSN - sequence number of the record, the first record is 1
+type walRecord struct {
+	LSN       uint64                 `json:"lsn"`
+	Op        string                 `json:"op"`
+	Name      string                 `json:"name,omitempty"`
+	Value     *float64               `json:"value,omitempty"`
+	Delta     *int64                 `json:"delta,omitempty"`
+	Histogram *models.HistogramValue `json:"histogram,omitempty"`
+	Metrics   []models.Metrics       `json:"metrics,omitempty"`
+}
+
+// walSegment - struct for a segment file found in the log directory
+// Start - LSN of the first record of the segment
+type walSegment struct {
+	Path  string
+	Start uint64
+}
+
+// WALStorage - struct for the repository persisting every update to a segmented write-ahead log
+// a record is appended, and fsynced if configured, before the update is applied and acknowledged
+// a checkpoint writes a snapshot with the LSN of its last record and deletes the segments it covers,
+// on startup the snapshot is restored and the newer records are replayed
+// a torn last record, left by a crash during an append, is cut off
+// the history isn't logged, the replayed updates record their samples at the replay time
+type WALStorage struct {
+	storage Repository
+	file    *SnapshotFile
+	cfg     WALConfig
+	logger  *zap.Logger
+
+	mu           sync.Mutex
+	segment      *os.File
+	segmentStart uint64
+	segmentSize  int64
+	lsn          uint64
+	err          error
+
+	checkpointMu sync.Mutex
+}
+
+// SetGauge - method for logging and setting a gauge
+// if error, return error
+func (s *WALStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	return s.log(ctx, walRecord{Op: walGauge, Name: name, Value: &value})
+}
+
+// SetCounter - method for logging and setting a counter
+// if error, return error
+func (s *WALStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	return s.log(ctx, walRecord{Op: walCounter, Name: name, Delta: &value})
+}
+
+// GetGauge - method for getting a gauge
+func (s *WALStorage) GetGauge(ctx context.Context, name string) (float64, bool) {
+	return s.storage.GetGauge(ctx, name)
+}
+
+// GetCounter - method for getting a counter
+func (s *WALStorage) GetCounter(ctx context.Context, name string) (int64, bool) {
+	return s.storage.GetCounter(ctx, name)
+}
+
+// GetAllGauges - method for getting all gauges
+func (s *WALStorage) GetAllGauges(ctx context.Context) (map[string]float64, error) {
+	return s.storage.GetAllGauges(ctx)
+}
+
+// GetAllCounters - method for getting all counters
+func (s *WALStorage) GetAllCounters(ctx context.Context) (map[string]int64, error) {
+	return s.storage.GetAllCounters(ctx)
+}
+
+// SetHistogram - method for logging and merging a histogram
+// if error, return error
+func (s *WALStorage) SetHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	return s.log(ctx, walRecord{Op: walHistogram, Name: name, Histogram: &value})
+}
+
+// GetHistogram - method for getting a histogram
+func (s *WALStorage) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	return s.storage.GetHistogram(ctx, name)
+}
+
+// GetAllHistograms - method for getting all histograms
+func (s *WALStorage) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	return s.storage.GetAllHistograms(ctx)
+}
+
+// SetMetricBatch - method for logging a batch of metrics as one record and setting it
+// the batch is resolved against the storage under the log lock first,
+// so the record holds the updates as they are applied and a broken batch isn't logged
+// if error, return error
+func (s *WALStorage) SetMetricBatch(ctx context.Context, metrics []models.Metrics) error {
+	_, err := s.SetMetricBatchChanges(ctx, metrics)
+	return err
+}
+
+// SetMetricBatchChanges - method for logging and setting a batch of metrics
+// returns the changes of the metrics, the writes are serialized by the log lock
+// so the states read to resolve the batch are the ones it is applied to
+// if error, return error
+func (s *WALStorage) SetMetricBatchChanges(ctx context.Context, metrics []models.Metrics) ([]models.MetricChange, error) {
+	var changes []models.MetricChange
+	err := s.write(ctx, func() (walRecord, error) {
+		resolved, batchChanges, err := resolveBatch(metrics, storageStates(ctx, s.storage, metrics))
+		if err != nil {
+			return walRecord{}, err
+		}
+		changes = batchChanges
+		return walRecord{Op: walBatch, Metrics: resolved}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetHistory - method for getting the samples of a gauge or counter series
+func (s *WALStorage) GetHistory(ctx context.Context, mType string, name string, from, to time.Time) ([]models.Sample, error) {
+	return s.storage.GetHistory(ctx, mType, name, from, to)
+}
+
+// ApplyRetention - method for rolling up and deleting old history samples
+// the history isn't logged, so nothing is appended
+func (s *WALStorage) ApplyRetention(ctx context.Context, rules []RetentionRule, now time.Time) error {
+	return s.storage.ApplyRetention(ctx, rules, now)
+}
+
+// Ping - method for pinging the storage
+// if a failed append left the log unusable, return its error
+func (s *WALStorage) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.storage.Ping(ctx)
+}
+
+// Checkpoint - method for writing a snapshot of the storage and deleting the segments it covers
+// the snapshot is taken and the segment rotated under the lock, the file is written without it
+// if error, return error
+func (s *WALStorage) Checkpoint(ctx context.Context) error {
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	snapshot, err := TakeSnapshot(ctx, s.storage)
+	if err == nil && s.segmentSize > 0 {
+		err = s.rotate()
+	}
+	snapshot.LSN = s.lsn
+	start := s.segmentStart
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("couldn't checkpoint write-ahead log: %w", err)
+	}
+
+	if err := s.file.Write(snapshot); err != nil {
+		return fmt.Errorf("couldn't checkpoint write-ahead log: %w", err)
+	}
+
+	segments, err := listSegments(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("couldn't checkpoint write-ahead log: %w", err)
+	}
+	for _, segment := range segments {
+		if segment.Start >= start {
+			break
+		}
+		if err := os.Remove(segment.Path); err != nil {
+			return fmt.Errorf("couldn't delete write-ahead log segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close - method for closing the active segment
+// the caller is expected to checkpoint first, otherwise the records are replayed on the next start
+// if error, return error
+func (s *WALStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.segment == nil {
+		return nil
+	}
+	err := s.segment.Close()
+	s.segment = nil
+	if s.err == nil {
+		s.err = errors.New("write-ahead log is closed")
+	}
+	return err
+}
+
+// log - method for appending the record and applying it to the storage
+// if error, return error
+func (s *WALStorage) log(ctx context.Context, record walRecord) error {
+	return s.write(ctx, func() (walRecord, error) {
+		return record, nil
+	})
+}
+
+// write - method for building a record under the lock, appending it and applying it to the storage
+// a full segment is checkpointed after the update, a failed checkpoint is only logged
+// because the update is already durable
+// if error, return error
+func (s *WALStorage) write(ctx context.Context, build func() (walRecord, error)) error {
+	s.mu.Lock()
+	record, err := build()
+	var rotate bool
+	if err == nil {
+		rotate, err = s.append(record)
+	}
+	if err == nil {
+		err = applyRecord(ctx, s.storage, record)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if rotate {
+		if err := s.Checkpoint(ctx); err != nil {
+			s.logger.Error("Failed to checkpoint write-ahead log", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// append - method for writing a record to the active segment
+// returns true if the segment is full
+// a failed write is cut off, if that fails too the log refuses all later appends,
+// so a replay never meets a broken record in the middle of a segment
+// must be called with the lock held
+// if error, return error
+func (s *WALStorage) append(record walRecord) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+
+	record.LSN = s.lsn + 1
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("couldn't marshal write-ahead log record: %w", err)
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, walTable))
+	copy(frame[walHeaderSize:], payload)
+
+	_, err = s.segment.Write(frame)
+	if err == nil && s.cfg.Fsync {
+		err = s.segment.Sync()
+	}
+	if err != nil {
+		if terr := s.segment.Truncate(s.segmentSize); terr != nil {
+			s.err = fmt.Errorf("write-ahead log is unusable after a failed append: %w", errors.Join(err, terr))
+		}
+		return false, fmt.Errorf("couldn't append write-ahead log record: %w", err)
+	}
+
+	s.lsn = record.LSN
+	s.segmentSize += int64(len(frame))
+	return s.cfg.SegmentSize > 0 && s.segmentSize >= s.cfg.SegmentSize, nil
+}
+
+// rotate - method for closing the active segment and starting the next one
+// must be called with the lock held
+// if error, return error
+func (s *WALStorage) rotate() error {
+	if err := s.segment.Sync(); err != nil {
+		return err
+	}
+	if err := s.segment.Close(); err != nil {
+		return err
+	}
+
+	segment, err := createSegment(s.cfg.Dir, s.lsn+1)
+	if err != nil {
+		s.err = fmt.Errorf("write-ahead log has no active segment: %w", err)
+		return err
+	}
+	s.segment, s.segmentStart, s.segmentSize = segment, s.lsn+1, 0
+	return nil
+}
+
+// open - method for rebuilding the storage from the snapshot and the log and opening the active segment
+// if error, return error
+func (s *WALStorage) open(ctx context.Context) error {
+	if err := os.MkdirAll(s.cfg.Dir, 0755); err != nil {
+		return fmt.Errorf("couldn't create write-ahead log directory: %w", err)
+	}
+
+	segments, err := listSegments(s.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	if !s.cfg.Restore {
+		// the empty snapshot goes first, so a crash can't pair the old snapshot with new records
+		if err := s.file.Write(Snapshot{}); err != nil {
+			return fmt.Errorf("couldn't reset storage file: %w", err)
+		}
+		for _, segment := range segments {
+			if err := os.Remove(segment.Path); err != nil {
+				return fmt.Errorf("couldn't delete write-ahead log segment: %w", err)
+			}
+		}
+		segments = nil
+	} else if err := s.replay(ctx, segments); err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		segment, err := createSegment(s.cfg.Dir, s.lsn+1)
+		if err != nil {
+			return err
+		}
+		s.segment, s.segmentStart, s.segmentSize = segment, s.lsn+1, 0
+		return nil
+	}
+
+	last := segments[len(segments)-1]
+	segment, err := os.OpenFile(last.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("couldn't open write-ahead log segment: %w", err)
+	}
+	info, err := segment.Stat()
+	if err != nil {
+		segment.Close()
+		return fmt.Errorf("couldn't open write-ahead log segment: %w", err)
+	}
+	s.segment, s.segmentStart, s.segmentSize = segment, last.Start, info.Size()
+	return nil
+}
+
+// replay - method for restoring the snapshot and applying the newer records of the segments
+// the records must follow each other without gaps, the last segment is cut at a torn record
+// a record that fails to apply failed the same way when it was logged, so it is skipped
+// if error, return error
+func (s *WALStorage) replay(ctx context.Context, segments []walSegment) error {
+	snapshot, err := s.file.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("couldn't load storage file: %w", err)
+	}
+	if err := snapshot.Restore(ctx, s.storage); err != nil {
+		return fmt.Errorf("couldn't restore storage file: %w", err)
+	}
+	s.lsn = snapshot.LSN
+
+	for i, segment := range segments {
+		size, err := readSegment(segment.Path, func(record walRecord) error {
+			if record.LSN <= s.lsn {
+				return nil
+			}
+			if record.LSN != s.lsn+1 {
+				return fmt.Errorf("%w: expected record %d, got %d in %s", ErrWALCorrupted, s.lsn+1, record.LSN, segment.Path)
+			}
+			applyRecord(ctx, s.storage, record)
+			s.lsn = record.LSN
+			return nil
+		})
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrWALCorrupted) || size < 0 || i < len(segments)-1 {
+			return err
+		}
+
+		s.logger.Warn("Cutting off the torn write-ahead log record",
+			zap.String("path", segment.Path), zap.Int64("offset", size), zap.Error(err))
+		if err := os.Truncate(segment.Path, size); err != nil {
+			return fmt.Errorf("couldn't cut write-ahead log segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// readSegment - method for reading the records of a segment
+// returns the size of the readable part, so a torn record can be cut off,
+// or -1 if the records are readable but fn rejected one
+// if a record is torn or damaged, return ErrWALCorrupted
+// if error, return error
+func readSegment(path string, fn func(record walRecord) error) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1, fmt.Errorf("couldn't read write-ahead log segment: %w", err)
+	}
+
+	var offset int64
+	for rest := data; len(rest) > 0; {
+		if len(rest) < walHeaderSize {
+			return offset, fmt.Errorf("%w: truncated record header in %s", ErrWALCorrupted, path)
+		}
+		length := int(binary.LittleEndian.Uint32(rest[0:4]))
+		if len(rest)-walHeaderSize < length {
+			return offset, fmt.Errorf("%w: truncated record in %s", ErrWALCorrupted, path)
+		}
+		payload := rest[walHeaderSize : walHeaderSize+length]
+		if crc32.Checksum(payload, walTable) != binary.LittleEndian.Uint32(rest[4:8]) {
+			return offset, fmt.Errorf("%w: checksum mismatch in %s", ErrWALCorrupted, path)
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return offset, fmt.Errorf("%w: %v in %s", ErrWALCorrupted, err, path)
+		}
+		if err := fn(record); err != nil {
+			return -1, err
+		}
+
+		offset += int64(walHeaderSize + length)
+		rest = rest[walHeaderSize+length:]
+	}
+	return offset, nil
+}
+
+// applyRecord - method for applying a record to the storage
+// if error, return error
+func applyRecord(ctx context.Context, storage Repository, record walRecord) error {
+	switch record.Op {
+	case walGauge:
+		return storage.SetGauge(ctx, record.Name, *record.Value)
+	case walCounter:
+		return storage.SetCounter(ctx, record.Name, *record.Delta)
+	case walHistogram:
+		return storage.SetHistogram(ctx, record.Name, *record.Histogram)
+	case walBatch:
+		return storage.SetMetricBatch(ctx, record.Metrics)
+	}
+	return fmt.Errorf("unknown write-ahead log operation: %q", record.Op)
+}
+
+// segmentName - method for getting the file name of the segment starting at the LSN
+func segmentName(start uint64) string {
+	return fmt.Sprintf("wal-%020d.log", start)
+}
+
+// createSegment - method for creating an empty segment and syncing the directory
+// if error, return error
+func createSegment(dir string, start uint64) (*os.File, error) {
+	segment, err := os.OpenFile(filepath.Join(dir, segmentName(start)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create write-ahead log segment: %w", err)
+	}
+
+	d, err := os.Open(dir)
+	if err == nil {
+		err = d.Sync()
+		d.Close()
+	}
+	if err != nil {
+		segment.Close()
+		return nil, fmt.Errorf("couldn't sync write-ahead log directory: %w", err)
+	}
+	return segment, nil
+}
+
+// listSegments - method for getting the segments of the directory ordered by their first LSN
+// if error, return error
+func listSegments(dir string) ([]walSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read write-ahead log directory: %w", err)
+	}
+
+	var segments []walSegment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "wal-") || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "wal-"), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{Path: filepath.Join(dir, name), Start: start})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start < segments[j].Start
+	})
+	return segments, nil
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func openWAL(t *testing.T, dir string, cfg WALConfig) *WALStorage {
+	cfg.Dir = filepath.Join(dir, "wal")
+	wal, err := NewWALStorage(context.Background(), NewStorage(), NewSnapshotFile(filepath.Join(dir, "save.json")), cfg, zap.NewNop())
+	require.NoError(t, err)
+	return wal
+}
+
+func TestWALStorage_Replay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	wal := openWAL(t, dir, WALConfig{Fsync: true})
+	require.NoError(t, wal.SetGauge(ctx, "load", 1.5))
+	require.NoError(t, wal.SetCounter(ctx, "requests", 2))
+	require.NoError(t, wal.Checkpoint(ctx))
+
+	delta := int64(3)
+	value := 2.5
+	require.NoError(t, wal.SetMetricBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "load", MType: models.Gauge, Value: &value},
+	}))
+	assert.Error(t, wal.SetMetricBatch(ctx, []models.Metrics{{ID: "load", MType: models.Gauge}}))
+	// no checkpoint, the batch is only in the log
+	require.NoError(t, wal.Close())
+
+	wal = openWAL(t, dir, WALConfig{Fsync: true, Restore: true})
+	counter, _ := wal.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(5), counter)
+	gauge, _ := wal.GetGauge(ctx, "load")
+	assert.Equal(t, 2.5, gauge)
+
+	// the records continue after the replayed ones
+	require.NoError(t, wal.SetCounter(ctx, "requests", 1))
+	require.NoError(t, wal.Close())
+	wal = openWAL(t, dir, WALConfig{Restore: true})
+	counter, _ = wal.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(6), counter)
+	require.NoError(t, wal.Close())
+
+	// without restore the metrics are discarded
+	wal = openWAL(t, dir, WALConfig{})
+	_, ok := wal.GetCounter(ctx, "requests")
+	assert.False(t, ok)
+	require.NoError(t, wal.Close())
+}
+
+func TestWALStorage_TornRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	wal := openWAL(t, dir, WALConfig{})
+	require.NoError(t, wal.SetCounter(ctx, "requests", 1))
+	require.NoError(t, wal.SetCounter(ctx, "requests", 2))
+	require.NoError(t, wal.Close())
+
+	segments, err := listSegments(filepath.Join(dir, "wal"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	info, err := os.Stat(segments[0].Path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segments[0].Path, info.Size()-3))
+
+	wal = openWAL(t, dir, WALConfig{Restore: true})
+	counter, _ := wal.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(1), counter)
+
+	// the torn record is cut off, so the next record is readable
+	require.NoError(t, wal.SetCounter(ctx, "requests", 4))
+	require.NoError(t, wal.Close())
+	wal = openWAL(t, dir, WALConfig{Restore: true})
+	counter, _ = wal.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(5), counter)
+	require.NoError(t, wal.Close())
+}
+
+func TestWALStorage_CorruptedSegment(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	wal := openWAL(t, dir, WALConfig{})
+	require.NoError(t, wal.SetCounter(ctx, "requests", 1))
+	require.NoError(t, wal.SetCounter(ctx, "requests", 2))
+	require.NoError(t, wal.Close())
+
+	// a damaged record is an error unless it is at the end of the last segment
+	segments, err := listSegments(filepath.Join(dir, "wal"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	data, err := os.ReadFile(segments[0].Path)
+	require.NoError(t, err)
+	data[walHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(segments[0].Path, data, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wal", segmentName(3)), nil, 0600))
+
+	_, err = NewWALStorage(ctx, NewStorage(), NewSnapshotFile(filepath.Join(dir, "save.json")),
+		WALConfig{Dir: filepath.Join(dir, "wal"), Restore: true}, zap.NewNop())
+	assert.ErrorIs(t, err, ErrWALCorrupted)
+}
+
+func TestWALStorage_CheckpointTruncates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// every record fills a segment and triggers a checkpoint
+	wal := openWAL(t, dir, WALConfig{SegmentSize: 1})
+	for i := 0; i < 5; i++ {
+		require.NoError(t, wal.SetCounter(ctx, "requests", 1))
+	}
+
+	segments, err := listSegments(filepath.Join(dir, "wal"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.Equal(t, uint64(6), segments[0].Start)
+
+	snapshot, err := NewSnapshotFile(filepath.Join(dir, "save.json")).Load()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), snapshot.LSN)
+	assert.Equal(t, map[string]int64{"requests": 5}, snapshot.Counters)
+	require.NoError(t, wal.Close())
+
+	wal = openWAL(t, dir, WALConfig{Restore: true})
+	counter, _ := wal.GetCounter(ctx, "requests")
+	assert.Equal(t, int64(5), counter)
+	require.NoError(t, wal.Close())
+}
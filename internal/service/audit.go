@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+)
+
+// newAuditEvent - method for building the audit event of a write
+// changes - changes returned by the storage from the write, nil if it doesn't return them
+// the request fields are taken from the context, the client is "unknown" without them
+func newAuditEvent(ctx context.Context, metrics []models.Metrics, changes []observer.MetricChange) observer.AuditEvent {
+	ids := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		ids = append(ids, m.SeriesKey())
+	}
+
+	event := observer.AuditEvent{
+		TimeStamp: int(time.Now().Unix()),
+		Metrics:   ids,
+		IPAddress: "unknown",
+		Changes:   changes,
+	}
+
+	if info, ok := observer.RequestFrom(ctx); ok {
+		if info.IPAddress != "" {
+			event.IPAddress = info.IPAddress
+		}
+		event.Endpoint = info.Endpoint
+		event.Method = info.Method
+		event.RequestID = info.RequestID
+		event.UserAgent = info.UserAgent
+		event.Signed = info.Signed
+		event.Encrypted = info.Encrypted
+		event.Identity = info.Identity
+	}
+
+	return event
+}
@@ -16,6 +16,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// ErrNoHistory - error returned when the history of the metric type isn't kept
+var ErrNoHistory = errors.New("history is not kept for the metric type")
+
 // MetricsService - interface for the metrics service
 // UpdateMetric - method for updating a metric
 // UpdateGauge - method for updating a gauge
@@ -24,21 +27,35 @@ import (
 // UpdateCounter - method for updating a counter
 // GetCounter - method for getting a counter
 // GetAllCounters - method for getting all counters
+// UpdateHistogram - method for updating a histogram
+// GetHistogram - method for getting a histogram
+// GetAllHistograms - method for getting all histograms
 // SetLocalStorage - method for setting the local storage
+// SetRetryPolicy - method for setting the retry schedule of the writes
 // UpdateMetricBatch - method for updating a batch of metrics
+// GetHistory - method for getting the history of a gauge or counter
+// GetTimes - method for getting the write times of a series
 // PingDB - method for pinging the database
 // RegisterObserver - method for registering an observer
+// every method takes the context of the caller, retries stop when it is done
+// only writes are retried, reads fail right away
 type MetricsService interface {
 	UpdateMetric(ctx context.Context, metric models.Metrics) error
-	UpdateGauge(name string, value float64) error
-	GetGauge(ctx context.Context,name string) (float64, bool)
-	GetAllGauges() (map[string]float64, error)
-	UpdateCounter(name string, value int64) error
-	GetCounter(name string) (int64, bool)
-	GetAllCounters() (map[string]int64, error)
+	UpdateGauge(ctx context.Context, name string, value float64) error
+	GetGauge(ctx context.Context, name string) (float64, bool)
+	GetAllGauges(ctx context.Context) (map[string]float64, error)
+	UpdateCounter(ctx context.Context, name string, value int64) error
+	GetCounter(ctx context.Context, name string) (int64, bool)
+	GetAllCounters(ctx context.Context) (map[string]int64, error)
+	UpdateHistogram(ctx context.Context, name string, value models.HistogramValue) error
+	GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool)
+	GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error)
 	SetLocalStorage(storage repository.Repository)
+	SetRetryPolicy(policy RetryPolicy)
 	UpdateMetricBatch(ctx context.Context, metrics []models.Metrics) error
-	PingDB() error
+	GetHistory(ctx context.Context, mType string, name string, from, to time.Time, step time.Duration) ([]models.Sample, error)
+	GetTimes(ctx context.Context, mType string, name string) (repository.MetricTimes, bool)
+	PingDB(ctx context.Context) error
 	RegisterObserver(o observer.Observer)
 }
 
@@ -48,6 +65,7 @@ type Service struct {
 	storage   repository.Repository
 	logger    *zap.Logger
 	observers []observer.Observer
+	retry     RetryPolicy
 }
 
 // NewService - method for creating a new metrics service
@@ -56,6 +74,7 @@ func NewService(storage repository.Repository, logger *zap.Logger) MetricsServic
 	return &Service{
 		storage: storage,
 		logger:  logger,
+		retry:   DefaultRetryPolicy,
 	}
 }
 
@@ -64,64 +83,73 @@ func NewService(storage repository.Repository, logger *zap.Logger) MetricsServic
 // if error, return error
 // if success, return nil
 func (s *Service) UpdateMetric(ctx context.Context, metric models.Metrics) error {
+	if err := models.ValidateSeries(metric.ID, metric.Labels); err != nil {
+		return err
+	}
+
 	switch metric.MType {
 	case models.Counter:
 		if metric.Delta == nil {
-			return fmt.Errorf("metric %q: Delta is nil", metric.ID)
-		}
-		if err := s.UpdateCounter(metric.ID, *metric.Delta); err != nil {
-			return fmt.Errorf("failed to update metric: %w", err)
+			return models.Invalid(fmt.Errorf("metric %q: Delta is nil", metric.ID))
 		}
-
-		s.sendMetricEvent(ctx, metric.ID)
-		return nil
 	case models.Gauge:
 		if metric.Value == nil {
-			return fmt.Errorf("metric %q: Value is nil", metric.ID)
+			return models.Invalid(fmt.Errorf("metric %q: Value is nil", metric.ID))
 		}
-		if err := s.UpdateGauge(metric.ID, *metric.Value); err != nil {
-			return fmt.Errorf("failed to update metric: %w", err)
+	case models.Histogram:
+		if metric.Histogram == nil && metric.Value == nil {
+			return models.Invalid(fmt.Errorf("metric %q: Histogram and Value are nil", metric.ID))
 		}
+	default:
+		return models.Invalid(fmt.Errorf("unknown metric type: %q", metric.MType))
+	}
 
-		s.sendMetricEvent(ctx, metric.ID)
+	// the metric is written as a batch of one, so the storage returns its change from the write,
+	// a single histogram value is put into the stored bounds in the write too
+	metrics := []models.Metrics{metric}
+	changes, err := s.writeBatch(ctx, metrics)
+	if err != nil {
+		return fmt.Errorf("failed to update metric: %w", err)
 	}
 
-	return fmt.Errorf("unknown metric type: %q", metric.MType)
+	s.sendMetricEvent(ctx, metrics, changes)
+	return nil
 }
 
 // sendMetricEvent - method for sending a metric event
-func (s *Service) sendMetricEvent(ctx context.Context, id string) {
-	var ids []string
-
-	ids = append(ids, id)
-
-	event := observer.AuditEvent{
-		TimeStamp: int(time.Now().Unix()),
-		Metrics:   ids,
-		IPAddress: idFromContext(ctx),
-	}
-
-	for _, o := range s.observers {
-		o.Notify(ctx, event)
-	}
+func (s *Service) sendMetricEvent(ctx context.Context, metrics []models.Metrics, changes []observer.MetricChange) {
+	s.notify(ctx, newAuditEvent(ctx, metrics, changes))
 }
 
-// idFromContext - method for getting the id from the context
-func idFromContext(ctx context.Context) string {
-	ip, ok := ctx.Value(observer.ReqIDKey).(string)
-	if !ok {
-		return "unknown"
+// writeBatch - method for writing a batch of metrics with retries
+// the changes are returned by the storage from the write, in the same transaction,
+// only if an observer is active and the storage implements repository.ChangeWriter
+// otherwise the changes are nil
+// if error, return error
+func (s *Service) writeBatch(ctx context.Context, metrics []models.Metrics) ([]observer.MetricChange, error) {
+	writer, ok := s.storage.(repository.ChangeWriter)
+	if !ok || len(s.activeObservers()) == 0 {
+		return nil, withRetry(ctx, s.retry, func() error {
+			return s.storage.SetMetricBatch(ctx, metrics)
+		}, s.logger)
 	}
-	return ip
+
+	var changes []observer.MetricChange
+	err := withRetry(ctx, s.retry, func() error {
+		var err error
+		changes, err = writer.SetMetricBatchChanges(ctx, metrics)
+		return err
+	}, s.logger)
+	return changes, err
 }
 
 // UpdateGauge - method for updating a gauge
 // update the value of the gauge
 // if error, return error
 // if success, return nil
-func (s *Service) UpdateGauge(name string, value float64) error {
-	return withRetry(func() error {
-		return s.storage.SetGauge(name, value)
+func (s *Service) UpdateGauge(ctx context.Context, name string, value float64) error {
+	return withRetry(ctx, s.retry, func() error {
+		return s.storage.SetGauge(ctx, name, value)
 	}, s.logger)
 }
 
@@ -130,33 +158,24 @@ func (s *Service) UpdateGauge(name string, value float64) error {
 // if error, return error
 // if success, return the value of the gauge
 func (s *Service) GetGauge(ctx context.Context, name string) (float64, bool) {
-	value, err := retryValue(func() (float64, error) {
-		v, ok := s.storage.GetGauge(ctx, name)
-		if !ok {
-			return 0, fmt.Errorf("metric %q not found", name)
-		}
-		return v, nil
-	}, s.logger)
-	return value, err == nil
+	return s.storage.GetGauge(ctx, name)
 }
 
 // GetAllGauges - method for getting all gauges
 // get all the gauges
 // if error, return error
 // if success, return the value of the gauges
-func (s *Service) GetAllGauges() (map[string]float64, error) {
-	return retryValue(func() (map[string]float64, error) {
-		return s.storage.GetAllGauges()
-	}, s.logger)
+func (s *Service) GetAllGauges(ctx context.Context) (map[string]float64, error) {
+	return s.storage.GetAllGauges(ctx)
 }
 
 // UpdateCounter - method for updating a counter
 // update the value of the counter
 // if error, return error
 // if success, return nil
-func (s *Service) UpdateCounter(name string, value int64) error {
-	return withRetry(func() error {
-		return s.storage.SetCounter(name, value)
+func (s *Service) UpdateCounter(ctx context.Context, name string, value int64) error {
+	return withRetry(ctx, s.retry, func() error {
+		return s.storage.SetCounter(ctx, name, value)
 	}, s.logger)
 }
 
@@ -164,27 +183,44 @@ func (s *Service) UpdateCounter(name string, value int64) error {
 // get the value of the counter
 // if error, return error
 // if success, return the value of the counter
-func (s *Service) GetCounter(name string) (int64, bool) {
-	value, err := retryValue(func() (int64, error) {
-		v, ok := s.storage.GetCounter(name)
-		if !ok {
-			return 0, fmt.Errorf("counter %q not found", name)
-		}
-		return v, nil
-	}, s.logger)
-	return value, err == nil
+func (s *Service) GetCounter(ctx context.Context, name string) (int64, bool) {
+	return s.storage.GetCounter(ctx, name)
 }
 
 // GetAllCounters - method for getting all counters
 // get all the counters
 // if error, return error
 // if success, return the value of the counters
-func (s *Service) GetAllCounters() (map[string]int64, error) {
-	return retryValue(func() (map[string]int64, error) {
-		return s.storage.GetAllCounters()
+func (s *Service) GetAllCounters(ctx context.Context) (map[string]int64, error) {
+	return s.storage.GetAllCounters(ctx)
+}
+
+// UpdateHistogram - method for updating a histogram
+// merge the histogram into the stored one
+// if error, return error
+// if success, return nil
+func (s *Service) UpdateHistogram(ctx context.Context, name string, value models.HistogramValue) error {
+	return withRetry(ctx, s.retry, func() error {
+		return s.storage.SetHistogram(ctx, name, value)
 	}, s.logger)
 }
 
+// GetHistogram - method for getting a histogram
+// get the histogram
+// if error, return false
+// if success, return the histogram and true
+func (s *Service) GetHistogram(ctx context.Context, name string) (models.HistogramValue, bool) {
+	return s.storage.GetHistogram(ctx, name)
+}
+
+// GetAllHistograms - method for getting all histograms
+// get all the histograms
+// if error, return error
+// if success, return the histograms
+func (s *Service) GetAllHistograms(ctx context.Context) (map[string]models.HistogramValue, error) {
+	return s.storage.GetAllHistograms(ctx)
+}
+
 // SetLocalStorage - method for setting the local storage
 // set the local storage
 // if error, return error
@@ -193,61 +229,115 @@ func (s *Service) SetLocalStorage(storage repository.Repository) {
 	s.storage = storage
 }
 
+// SetRetryPolicy - method for setting the retry schedule of the writes
+func (s *Service) SetRetryPolicy(policy RetryPolicy) {
+	s.retry = policy
+}
+
 // UpdateMetricBatch - method for updating a batch of metrics
 // update the value of the metrics
+// single histogram values are put into the stored bounds by the storage, in the write
+// the metrics of the caller aren't changed
 // if error, return error
 // if success, return nil
 func (s *Service) UpdateMetricBatch(ctx context.Context, metrics []models.Metrics) error {
-	err := withRetry(func() error {
-		return s.storage.SetMetricBatch(metrics)
-	}, s.logger)
+	for _, m := range metrics {
+		if err := models.ValidateSeries(m.ID, m.Labels); err != nil {
+			return err
+		}
+	}
 
+	changes, err := s.writeBatch(ctx, metrics)
 	if err != nil {
 		return err
 	}
 
-	ids := make([]string, 0, len(metrics))
-	for _, m := range metrics {
-		ids = append(ids, m.ID)
-	}
-
-	s.sendMetricBatchEvent(ctx, ids)
+	s.sendMetricBatchEvent(ctx, metrics, changes)
 
 	return nil
 }
 
 // sendMetricBatchEvent - method for sending a metric batch event
-func (s *Service) sendMetricBatchEvent(ctx context.Context, ids []string) {
-	event := observer.AuditEvent{
-		TimeStamp: int(time.Now().Unix()),
-		Metrics:   ids,
-		IPAddress: idFromContext(ctx),
+// the event has the change of every metric of the batch
+func (s *Service) sendMetricBatchEvent(ctx context.Context, metrics []models.Metrics, changes []observer.MetricChange) {
+	s.notify(ctx, newAuditEvent(ctx, metrics, changes))
+}
+
+// notify - method for passing the event to the registered observers
+// idle observers are skipped
+func (s *Service) notify(ctx context.Context, event observer.AuditEvent) {
+	for _, o := range s.activeObservers() {
+		o.Notify(ctx, event)
 	}
+}
 
+// activeObservers - method for getting the observers that aren't idle
+// an observer without the Idler interface is always active
+func (s *Service) activeObservers() []observer.Observer {
+	active := make([]observer.Observer, 0, len(s.observers))
 	for _, o := range s.observers {
-		o.Notify(ctx, event)
+		if idler, ok := o.(observer.Idler); ok && idler.Idle() {
+			continue
+		}
+		active = append(active, o)
+	}
+	return active
+}
+
+// GetHistory - method for getting the history of a gauge or counter
+// get the samples written between from and to
+// step > 0 aggregates the samples into step-aligned buckets
+// if error, return error
+// if success, return the samples in time order
+func (s *Service) GetHistory(ctx context.Context, mType string, name string, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	if mType != models.Gauge && mType != models.Counter {
+		return nil, fmt.Errorf("%w: %q", ErrNoHistory, mType)
+	}
+
+	samples, err := s.storage.GetHistory(ctx, mType, name, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.Downsample(samples, step), nil
+}
+
+// GetTimes - method for getting the write times of a series
+// the times are known only when the storage tracks them
+// if the storage doesn't, return false
+func (s *Service) GetTimes(ctx context.Context, mType string, name string) (repository.MetricTimes, bool) {
+	reader, ok := s.storage.(repository.TimesReader)
+	if !ok {
+		return repository.MetricTimes{}, false
 	}
+	return reader.GetTimes(ctx, mType, name)
 }
 
 // PingDB - method for pinging the database
 // checks the connection to the database
 // returns error if connection fails, nil otherwise
-func (s *Service) PingDB() error {
-	return s.storage.Ping()
+func (s *Service) PingDB(ctx context.Context) error {
+	return s.storage.Ping(ctx)
 }
 
-// retryIntervals - intervals for retrying failed operations
-var retryIntervals = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
-
 // withRetry - retries a function with exponential backoff
-// retries the function up to 3 times with intervals of 1s, 3s, 5s
+// retries the function according to the policy while the error is temporary
+// ctx - context of the caller, no attempt is made after it is done
+// policy - retry schedule
 // fn - function to retry
 // logger - logger for logging retry attempts
-// returns error if all retries fail, nil on success
-func withRetry(fn func() error, logger *zap.Logger) error {
+// returns error if all retries fail or the context is done, nil on success
+func withRetry(ctx context.Context, policy RetryPolicy, fn func() error, logger *zap.Logger) error {
 	var lastErr error
 
-	for i, interval := range retryIntervals {
+	for attempt := 0; attempt < policy.Attempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr != nil {
+				return fmt.Errorf("operation aborted: %w (last error: %v)", err, lastErr)
+			}
+			return err
+		}
+
 		err := fn()
 		if err == nil {
 			return nil
@@ -255,18 +345,23 @@ func withRetry(fn func() error, logger *zap.Logger) error {
 
 		lastErr = err
 
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, sql.ErrNoRows) || !isTemporary(err) {
 			return err
 		}
 
-		if isTemporary(err) {
-			logger.Sugar().Infof("Temporary error, retrying in %s: %v\n", interval, err)
-		} else {
-			return err
+		if attempt == policy.Attempts-1 {
+			break
 		}
 
-		if i < len(retryIntervals)-1 {
-			time.Sleep(interval)
+		delay := policy.Delay(attempt)
+		logger.Sugar().Infof("Temporary error, retrying in %s: %v\n", delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("operation aborted: %w (last error: %v)", ctx.Err(), lastErr)
 		}
 	}
 
@@ -289,23 +384,6 @@ func isTemporary(err error) bool {
 	return false
 }
 
-// retryValue - retries a function that returns a value with exponential backoff
-// generic function that retries operations returning a value
-// fn - function to retry that returns a value and error
-// logger - logger for logging retry attempts
-// returns the value and error (nil on success)
-func retryValue[T any](fn func() (T, error), logger *zap.Logger) (T, error) {
-	var result T
-
-	err := withRetry(func() error {
-		var err error
-		result, err = fn()
-		return err
-	}, logger)
-
-	return result, err
-}
-
 // RegisterObserver - method for registering an observer
 // adds an observer to the list of observers that will be notified of metric events
 // o - observer to register
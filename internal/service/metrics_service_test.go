@@ -3,10 +3,13 @@ package service
 import (
 	"context"
 	"testing"
+	"time"
 
 	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
 	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -58,11 +61,11 @@ func TestService_UpdateCounter(t *testing.T) {
 
 			var counterName string
 			for _, c := range tt.input {
-				service.UpdateCounter(c.name, c.value)
+				service.UpdateCounter(context.Background(), c.name, c.value)
 				counterName = c.name
 			}
 
-			value, ok := storage.GetCounter(counterName)
+			value, ok := storage.GetCounter(context.Background(), counterName)
 			assert.True(t, ok, "counter should exist")
 			assert.Equal(t, tt.want, value)
 		})
@@ -96,7 +99,7 @@ func TestService_UpdateGauge(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			storage.SetGauge(tt.input.name, tt.input.value)
+			storage.SetGauge(context.Background(), tt.input.name, tt.input.value)
 			value, ok := storage.GetGauge(context.Background(), tt.input.name)
 			assert.True(t, ok, "gauge should exist")
 			assert.Equal(t, tt.input.value, value)
@@ -136,7 +139,7 @@ func TestService_GetGauge(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := repository.NewStorage()
 			service := NewService(storage, &zap.Logger{})
-			service.UpdateGauge(tt.input.name, tt.input.value)
+			service.UpdateGauge(context.Background(), tt.input.name, tt.input.value)
 
 			value, ok := service.GetGauge(context.Background(), tt.key)
 			assert.Equal(t, tt.wantValue, value)
@@ -177,9 +180,9 @@ func TestService_GetCounter(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := repository.NewStorage()
 			service := NewService(storage, &zap.Logger{})
-			service.UpdateCounter(tt.input.name, tt.input.value)
+			service.UpdateCounter(context.Background(), tt.input.name, tt.input.value)
 
-			value, ok := service.GetCounter(tt.key)
+			value, ok := service.GetCounter(context.Background(), tt.key)
 			assert.Equal(t, tt.wantValue, value)
 			assert.Equal(t, tt.wantOk, ok)
 		})
@@ -211,8 +214,8 @@ func TestService_GetAllCounters(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := repository.NewStorage()
 			service := NewService(storage, &zap.Logger{})
-			service.UpdateCounter(tt.mock.name, tt.mock.value)
-			gauges, err := service.GetAllCounters()
+			service.UpdateCounter(context.Background(), tt.mock.name, tt.mock.value)
+			gauges, err := service.GetAllCounters(context.Background())
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want, gauges)
 		})
@@ -244,8 +247,8 @@ func TestService_GetAllGauges(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			storage := repository.NewStorage()
 			service := NewService(storage, &zap.Logger{})
-			service.UpdateGauge(tt.mock.name, tt.mock.value)
-			gauges, err := service.GetAllGauges()
+			service.UpdateGauge(context.Background(), tt.mock.name, tt.mock.value)
+			gauges, err := service.GetAllGauges(context.Background())
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want, gauges)
 		})
@@ -282,3 +285,158 @@ func BenchmarkUpdMetricButch(b *testing.B) {
 		service.UpdateMetricBatch(ctx, metrics)
 	}
 }
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestWithRetry_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+
+	start := time.Now()
+	err := withRetry(ctx, DefaultRetryPolicy, func() error {
+		attempts++
+		cancel()
+		return timeoutErr{}
+	}, zap.NewNop())
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, attempts)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWithRetry_Attempts(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Base: time.Millisecond, Max: time.Millisecond}
+	attempts := 0
+
+	err := withRetry(context.Background(), policy, func() error {
+		attempts++
+		return timeoutErr{}
+	}, zap.NewNop())
+
+	assert.ErrorIs(t, err, timeoutErr{})
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, Base: time.Second, Max: 5 * time.Second}
+
+	assert.Equal(t, time.Second, policy.Delay(0))
+	assert.Equal(t, 2*time.Second, policy.Delay(1))
+	assert.Equal(t, 4*time.Second, policy.Delay(2))
+	assert.Equal(t, 5*time.Second, policy.Delay(3))
+	assert.Equal(t, 5*time.Second, policy.Delay(40))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := policy.Delay(1)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+	}
+
+	assert.NoError(t, policy.Validate())
+	assert.Error(t, RetryPolicy{Attempts: 0, Base: time.Second, Max: time.Second}.Validate())
+	assert.Error(t, RetryPolicy{Attempts: 1, Base: time.Second, Max: time.Millisecond}.Validate())
+	assert.Error(t, RetryPolicy{Attempts: 1, Base: time.Second, Max: time.Second, Jitter: 2}.Validate())
+}
+
+// recordObserver - observer keeping the received events
+type recordObserver struct {
+	events []observer.AuditEvent
+}
+
+func (r *recordObserver) Notify(ctx context.Context, event observer.AuditEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestService_AuditChanges(t *testing.T) {
+	storage := repository.NewStorage()
+	ctx := context.Background()
+	require.NoError(t, storage.SetGauge(ctx, "load", 1.5))
+	require.NoError(t, storage.SetCounter(ctx, "requests", 10))
+
+	service := NewService(storage, zap.NewNop())
+	recorder := &recordObserver{}
+	service.RegisterObserver(recorder)
+
+	ctx = observer.WithRequest(ctx, observer.RequestInfo{
+		IPAddress: "10.0.0.1",
+		Endpoint:  "/updates/",
+		Method:    "POST",
+		RequestID: "req-1",
+		UserAgent: "agent/1.0",
+		Signed:    true,
+	})
+
+	gauge, delta, fresh := 2.5, int64(3), int64(1)
+	require.NoError(t, service.UpdateMetric(ctx, models.Metrics{ID: "load", MType: models.Gauge, Value: &gauge}))
+	require.NoError(t, service.UpdateMetricBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "requests", MType: models.Counter, Delta: &delta},
+		{ID: "errors", MType: models.Counter, Delta: &fresh},
+	}))
+
+	require.Len(t, recorder.events, 2)
+
+	single := recorder.events[0]
+	assert.Equal(t, []string{"load"}, single.Metrics)
+	assert.Equal(t, "10.0.0.1", single.IPAddress)
+	assert.Equal(t, "/updates/", single.Endpoint)
+	assert.Equal(t, "POST", single.Method)
+	assert.Equal(t, "req-1", single.RequestID)
+	assert.Equal(t, "agent/1.0", single.UserAgent)
+	assert.True(t, single.Signed)
+	assert.False(t, single.Encrypted)
+	assert.Equal(t, []observer.MetricChange{
+		{ID: "load", MType: models.Gauge, OldValue: ptr(1.5), NewValue: ptr(2.5)},
+	}, single.Changes)
+
+	batch := recorder.events[1]
+	assert.Equal(t, []string{"requests", "requests", "errors"}, batch.Metrics)
+	assert.Equal(t, []observer.MetricChange{
+		{ID: "requests", MType: models.Counter, OldTotal: ptr(int64(10)), NewTotal: ptr(int64(13))},
+		{ID: "requests", MType: models.Counter, OldTotal: ptr(int64(13)), NewTotal: ptr(int64(16))},
+		{ID: "errors", MType: models.Counter, NewTotal: ptr(int64(1))},
+	}, batch.Changes)
+
+	total, _ := storage.GetCounter(context.Background(), "requests")
+	assert.Equal(t, int64(16), total)
+}
+
+// idleObserver - observer reporting itself idle until it is woken
+type idleObserver struct {
+	recordObserver
+	idle bool
+}
+
+func (o *idleObserver) Idle() bool {
+	return o.idle
+}
+
+func TestService_IdleObservers(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(repository.NewStorage(), zap.NewNop())
+	idle := &idleObserver{idle: true}
+	service.RegisterObserver(idle)
+
+	gauge := 2.5
+	metric := models.Metrics{ID: "load", MType: models.Gauge, Value: &gauge}
+
+	// an idle observer gets no events
+	require.NoError(t, service.UpdateMetric(ctx, metric))
+	assert.Empty(t, idle.events)
+
+	idle.idle = false
+	require.NoError(t, service.UpdateMetric(ctx, metric))
+	require.Len(t, idle.events, 1)
+	assert.Equal(t, []observer.MetricChange{
+		{ID: "load", MType: models.Gauge, OldValue: ptr(2.5), NewValue: ptr(2.5)},
+	}, idle.events[0].Changes)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
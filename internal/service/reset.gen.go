@@ -13,4 +13,7 @@ func (s *Service) Reset() {
 		s.observers = (s.observers)[:0]
 	}
 
+	var z_retry RetryPolicy
+	s.retry = z_retry
+
 }
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy - struct for the retry schedule of the storage writes
+// Attempts - number of attempts, 1 disables retries
+// Base - delay before the first retry, doubled for every next one
+// Max - upper bound of the delay
+// Jitter - share of the delay picked at random, from 0 to 1
+type RetryPolicy struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+	Jitter   float64
+}
+
+// DefaultRetryPolicy - retry schedule used when none is configured
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 3,
+	Base:     time.Second,
+	Max:      5 * time.Second,
+	Jitter:   0.2,
+}
+
+// Validate - method for validating the retry policy
+// if error, return error
+func (p RetryPolicy) Validate() error {
+	if p.Attempts < 1 {
+		return errors.New("retry attempts must be at least 1")
+	}
+	if p.Base <= 0 {
+		return errors.New("retry base delay must be positive")
+	}
+	if p.Max < p.Base {
+		return errors.New("retry max delay must not be less than the base delay")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Delay - method for getting the delay before a retry
+// attempt - number of the failed attempt, starting from 0
+// the delay is Base * 2^attempt capped by Max, minus a random share of up to Jitter
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Base
+	for i := 0; i < attempt && delay < p.Max; i++ {
+		delay *= 2
+	}
+	if delay > p.Max {
+		delay = p.Max
+	}
+
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
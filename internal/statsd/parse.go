@@ -0,0 +1,96 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Constants for the StatsD metric types
+const (
+	TypeCounter   = "c"
+	TypeGauge     = "g"
+	TypeTimer     = "ms"
+	TypeHistogram = "h"
+)
+
+// Metric - struct for one parsed StatsD line
+// Name - metric name
+// Labels - DogStatsD tags, nil if the line has none
+// Type - metric type, one of the Type constants
+// Value - metric value
+// Relative - whether a gauge value is a signed change of the current value
+// Rate - sample rate, 1 if the line has none
+type Metric struct {
+	Name     string
+	Labels   map[string]string
+	Type     string
+	Value    float64
+	Relative bool
+	Rate     float64
+}
+
+// ParseLine - parses name:value|type[|@rate][|#tag:value,...]
+// a value that isn't finite is invalid
+// if error, return error
+func ParseLine(line string) (Metric, error) {
+	m := Metric{Rate: 1}
+
+	pipe := strings.IndexByte(line, '|')
+	colon := strings.LastIndexByte(line[:max(pipe, 0)], ':')
+	if colon <= 0 {
+		return m, fmt.Errorf("invalid line %q", line)
+	}
+	m.Name = line[:colon]
+
+	parts := strings.Split(line[colon+1:], "|")
+	value := parts[0]
+	m.Type = parts[1]
+
+	switch m.Type {
+	case TypeCounter, TypeTimer, TypeHistogram:
+	case TypeGauge:
+		m.Relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	default:
+		return m, fmt.Errorf("unsupported metric type %q", m.Type)
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return m, fmt.Errorf("invalid value %q", value)
+	}
+	m.Value = v
+
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return m, fmt.Errorf("invalid sample rate %q", p)
+			}
+			m.Rate = rate
+		case strings.HasPrefix(p, "#"):
+			m.Labels = parseTags(p[1:])
+		default:
+			return m, fmt.Errorf("unknown section %q", p)
+		}
+	}
+
+	return m, nil
+}
+
+// parseTags - parses DogStatsD tags, a tag without value gets an empty one
+func parseTags(s string) map[string]string {
+	labels := make(map[string]string)
+
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(tag, ":")
+		labels[k] = v
+	}
+
+	return labels
+}
@@ -0,0 +1,74 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Metric
+		wantErr bool
+	}{
+		{
+			name: "Counter",
+			line: "jobs:3|c",
+			want: Metric{Name: "jobs", Type: TypeCounter, Value: 3, Rate: 1},
+		},
+		{
+			name: "Counter with sample rate and tags",
+			line: "jobs:1|c|@0.1|#host:web03,region:eu",
+			want: Metric{Name: "jobs", Labels: map[string]string{"host": "web03", "region": "eu"}, Type: TypeCounter, Value: 1, Rate: 0.1},
+		},
+		{
+			name: "Relative gauge",
+			line: "queue.size:-4|g",
+			want: Metric{Name: "queue.size", Type: TypeGauge, Value: -4, Relative: true, Rate: 1},
+		},
+		{
+			name: "Timer",
+			line: "db.query:12.5|ms",
+			want: Metric{Name: "db.query", Type: TypeTimer, Value: 12.5, Rate: 1},
+		},
+		{
+			name:    "Set is not supported",
+			line:    "users:42|s",
+			wantErr: true,
+		},
+		{
+			name:    "Missing type",
+			line:    "jobs:1",
+			wantErr: true,
+		},
+		{
+			name:    "Counter is not finite",
+			line:    "jobs:NaN|c",
+			wantErr: true,
+		},
+		{
+			name:    "Relative gauge is not finite",
+			line:    "queue.size:+Inf|g",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid sample rate",
+			line:    "jobs:1|c|@2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
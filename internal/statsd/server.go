@@ -0,0 +1,255 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/observer"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"go.uber.org/zap"
+)
+
+// maxPacketSize - the largest UDP payload read at once
+const maxPacketSize = 65535
+
+// TimerBounds - bucket bounds in milliseconds of the histograms built from timers
+var TimerBounds = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Server - struct for the StatsD UDP listener
+// packets are aggregated in memory and pushed into the service on every flush
+// counters become counters, gauges become gauges, timers become histograms
+type Server struct {
+	addr          string
+	flushInterval time.Duration
+	service       service.MetricsService
+	logger        *zap.Logger
+
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]gaugeValue
+	timers   map[string]*models.HistogramValue
+}
+
+// gaugeValue - aggregated gauge of one flush interval
+// relative gauges without an absolute value are added to the stored gauge in the write
+type gaugeValue struct {
+	value    float64
+	relative bool
+}
+
+// NewServer - method for creating a new StatsD server
+func NewServer(addr string, flushInterval time.Duration, service service.MetricsService, logger *zap.Logger) *Server {
+	s := &Server{
+		addr:          addr,
+		flushInterval: flushInterval,
+		service:       service,
+		logger:        logger,
+	}
+	s.reset()
+
+	return s
+}
+
+// ListenAndServe - method for listening on the UDP address until the context is done
+// the aggregated metrics are flushed once more before return
+// if error, return error
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+
+	return s.Serve(ctx, conn)
+}
+
+// Serve - method for reading packets from the connection until the context is done
+// the connection is closed on return
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	flushDone := make(chan struct{})
+	go func() {
+		defer close(flushDone)
+		s.runFlush(ctx)
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			s.logger.Warn("Failed to read statsd packet", zap.Error(err))
+			continue
+		}
+
+		s.HandlePacket(buf[:n])
+	}
+
+	<-flushDone
+	return nil
+}
+
+// runFlush - method for flushing on every tick and once after the context is done
+func (s *Server) runFlush(ctx context.Context) {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush(ctx)
+		case <-ctx.Done():
+			s.Flush(context.WithoutCancel(ctx))
+			return
+		}
+	}
+}
+
+// HandlePacket - method for aggregating the lines of one packet
+// invalid lines are logged and skipped
+func (s *Server) HandlePacket(packet []byte) {
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		m, err := ParseLine(line)
+		if err == nil {
+			err = models.ValidateSeries(m.Name, m.Labels)
+		}
+		if err != nil {
+			s.logger.Debug("Skipping invalid statsd line", zap.String("line", line), zap.Error(err))
+			continue
+		}
+
+		if err := s.add(m); err != nil {
+			s.logger.Debug("Skipping invalid statsd line", zap.String("line", line), zap.Error(err))
+		}
+	}
+}
+
+// add - method for adding a metric to the current interval
+// counters are scaled by the sample rate, timers are observed once per line
+// if a timer value isn't finite, return error
+func (s *Server) add(m Metric) error {
+	key := models.SeriesKey(m.Name, m.Labels)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m.Type {
+	case TypeCounter:
+		s.counters[key] += m.Value / m.Rate
+	case TypeGauge:
+		g, ok := s.gauges[key]
+		if !m.Relative {
+			s.gauges[key] = gaugeValue{value: m.Value}
+			return nil
+		}
+		if !ok {
+			g.relative = true
+		}
+		g.value += m.Value
+		s.gauges[key] = g
+	case TypeTimer, TypeHistogram:
+		h, ok := s.timers[key]
+		if !ok {
+			h = models.NewHistogram(TimerBounds)
+		}
+		if err := h.Observe(m.Value); err != nil {
+			return err
+		}
+		s.timers[key] = h
+	}
+	return nil
+}
+
+// Flush - method for pushing the aggregated metrics into the service
+// the aggregation is reset even if the push fails
+// if error, return error
+func (s *Server) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	counters, gauges, timers := s.counters, s.gauges, s.timers
+	s.reset()
+	s.mu.Unlock()
+
+	metrics := make([]models.Metrics, 0, len(counters)+len(gauges)+len(timers))
+
+	for key, v := range counters {
+		delta := int64(math.Round(v))
+		if delta == 0 {
+			continue
+		}
+		m, err := metricFromKey(key, models.Counter)
+		if err != nil {
+			continue
+		}
+		m.Delta = &delta
+		metrics = append(metrics, m)
+	}
+
+	for key, g := range gauges {
+		m, err := metricFromKey(key, models.Gauge)
+		if err != nil {
+			continue
+		}
+		value := g.value
+		if g.relative {
+			m.GaugeDelta = &value
+		} else {
+			m.Value = &value
+		}
+		metrics = append(metrics, m)
+	}
+
+	for key, h := range timers {
+		m, err := metricFromKey(key, models.Histogram)
+		if err != nil {
+			continue
+		}
+		m.Histogram = h
+		metrics = append(metrics, m)
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	ctx = observer.WithRequest(ctx, observer.RequestInfo{IPAddress: "statsd", Endpoint: "statsd"})
+	if err := s.service.UpdateMetricBatch(ctx, metrics); err != nil {
+		s.logger.Error("Failed to flush statsd metrics", zap.Int("metrics", len(metrics)), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// reset - method for starting a new aggregation interval
+func (s *Server) reset() {
+	s.counters = make(map[string]float64)
+	s.gauges = make(map[string]gaugeValue)
+	s.timers = make(map[string]*models.HistogramValue)
+}
+
+// metricFromKey - method for building a metric from a series key
+func metricFromKey(key string, mType string) (models.Metrics, error) {
+	name, labels, err := models.ParseSeriesKey(key)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	return models.Metrics{ID: name, MType: mType, Labels: labels}, nil
+}
@@ -0,0 +1,54 @@
+package statsd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	models "github.com/makimaki04/go-metrics-agent.git/internal/model"
+	"github.com/makimaki04/go-metrics-agent.git/internal/repository"
+	"github.com/makimaki04/go-metrics-agent.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer_Flush(t *testing.T) {
+	ctx := context.Background()
+	storage := repository.NewStorage()
+	svc := service.NewService(storage, zap.NewNop())
+	require.NoError(t, svc.UpdateGauge(context.Background(), "queue", 10))
+
+	s := NewServer(":0", time.Second, svc, zap.NewNop())
+	s.HandlePacket([]byte("jobs:1|c|@0.5\njobs:3|c\nqueue:+5|g\nqueue:-2|g\nqueue:NaN|g\nfresh:-3|g\nbroken\ntemp:21|g\ndb:12|ms\ndb:700|ms|#host:web03"))
+	require.NoError(t, s.Flush(ctx))
+
+	jobs, ok := storage.GetCounter(context.Background(), "jobs")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), jobs)
+
+	queue, _ := storage.GetGauge(ctx, "queue")
+	assert.Equal(t, float64(13), queue)
+
+	// a relative gauge of a new series starts from zero
+	fresh, ok := storage.GetGauge(ctx, "fresh")
+	assert.True(t, ok)
+	assert.Equal(t, float64(-3), fresh)
+
+	temp, _ := storage.GetGauge(ctx, "temp")
+	assert.Equal(t, float64(21), temp)
+
+	db, ok := storage.GetHistogram(ctx, "db")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), db.Count)
+	assert.Equal(t, uint64(1), db.Counts[3])
+
+	tagged, ok := storage.GetHistogram(ctx, models.SeriesKey("db", map[string]string{"host": "web03"}))
+	require.True(t, ok)
+	assert.Equal(t, float64(700), tagged.Sum)
+
+	// the next interval starts empty
+	require.NoError(t, s.Flush(ctx))
+	jobs, _ = storage.GetCounter(context.Background(), "jobs")
+	assert.Equal(t, int64(5), jobs)
+}